@@ -2,20 +2,279 @@
 package app_structs
 
 import (
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"strconv"
-	// Import strings for potential condition handling
+	"strings"
+
+	"gopkg.in/yaml.v2"
 )
 
 type FileFormat struct {
 	Name             string            `yaml:"name"`
 	Description      string            `yaml:"description"`
 	VersionFieldPath string            `yaml:"version_field,omitempty"` // e.g., "Header.Version"
+	Endian           string            `yaml:"endian,omitempty"`        // Default byte order: "little" (default) or "big"
+	Layout           []string          `yaml:"layout,omitempty"`        // Top-level structs of a file, in order (e.g., FileHeader, InfoHeader, ImageData)
+	ExpressionMode   string            `yaml:"expressions,omitempty"`   // How expressions are compiled: "govaluate" (default) or "native"
 	Structs          map[string]Struct `yaml:"structs"`
+	// Functions declares Go functions that expressions can call, keyed by the
+	// name used in expressions (e.g., "AlignTo").
+	Functions map[string]Function `yaml:"functions,omitempty"`
+	// Enums declares named sets of values that numeric fields can refer to
+	// with their enum attribute, keyed by the generated Go type name.
+	Enums map[string]Enum `yaml:"enums,omitempty"`
+	// StructOrder lists the struct names in the order they are declared in the
+	// YAML source. Maps don't keep order, so it is captured separately when
+	// unmarshaling and used when marshaling and generating code.
+	StructOrder []string `yaml:"-"`
+}
+
+// UnmarshalYAML decodes the format and records the declaration order of its structs.
+func (ff *FileFormat) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain FileFormat // plain has no UnmarshalYAML method, avoiding recursion
+	if err := unmarshal((*plain)(ff)); err != nil {
+		return err
+	}
+	var ordered struct {
+		Structs yaml.MapSlice `yaml:"structs"`
+	}
+	if err := unmarshal(&ordered); err != nil {
+		return err
+	}
+	ff.StructOrder = mapSliceKeys(ordered.Structs)
+	return nil
+}
+
+// MarshalYAML encodes the format with its structs in declaration order
+// instead of yaml.v2's alphabetical map order.
+func (ff FileFormat) MarshalYAML() (interface{}, error) {
+	type plain FileFormat // plain has no MarshalYAML method, avoiding recursion
+	data, err := yaml.Marshal(plain(ff))
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	structs := make(yaml.MapSlice, 0, len(ff.Structs))
+	for _, name := range ff.OrderedStructNames() {
+		structs = append(structs, yaml.MapItem{Key: name, Value: ff.Structs[name]})
+	}
+	for i := range doc {
+		if doc[i].Key == "structs" {
+			doc[i].Value = structs
+		}
+	}
+	return doc, nil
+}
+
+// ParseStructOrder returns the struct names of a YAML format definition in
+// declaration order. It is used where the YAML is decoded through a generic
+// map, which loses the order.
+func ParseStructOrder(data []byte) ([]string, error) {
+	var ordered struct {
+		Structs yaml.MapSlice `yaml:"structs"`
+	}
+	if err := yaml.Unmarshal(data, &ordered); err != nil {
+		return nil, err
+	}
+	return mapSliceKeys(ordered.Structs), nil
+}
+
+func mapSliceKeys(items yaml.MapSlice) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, fmt.Sprintf("%v", item.Key))
+	}
+	return keys
+}
+
+// OrderedStructNames returns the names of all structs in declaration order.
+// Structs missing from StructOrder (e.g. added programmatically) follow in
+// alphabetical order, so the result is always deterministic.
+func (ff *FileFormat) OrderedStructNames() []string {
+	names := make([]string, 0, len(ff.Structs))
+	seen := make(map[string]bool, len(ff.Structs))
+	for _, name := range ff.StructOrder {
+		if _, ok := ff.Structs[name]; ok && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	var rest []string
+	for name := range ff.Structs {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// Expression modes supported by the expressions attribute.
+const (
+	// ExpressionModeGovaluate evaluates length/count expressions at runtime
+	// with govaluate; generated code imports FIG/utils.
+	ExpressionModeGovaluate = "govaluate"
+	// ExpressionModeNative translates length/count expressions and conditions
+	// to plain Go at generation time; generated code has no FIG dependency.
+	ExpressionModeNative = "native"
+)
+
+// NormalizeExpressionMode maps an expressions attribute to one of the
+// ExpressionMode constants. An empty value stays empty (govaluate).
+func NormalizeExpressionMode(mode string) (string, error) {
+	switch normalized := strings.ToLower(strings.TrimSpace(mode)); normalized {
+	case "", ExpressionModeGovaluate, ExpressionModeNative:
+		return normalized, nil
+	}
+	return "", fmt.Errorf("invalid expressions mode '%s': must be '%s' or '%s'", mode, ExpressionModeGovaluate, ExpressionModeNative)
+}
+
+// IsNativeMode reports whether expressions are translated to plain Go code.
+func (ff *FileFormat) IsNativeMode() bool {
+	return ff.ExpressionMode == ExpressionModeNative
+}
+
+// FileTypeName is the name of the generated type holding the structs listed in Layout.
+const FileTypeName = "File"
+
+// HasLayout reports whether the format declares a top-level file layout.
+func (ff *FileFormat) HasLayout() bool {
+	return len(ff.Layout) > 0
+}
+
+// HasConstantFields reports whether any field of the format declares a
+// constant Value, whose mismatches Read reports with a ValueMismatchError.
+func (ff *FileFormat) HasConstantFields() bool {
+	for _, structDef := range ff.Structs {
+		for _, field := range structDef.Fields {
+			if field.Value != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsStructType reports whether typeName names a struct defined in this format,
+// i.e. a field of that type is read and written through the struct's own methods.
+func (ff *FileFormat) IsStructType(typeName string) bool {
+	_, ok := ff.Structs[typeName]
+	return ok
+}
+
+// Function is a Go function that expressions can call. It takes int64
+// arguments and returns an int64, so it works in both expression modes.
+type Function struct {
+	// Go is the function as called from the generated package: a function
+	// defined in it (e.g., "alignTo", in a hand-written file) or a qualified
+	// name from Import (e.g., "fmtutil.AlignTo").
+	Go     string `yaml:"go"`
+	Import string `yaml:"import,omitempty"` // Import path of the package providing Go, if any
+	Args   int    `yaml:"args"`             // Number of arguments
+}
+
+// Enum is a set of named values of an integer type. A field referring to it
+// gets the generated Go type of the enum, which has a constant per value
+// (named <Enum><Value>) and a String method.
+type Enum struct {
+	Type        string      `yaml:"type"` // Underlying integer type (e.g., "uint32")
+	Description string      `yaml:"description,omitempty"`
+	Strict      bool        `yaml:"strict,omitempty"` // Read rejects values that are not declared
+	Values      []EnumValue `yaml:"values"`
+}
+
+// HasValue reports whether the enum declares the value n.
+func (e Enum) HasValue(n int64) bool {
+	for _, v := range e.Values {
+		if v.Value == n {
+			return true
+		}
+	}
+	return false
+}
+
+// EnumValue is a named value of an Enum.
+type EnumValue struct {
+	Name        string `yaml:"name"`
+	Value       int64  `yaml:"value"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// ConstName returns the name of the Go constant generated for value v of the
+// enum enumName (e.g., "CompressionRGB").
+func (v EnumValue) ConstName(enumName string) string {
+	return enumName + v.Name
 }
 
 type Struct struct {
-	Fields []Field `yaml:"fields"`
+	// Context declares the type of ctx passed to the struct's Read method.
+	// Without it, Read takes ctx interface{}.
+	Context Context `yaml:"context,omitempty"`
+	Fields  []Field `yaml:"fields"`
+}
+
+// Context is the declared type of a struct's read context: either the name of
+// a struct of the format (or File), or a list of fields for which a
+// <Struct>Context type is generated.
+type Context struct {
+	Type   string
+	Fields []Field
+}
+
+// UnmarshalYAML accepts a type name ("context: InfoHeader") or a list of fields.
+func (c *Context) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var typeName string
+	if err := unmarshal(&typeName); err == nil {
+		c.Type = typeName
+		return nil
+	}
+	return unmarshal(&c.Fields)
+}
+
+// MarshalYAML encodes the context in the form it was declared.
+func (c Context) MarshalYAML() (interface{}, error) {
+	if c.Type != "" {
+		return c.Type, nil
+	}
+	return c.Fields, nil
+}
+
+// IsZero reports whether no context is declared (used by yaml's omitempty).
+func (c Context) IsZero() bool {
+	return c.Type == "" && len(c.Fields) == 0
+}
+
+// ContextTypeSuffix is appended to a struct name to name the type generated
+// for a context declared as a list of fields.
+const ContextTypeSuffix = "Context"
+
+// ContextType returns the name of the declared context type of a struct:
+// the context's struct name, structName+ContextTypeSuffix for a list of
+// fields, or "" if the struct declares no context.
+func (ff *FileFormat) ContextType(structName string) string {
+	context := ff.Structs[structName].Context
+	if len(context.Fields) > 0 {
+		return structName + ContextTypeSuffix
+	}
+	return context.Type
+}
+
+// TypeFields returns the fields of a struct of the format or of a generated
+// context type, and whether typeName is one of them.
+func (ff *FileFormat) TypeFields(typeName string) ([]Field, bool) {
+	if structDef, ok := ff.Structs[typeName]; ok {
+		return structDef.Fields, true
+	}
+	owner := strings.TrimSuffix(typeName, ContextTypeSuffix)
+	if structDef, ok := ff.Structs[owner]; ok && owner != typeName && len(structDef.Context.Fields) > 0 {
+		return structDef.Context.Fields, true
+	}
+	return nil, false
 }
 
 type Field struct {
@@ -26,7 +285,162 @@ type Field struct {
 	// It's used for fixed-size strings, byte slices, or dynamic lengths
 	Length    string `yaml:"length,omitempty"`
 	Condition string `yaml:"condition,omitempty"` // Condition for reading/writing
-	Tags        string `yaml:"tags,omitempty"`
+	Tags      string `yaml:"tags,omitempty"`
+	Endian    string `yaml:"endian,omitempty"` // Overrides FileFormat.Endian for this field
+	// Count makes a slice field (e.g. "[]uint16", "[]ComponentSpec") repeated:
+	// a number, an expression (e.g., "s.NumberOfComponents"), or "eof" to read
+	// elements until the end of the stream.
+	Count string `yaml:"count,omitempty"`
+	// Pad is the byte used by Write to fill string/[]byte values shorter than
+	// Length: a number (e.g., "0x20") or a single character (e.g., " "). Without
+	// it (or the padded encoding, which pads with 0x00 by default), Write
+	// rejects short values.
+	Pad string `yaml:"pad,omitempty"`
+	// Truncate makes Write cut values longer than Length instead of returning an error.
+	Truncate bool `yaml:"truncate,omitempty"`
+	// Encoding selects how a string is stored (see the String encodings below).
+	// cstring and pascal encodings don't need a Length.
+	Encoding string `yaml:"encoding,omitempty"`
+	// MaxLength optionally limits the number of bytes of a cstring/pascal string.
+	MaxLength int `yaml:"max_length,omitempty"`
+	// Enum names an entry of FileFormat.Enums. The field (or, for repeated
+	// fields, each element) then has the enum's Go type instead of Type.
+	Enum string `yaml:"enum,omitempty"`
+	// Bits names ranges of bits of an unsigned integer field, which get
+	// accessor methods on the struct (see BitField).
+	Bits []BitField `yaml:"bits,omitempty"`
+	// Value makes the field a constant, such as a signature or magic number:
+	// a number for numeric fields (e.g., "0xFFD8"), the text of a string field
+	// (e.g., "BM"), or the bytes of a []byte field, as text or in hex (e.g.,
+	// "0x89504E470D0A1A0A"). Read returns a ValueMismatchError when the
+	// data holds anything else, and Write always writes it.
+	Value string `yaml:"value,omitempty"`
+	// Magic is an alias of Value; the validator moves it into Value.
+	Magic string `yaml:"magic,omitempty"`
+}
+
+// BitField is a named range of bits packed into an unsigned integer field.
+// The generator emits a getter (e.g., Horizontal()) and a setter (e.g.,
+// SetHorizontal(v)) for it on the struct; the field itself keeps the packed
+// value that Read and Write process.
+type BitField struct {
+	Name string `yaml:"name"`
+	// Bits is the range of bits, bit 0 being the least significant: "4-7",
+	// or a single bit such as "3", whose accessors use bool.
+	Bits        string `yaml:"bits"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// Range parses Bits into its lowest and highest bit.
+func (b BitField) Range() (lo, hi int, err error) {
+	from, to, isRange := strings.Cut(b.Bits, "-")
+	lo, err = strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid bit range '%s': use '<lowest>-<highest>' (e.g., '4-7') or a single bit", b.Bits)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+			return 0, 0, fmt.Errorf("invalid bit range '%s': use '<lowest>-<highest>' (e.g., '4-7') or a single bit", b.Bits)
+		}
+	}
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return lo, hi, nil
+}
+
+// IsFlag reports whether the bit field is a single bit.
+func (b BitField) IsFlag() bool {
+	lo, hi, err := b.Range()
+	return err == nil && lo == hi
+}
+
+// String encodings supported by the encoding attribute.
+const (
+	EncodingCString  = "cstring"  // Bytes followed by a NUL terminator
+	EncodingPascal8  = "pascal8"  // uint8 length prefix, then the bytes
+	EncodingPascal16 = "pascal16" // uint16 length prefix, then the bytes
+	EncodingPascal32 = "pascal32" // uint32 length prefix, then the bytes
+	EncodingPadded   = "padded"   // Fixed Length, trailing Pad bytes stripped on Read
+)
+
+// NormalizeEncoding maps the accepted spellings of an encoding attribute to
+// one of the Encoding constants. An empty value stays empty (raw fixed-length bytes).
+func NormalizeEncoding(encoding string) (string, error) {
+	switch normalized := strings.ToLower(strings.TrimSpace(encoding)); normalized {
+	case "", EncodingCString, EncodingPascal8, EncodingPascal16, EncodingPascal32, EncodingPadded:
+		return normalized, nil
+	case "pascal":
+		return EncodingPascal8, nil
+	}
+	return "", fmt.Errorf("invalid encoding '%s': must be one of %s, %s, %s, %s, %s", encoding,
+		EncodingCString, EncodingPascal8, EncodingPascal16, EncodingPascal32, EncodingPadded)
+}
+
+// LengthPrefixType returns the Go type of the length prefix of a pascal
+// string ("uint8", "uint16" or "uint32"), or "" for other encodings.
+func (f *Field) LengthPrefixType() string {
+	switch f.Encoding {
+	case EncodingPascal8:
+		return "uint8"
+	case EncodingPascal16:
+		return "uint16"
+	case EncodingPascal32:
+		return "uint32"
+	}
+	return ""
+}
+
+// IsSelfDelimited returns true if the string's size is stored in the data
+// itself (cstring or pascal), so it needs no Length.
+func (f *Field) IsSelfDelimited() bool {
+	return f.Encoding == EncodingCString || f.LengthPrefixType() != ""
+}
+
+// CountEOF is the Count value that repeats a field until the end of the stream.
+const CountEOF = "eof"
+
+// IsNumericType reports whether t is a fixed-size numeric type that can be
+// read and written directly with encoding/binary.
+func IsNumericType(t string) bool {
+	switch t {
+	case "uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64", "float32", "float64":
+		return true
+	}
+	return false
+}
+
+// Supported values for the endian attribute.
+const (
+	EndianLittle = "little"
+	EndianBig    = "big"
+)
+
+// NormalizeEndian maps the accepted spellings of an endian attribute to
+// EndianLittle or EndianBig. An empty value stays empty (meaning "inherit").
+func NormalizeEndian(endian string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(endian)) {
+	case "":
+		return "", nil
+	case "little", "le", "littleendian", "little-endian":
+		return EndianLittle, nil
+	case "big", "be", "bigendian", "big-endian":
+		return EndianBig, nil
+	}
+	return "", fmt.Errorf("invalid endian '%s': must be 'big' or 'little'", endian)
+}
+
+// ByteOrder returns the effective endianness of the field: its own Endian
+// attribute if set, otherwise the format-level default, otherwise little-endian.
+func (f *Field) ByteOrder(formatEndian string) string {
+	if f.Endian != "" {
+		return f.Endian
+	}
+	if formatEndian != "" {
+		return formatEndian
+	}
+	return EndianLittle
 }
 
 func (f *Field) GetLength() (int, error) {
@@ -53,6 +467,129 @@ func (f *Field) IsExpressionLength() bool {
 	return err != nil
 }
 
+// IsRepeated returns true if the field is a slice read element by element (it has a Count)
+func (f *Field) IsRepeated() bool {
+	return f.Count != ""
+}
+
+// IsCountToEOF returns true if the field repeats until the end of the stream
+func (f *Field) IsCountToEOF() bool {
+	return f.Count == CountEOF
+}
+
+// IsExpressionCount returns true if the count is an expression (not a fixed number or "eof")
+func (f *Field) IsExpressionCount() bool {
+	if f.Count == "" || f.Count == CountEOF {
+		return false
+	}
+	_, err := strconv.Atoi(f.Count)
+	return err != nil
+}
+
+// ElementType returns the element type of a slice field (e.g. "uint16" for "[]uint16")
+func (f *Field) ElementType() string {
+	return strings.TrimPrefix(f.Type, "[]")
+}
+
+// GoType returns the Go type of the field: Type, or the enum type when the
+// field refers to an enum (e.g., "Compression" or "[]Compression").
+func (f *Field) GoType() string {
+	if f.Enum == "" {
+		return f.Type
+	}
+	if f.IsRepeated() {
+		return "[]" + f.Enum
+	}
+	return f.Enum
+}
+
+// GoElementType is the Go counterpart of ElementType, like GoType.
+func (f *Field) GoElementType() string {
+	return strings.TrimPrefix(f.GoType(), "[]")
+}
+
+// ValueBytes returns the bytes of the constant Value of a string or []byte
+// field. A []byte value starting with "0x" is hex.
+func (f *Field) ValueBytes() ([]byte, error) {
+	digits, isHex := strings.CutPrefix(f.Value, "0x")
+	if f.Type != "[]byte" || !isHex {
+		return []byte(f.Value), nil
+	}
+	value, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("value '%s' is not a valid hex byte string", f.Value)
+	}
+	return value, nil
+}
+
+// ValueLiteral returns the untyped Go literal of the field's constant Value
+// (e.g., "0xFFD8", "-2", `"BM"`), or an error if Value doesn't fit the type.
+func (f *Field) ValueLiteral() (string, error) {
+	value := f.Value
+	switch {
+	case f.Type == "string" || f.Type == "[]byte":
+		b, err := f.ValueBytes()
+		if err != nil {
+			return "", err
+		}
+		return strconv.Quote(string(b)), nil
+	case !IsNumericType(f.Type):
+		return "", fmt.Errorf("a constant value requires a numeric, string or []byte type, not '%s'", f.Type)
+	}
+	value = strings.TrimSpace(value)
+	bitSize, _ := strconv.Atoi(strings.TrimLeft(f.Type, "uintfloa"))
+	switch {
+	case strings.HasPrefix(f.Type, "float"):
+		v, err := strconv.ParseFloat(value, bitSize)
+		if err != nil {
+			return "", fmt.Errorf("value '%s' is not a valid %s", f.Value, f.Type)
+		}
+		return strconv.FormatFloat(v, 'g', -1, bitSize), nil
+	case strings.HasPrefix(f.Type, "u"):
+		v, err := strconv.ParseUint(value, 0, bitSize)
+		if err != nil {
+			return "", fmt.Errorf("value '%s' is not a valid %s", f.Value, f.Type)
+		}
+		if v >= 0x100 { // Wide values, such as markers, read better in hex
+			return fmt.Sprintf("0x%X", v), nil
+		}
+		return strconv.FormatUint(v, 10), nil
+	}
+	v, err := strconv.ParseInt(value, 0, bitSize)
+	if err != nil {
+		return "", fmt.Errorf("value '%s' is not a valid %s", f.Value, f.Type)
+	}
+	return strconv.FormatInt(v, 10), nil
+}
+
+// ParsePadByte parses a pad attribute: a number between 0 and 255 (decimal,
+// hex or octal) or a single character. An empty value means 0x00.
+func ParsePadByte(pad string) (byte, error) {
+	if pad == "" {
+		return 0, nil
+	}
+	if value, err := strconv.ParseUint(strings.TrimSpace(pad), 0, 8); err == nil {
+		return byte(value), nil
+	}
+	if len(pad) == 1 {
+		return pad[0], nil
+	}
+	return 0, fmt.Errorf("invalid pad '%s': must be a byte value (e.g., 0x20) or a single character", pad)
+}
+
+// PadByte returns the byte used to pad the field on Write (0x00 if unset or invalid)
+func (f *Field) PadByte() byte {
+	pad, _ := ParsePadByte(f.Pad)
+	return pad
+}
+
+// IsPadded reports whether Write pads values shorter than Length, which the
+// pad attribute or the padded encoding request. Read then strips the trailing
+// pad bytes of strings, so they read back as written.
+func (f *Field) IsPadded() bool {
+	return f.Pad != "" || f.Encoding == EncodingPadded
+}
+
 // IsConditional returns true if the field has a condition
 func (f *Field) IsConditional() bool {
 	return f.Condition != ""
@@ -67,9 +604,9 @@ func (f *Field) Validate() error {
 		return fmt.Errorf("field %s: type cannot be empty", f.Name)
 	}
 
-	// For []byte and string types, Length is required
+	// For []byte and string types, Length is required unless the encoding stores the size
 	if f.Type == "[]byte" || f.Type == "string" {
-		if f.Length == "" {
+		if f.Length == "" && !f.IsSelfDelimited() {
 			return fmt.Errorf("field %s (%s): length must be specified", f.Name, f.Type)
 		}
 	}
@@ -0,0 +1,266 @@
+package app_structs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestNormalizeEndian(t *testing.T) {
+	tests := []struct {
+		endian  string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"little", EndianLittle, false},
+		{" LE ", EndianLittle, false},
+		{"Little-Endian", EndianLittle, false},
+		{"BIG", EndianBig, false},
+		{"be", EndianBig, false},
+		{"bigendian", EndianBig, false},
+		{"middle", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeEndian(tt.endian)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeEndian(%q) = %q, %v, want %q, error: %v", tt.endian, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFieldByteOrder(t *testing.T) {
+	tests := []struct {
+		field, format, want string
+	}{
+		{"", "", EndianLittle},
+		{"", EndianBig, EndianBig},
+		{EndianLittle, EndianBig, EndianLittle},
+		{EndianBig, "", EndianBig},
+	}
+	for _, tt := range tests {
+		f := Field{Name: "X", Type: "uint16", Endian: tt.field}
+		if got := f.ByteOrder(tt.format); got != tt.want {
+			t.Errorf("ByteOrder() of endian %q in a %q format = %q, want %q", tt.field, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestParsePadByte(t *testing.T) {
+	tests := []struct {
+		pad     string
+		want    byte
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0x20", 0x20, false},
+		{"255", 0xff, false},
+		{" ", ' ', false},
+		{"*", '*', false},
+		{"256", 0, true},
+		{"ab", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePadByte(tt.pad)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParsePadByte(%q) = %#x, %v, want %#x, error: %v", tt.pad, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizeEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		want     string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"CString", EncodingCString, false},
+		{"pascal", EncodingPascal8, false},
+		{" Pascal16 ", EncodingPascal16, false},
+		{"pascal32", EncodingPascal32, false},
+		{"PADDED", EncodingPadded, false},
+		{"utf9", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeEncoding(tt.encoding)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeEncoding(%q) = %q, %v, want %q, error: %v", tt.encoding, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+const orderedYAML = `name: Ordered
+structs:
+  Zeta:
+    fields:
+      - {name: A, type: Mid}
+  Alpha:
+    fields:
+      - {name: B, type: uint8}
+  Mid:
+    fields:
+      - {name: C, type: uint8}
+`
+
+func TestFileFormatStructOrder(t *testing.T) {
+	var ff FileFormat
+	if err := yaml.Unmarshal([]byte(orderedYAML), &ff); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Zeta", "Alpha", "Mid"}
+	if got := ff.OrderedStructNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OrderedStructNames() = %v, want %v", got, want)
+	}
+	if got, err := ParseStructOrder([]byte(orderedYAML)); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseStructOrder() = %v, %v, want %v", got, err, want)
+	}
+
+	data, err := yaml.Marshal(ff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeta, alpha, mid := strings.Index(string(data), "Zeta:"), strings.Index(string(data), "Alpha:"), strings.Index(string(data), "Mid:")
+	if zeta < 0 || !(zeta < alpha && alpha < mid) {
+		t.Errorf("Marshal() doesn't keep the declaration order:\n%s", data)
+	}
+
+	// Structs missing from StructOrder follow in alphabetical order
+	ff.Structs["Beta"] = Struct{}
+	ff.Structs["Aardvark"] = Struct{}
+	want = append(want, "Aardvark", "Beta")
+	if got := ff.OrderedStructNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OrderedStructNames() = %v, want %v", got, want)
+	}
+}
+
+func TestNormalizeExpressionMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"Native", ExpressionModeNative, false},
+		{" govaluate ", ExpressionModeGovaluate, false},
+		{"compiled", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeExpressionMode(tt.mode)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeExpressionMode(%q) = %q, %v, want %q, error: %v", tt.mode, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+const contextYAML = `structs:
+  Outer:
+    fields:
+      - {name: In, type: Inner}
+  Inner:
+    context: Outer
+    fields:
+      - {name: X, type: uint8}
+  Payload:
+    context:
+      - {name: SegmentLength, type: uint16}
+    fields:
+      - {name: Data, type: "[]byte", length: "ctx.SegmentLength - 2"}
+`
+
+func TestContext(t *testing.T) {
+	var ff FileFormat
+	if err := yaml.Unmarshal([]byte(contextYAML), &ff); err != nil {
+		t.Fatal(err)
+	}
+	for structName, want := range map[string]string{"Outer": "", "Inner": "Outer", "Payload": "PayloadContext"} {
+		if got := ff.ContextType(structName); got != want {
+			t.Errorf("ContextType(%q) = %q, want %q", structName, got, want)
+		}
+	}
+	if fields, ok := ff.TypeFields("PayloadContext"); !ok || len(fields) != 1 || fields[0].Name != "SegmentLength" {
+		t.Errorf("TypeFields(PayloadContext) = %v, %v", fields, ok)
+	}
+	if fields, ok := ff.TypeFields("Inner"); !ok || len(fields) != 1 || fields[0].Name != "X" {
+		t.Errorf("TypeFields(Inner) = %v, %v", fields, ok)
+	}
+	if _, ok := ff.TypeFields("OuterContext"); ok {
+		t.Errorf("TypeFields(OuterContext) found a type, but Outer declares no context")
+	}
+
+	// The context is written back in the form it was declared
+	data, err := yaml.Marshal(ff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again FileFormat
+	if err := yaml.Unmarshal(data, &again); err != nil {
+		t.Fatal(err)
+	}
+	for _, structName := range []string{"Outer", "Inner", "Payload"} {
+		if got, want := again.Structs[structName].Context, ff.Structs[structName].Context; !reflect.DeepEqual(got, want) {
+			t.Errorf("context of %s after a round trip = %+v, want %+v", structName, got, want)
+		}
+	}
+	if strings.Count(string(data), "context:") != 2 {
+		t.Errorf("Marshal() writes an empty context:\n%s", data)
+	}
+}
+
+func TestBitFieldRange(t *testing.T) {
+	tests := []struct {
+		bits          string
+		lo, hi        int
+		flag, wantErr bool
+	}{
+		{bits: "4-7", lo: 4, hi: 7},
+		{bits: " 0 - 3 ", lo: 0, hi: 3},
+		{bits: "7-4", lo: 4, hi: 7},
+		{bits: "3", lo: 3, hi: 3, flag: true},
+		{bits: "", wantErr: true},
+		{bits: "4-", wantErr: true},
+		{bits: "a-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		bitField := BitField{Name: "X", Bits: tt.bits}
+		lo, hi, err := bitField.Range()
+		if (err != nil) != tt.wantErr || err == nil && (lo != tt.lo || hi != tt.hi) {
+			t.Errorf("Range(%q) = %d, %d, %v, want %d, %d, error: %v", tt.bits, lo, hi, err, tt.lo, tt.hi, tt.wantErr)
+		}
+		if flag := bitField.IsFlag(); flag != tt.flag {
+			t.Errorf("IsFlag(%q) = %v, want %v", tt.bits, flag, tt.flag)
+		}
+	}
+}
+
+func TestValueLiteral(t *testing.T) {
+	tests := []struct {
+		typ, value string
+		want       string
+		wantErr    bool
+	}{
+		{typ: "uint16", value: "0xFFD8", want: "0xFFD8"},
+		{typ: "uint16", value: "65496", want: "0xFFD8"},
+		{typ: "uint8", value: "0x42", want: "66"},
+		{typ: "uint8", value: "256", wantErr: true},
+		{typ: "int8", value: "-2", want: "-2"},
+		{typ: "int8", value: "128", wantErr: true},
+		{typ: "float32", value: "1.5", want: "1.5"},
+		{typ: "uint32", value: "BM", wantErr: true},
+		{typ: "string", value: "BM", want: `"BM"`},
+		{typ: "string", value: "0x42", want: `"0x42"`},
+		{typ: "[]byte", value: "0x89504E47", want: `"\x89PNG"`},
+		{typ: "[]byte", value: "GIF", want: `"GIF"`},
+		{typ: "[]byte", value: "0xZZ", wantErr: true},
+		{typ: "Header", value: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		field := Field{Name: "X", Type: tt.typ, Value: tt.value}
+		got, err := field.ValueLiteral()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ValueLiteral(%s %q) = %s, %v, want %s, error: %v", tt.typ, tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// commands.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"FIG/config"
+	"FIG/fig"
+	"FIG/utils"
+)
+
+// command is a fig subcommand (e.g., "fig generate bmp").
+type command struct {
+	Name    string
+	Args    string // Argument synopsis shown in help, e.g. "<format>..."
+	Summary string
+	// Run parses the arguments following the subcommand name with its own
+	// flag set and returns the process exit code.
+	Run func(fs *flag.FlagSet, configPath *string, args []string) int
+}
+
+// commands lists the subcommands in the order they are shown in help.
+var commands []command
+
+func init() {
+	commands = []command{
+		{Name: "validate", Args: "<yaml>...", Summary: "Validate source YAML files without writing anything", Run: runValidate},
+		{Name: "bootstrap", Args: "[<yaml>|<format>...]", Summary: "Validate source YAML files and add them to the configuration", Run: runBootstrapCommand},
+		{Name: "generate", Args: "[<format>...]", Summary: "Generate Go code for configured formats", Run: runGenerate},
+		{Name: "clean", Args: "[<format>...]", Summary: "Remove generated Go files of configured formats", Run: runClean},
+		{Name: "list", Args: "", Summary: "List the configured formats", Run: runList},
+	}
+}
+
+// strictUsage is the help of the -strict flag of the commands that validate YAML.
+const strictUsage = "Reject YAML keys that match no attribute (e.g., misspelled ones); -strict=false only warns"
+
+// Help of the -dry-run and -diff flags of the commands that generate code.
+const (
+	dryRunUsage = "List the files generation would create, change or delete, without writing anything"
+	diffUsage   = "Print unified diffs between the current and the newly generated files, without writing anything"
+)
+
+// findCommand returns the subcommand with the given name, or nil.
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runCommand runs a subcommand with the arguments that follow its name.
+func runCommand(cmd *command, args []string) int {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	configPath := fs.String("config", configFileName, "Path to the formats configuration JSON file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fig %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.Name, cmd.Args, cmd.Summary)
+		fs.PrintDefaults()
+	}
+	return cmd.Run(fs, configPath, args)
+}
+
+// printUsage prints the top-level help listing every subcommand.
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: fig <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(out, "\nRun 'fig <command> -h' for the flags of a command.\n")
+	fmt.Fprintf(out, "Without a command, fig runs the interactive bootstrap/generation flow with these flags:\n")
+	flag.PrintDefaults()
+}
+
+// runValidate validates each YAML file given, printing the reformed YAML with
+// -print, or every diagnostic as JSON or SARIF with -format.
+func runValidate(fs *flag.FlagSet, configPath *string, args []string) int {
+	printReformed := fs.Bool("print", false, "Print the reformed YAML to stdout")
+	strict := fs.Bool("strict", true, strictUsage)
+	format := fs.String("format", utils.DiagnosticsText, "Diagnostics output: text (logged as found), json or sarif (written to stdout)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	*format = strings.ToLower(*format)
+	structured := *format != utils.DiagnosticsText
+	if err := utils.WriteDiagnostics(io.Discard, *format, nil); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return exitUsage
+	}
+	if structured && *printReformed {
+		fmt.Fprintln(fs.Output(), "-print cannot be combined with -format json or sarif, which use stdout.")
+		fs.Usage()
+		return exitUsage
+	}
+
+	g := fig.New(fig.Options{AllowUnknownKeys: !*strict, Logger: log.Default()})
+	failed := 0
+	var diagnostics []utils.Diagnostic
+	for _, yamlFile := range fs.Args() {
+		reformed, fileDiagnostics, err := g.Validate(yamlFile)
+		diagnostics = append(diagnostics, fileDiagnostics...)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			if !hasErrorDiagnostic(fileDiagnostics) { // e.g., the file could not be read
+				diagnostics = append(diagnostics, utils.Diagnostic{Severity: utils.SeverityError, File: yamlFile, Message: err.Error()})
+			}
+			failed++
+			continue
+		}
+		if *printReformed {
+			os.Stdout.Write(reformed)
+		}
+		log.Printf("%s is valid.", yamlFile)
+	}
+	if structured {
+		if err := utils.WriteDiagnostics(os.Stdout, *format, diagnostics); err != nil {
+			log.Printf("ERROR: writing diagnostics: %v", err)
+			return exitFailure
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d of %d file(s) failed validation.", failed, fs.NArg())
+		return exitFailure
+	}
+	return 0
+}
+
+// hasErrorDiagnostic reports whether any of the diagnostics is an error.
+func hasErrorDiagnostic(diagnostics []utils.Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == utils.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// runBootstrapCommand bootstraps the given source files (or all of them) and
+// optionally generates their code.
+func runBootstrapCommand(fs *flag.FlagSet, configPath *string, args []string) int {
+	all := fs.Bool("all", false, "Bootstrap every YAML file in the 'sources' directory")
+	generate := fs.Bool("generate", false, "Generate code for the bootstrapped formats afterwards")
+	tests := fs.Bool("tests", false, "With -generate, also generate test scripts")
+	noPrompt := fs.Bool("no-prompt", false, "Never read from stdin; requires arguments or -all")
+	strict := fs.Bool("strict", true, strictUsage)
+	fs.Parse(args)
+
+	opts := config.RunOptions{Formats: fs.Args(), All: *all, Tests: *tests, NoPrompt: *noPrompt, AllowUnknownKeys: !*strict}
+	if code := checkSelection(fs, opts); code != 0 {
+		return code
+	}
+	if err := RunBootstrap(*configPath, opts); err != nil {
+		log.Printf("Bootstrapping failed: %v", err)
+		return exitCode(err)
+	}
+	if !*generate {
+		return 0
+	}
+
+	// Source files are configured under their file name, so paths map to format names
+	genOpts := opts
+	genOpts.Formats = nil
+	for _, name := range opts.Formats {
+		genOpts.Formats = append(genOpts.Formats, strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)))
+	}
+	if err := RunGeneration(*configPath, genOpts); err != nil {
+		log.Printf("Code generation failed: %v", err)
+		return exitCode(err)
+	}
+	return 0
+}
+
+// runGenerate generates code for the given formats (or all of them).
+func runGenerate(fs *flag.FlagSet, configPath *string, args []string) int {
+	all := fs.Bool("all", false, "Generate every configured format")
+	tests := fs.Bool("tests", false, "Generate test scripts without asking")
+	noPrompt := fs.Bool("no-prompt", false, "Never read from stdin; requires arguments or -all")
+	strict := fs.Bool("strict", true, strictUsage)
+	dryRun := fs.Bool("dry-run", false, dryRunUsage)
+	diff := fs.Bool("diff", false, diffUsage)
+	fs.Parse(args)
+
+	opts := config.RunOptions{Formats: fs.Args(), All: *all, Tests: *tests, NoPrompt: *noPrompt, AllowUnknownKeys: !*strict, DryRun: *dryRun, Diff: *diff}
+	if code := checkSelection(fs, opts); code != 0 {
+		return code
+	}
+	if err := RunGeneration(*configPath, opts); err != nil {
+		log.Printf("Code generation failed: %v", err)
+		return exitCode(err)
+	}
+	return 0
+}
+
+// runClean removes the generated Go files of the given formats (or all of them).
+func runClean(fs *flag.FlagSet, configPath *string, args []string) int {
+	all := fs.Bool("all", false, "Clean every configured format")
+	fs.Parse(args)
+
+	if *all == (fs.NArg() > 0) {
+		fmt.Fprintln(fs.Output(), "Specify either format names or -all.")
+		fs.Usage()
+		return exitUsage
+	}
+	formatConfigs, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return exitFailure
+	}
+	if !*all {
+		if formatConfigs, err = config.SelectFormats(formatConfigs, fs.Args()); err != nil {
+			log.Printf("ERROR: %v", err)
+			return exitCode(err)
+		}
+	}
+	code := 0
+	for _, cfg := range formatConfigs {
+		if _, err := utils.Reset(cfg.OutputDir, nil); err != nil {
+			log.Printf("ERROR: %s: %v", cfg.Name, err)
+			code = exitFailure
+		}
+	}
+	return code
+}
+
+// runList prints the configured formats as a table.
+func runList(fs *flag.FlagSet, configPath *string, args []string) int {
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	formatConfigs, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return exitFailure
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPACKAGE\tYAML\tOUTPUT")
+	for _, cfg := range formatConfigs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", cfg.Name, cfg.PackageName, cfg.YAMLFile, cfg.OutputDir)
+	}
+	w.Flush()
+	return 0
+}
+
+// checkSelection rejects combining -all with explicit names, returning a
+// non-zero exit code if the selection is invalid.
+func checkSelection(fs *flag.FlagSet, opts config.RunOptions) int {
+	if opts.All && len(opts.Formats) > 0 {
+		fmt.Fprintln(fs.Output(), "-all cannot be combined with format names.")
+		fs.Usage()
+		return exitUsage
+	}
+	return 0
+}
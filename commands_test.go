@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"FIG/config"
+	"FIG/utils"
+)
+
+// writeTestFile writes content to path, creating its directory.
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// run runs the subcommand name with args, discarding what it logs, and
+// returns its exit code.
+func run(t *testing.T, name string, args ...string) int {
+	t.Helper()
+	cmd := findCommand(name)
+	if cmd == nil {
+		t.Fatalf("findCommand(%q) = nil", name)
+	}
+	logger := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logger)
+	return runCommand(cmd, args)
+}
+
+func TestFindCommand(t *testing.T) {
+	for _, name := range []string{"validate", "bootstrap", "generate", "clean", "list"} {
+		if cmd := findCommand(name); cmd == nil || cmd.Name != name {
+			t.Errorf("findCommand(%q) = %v", name, cmd)
+		}
+	}
+	if cmd := findCommand("build"); cmd != nil {
+		t.Errorf("findCommand(\"build\") = %v, want nil", cmd)
+	}
+}
+
+func TestRunValidate(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yml")
+	invalid := filepath.Join(dir, "invalid.yml")
+	misspelled := filepath.Join(dir, "misspelled.yml")
+	writeTestFile(t, valid, "structs:\n  A:\n    fields:\n      - {name: X, type: uint8}\n")
+	writeTestFile(t, invalid, "structs:\n  A:\n    fields:\n      - {name: X, type: uint128}\n")
+	writeTestFile(t, misspelled, "structs:\n  A:\n    fields:\n      - {name: X, type: uint8, lenght: 2}\n")
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{valid}, 0},
+		{[]string{valid, invalid}, exitFailure},
+		{[]string{misspelled}, exitFailure},
+		{[]string{"-strict=false", misspelled}, 0},
+		{[]string{"-format", "xml", valid}, exitUsage},
+		{[]string{"-format", "json", "-print", valid}, exitUsage},
+		{nil, exitUsage},
+	}
+	for _, tt := range tests {
+		if got := run(t, "validate", tt.args...); got != tt.want {
+			t.Errorf("validate %q = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 3 {
+		t.Errorf("validate wrote files: %v, %v", entries, err)
+	}
+}
+
+func TestRunClean(t *testing.T) {
+	dir := t.TempDir()
+	configs := []config.FormatConfig{
+		{Name: "BMP", YAMLFile: "sources/bmp.yml", OutputDir: filepath.Join(dir, "bmp"), PackageName: "bmp"},
+		{Name: "JPEG", YAMLFile: "sources/jpg.yml", OutputDir: filepath.Join(dir, "jpg"), PackageName: "jpg"},
+	}
+	configData, err := json.Marshal(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "formats.json")
+	writeTestFile(t, configPath, string(configData))
+	for _, cfg := range configs {
+		header := "// Code generated by FIG; DO NOT EDIT.\n\npackage " + cfg.PackageName + "\n"
+		writeTestFile(t, filepath.Join(cfg.OutputDir, "Header.go"), header)
+		if err := utils.WriteManifest(cfg.OutputDir, utils.Manifest{Files: map[string]string{"Header.go": utils.ContentHash([]byte(header))}}); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(cfg.OutputDir, "helpers.go"), "package "+cfg.PackageName+"\n")
+		writeTestFile(t, filepath.Join(cfg.OutputDir, cfg.PackageName+".yml"), "structs: {}\n")
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	if got := run(t, "clean", "-config", configPath, "-all", "bmp"); got != exitUsage {
+		t.Errorf("clean -all bmp = %d, want %d", got, exitUsage)
+	}
+	if got := run(t, "clean", "-config", configPath, "png"); got != exitUsage {
+		t.Errorf("clean png = %d, want %d", got, exitUsage)
+	}
+	if got := run(t, "clean", "-config", configPath, "bmp"); got != 0 {
+		t.Fatalf("clean bmp = %d, want 0", got)
+	}
+	if exists(filepath.Join(dir, "bmp", "Header.go")) || !exists(filepath.Join(dir, "jpg", "Header.go")) {
+		t.Errorf("clean bmp didn't remove exactly the Go files of bmp")
+	}
+	if !exists(filepath.Join(dir, "bmp", "bmp.yml")) || !exists(filepath.Join(dir, "bmp", "helpers.go")) {
+		t.Errorf("clean bmp removed the reformed YAML or a hand-written file")
+	}
+	if exists(filepath.Join(dir, "bmp", utils.ManifestFileName)) || !exists(filepath.Join(dir, "jpg", utils.ManifestFileName)) {
+		t.Errorf("clean bmp didn't remove exactly the manifest of bmp, which lists no file left")
+	}
+	if got := run(t, "clean", "-config", configPath, "-all"); got != 0 || exists(filepath.Join(dir, "jpg", "Header.go")) {
+		t.Errorf("clean -all = %d, and left jpg/Header.go: %v", got, exists(filepath.Join(dir, "jpg", "Header.go")))
+	}
+}
+
+func TestSelectSourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "png.yml")
+	writeTestFile(t, outside, "structs: {}\n")
+	sources := []string{"sources/bmp.yml", "sources/jpg.yml"}
+	tests := []struct {
+		opts    config.RunOptions
+		want    []string
+		wantErr error
+	}{
+		{opts: config.RunOptions{All: true}, want: sources},
+		{opts: config.RunOptions{Formats: []string{"JPG", "bmp", "jpg"}}, want: []string{"sources/jpg.yml", "sources/bmp.yml"}},
+		{opts: config.RunOptions{Formats: []string{"./sources/bmp.yml", outside}}, want: []string{"sources/bmp.yml", outside}},
+		{opts: config.RunOptions{Formats: []string{"gif"}}, wantErr: config.ErrUnknownFormat},
+		{opts: config.RunOptions{NoPrompt: true}, wantErr: config.ErrNoSelection},
+	}
+	for _, tt := range tests {
+		got, err := selectSourceFiles(sources, tt.opts)
+		if !errors.Is(err, tt.wantErr) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectSourceFiles(%+v) = %q, %v, want %q, %v", tt.opts, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
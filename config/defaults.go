@@ -18,11 +18,16 @@ type FormatConfig struct {
 
 // loadConfig reads and parses the formats.json file.
 func LoadConfig(configPath string) ([]FormatConfig, error) {
+	return ReadConfig(configPath, log.Default())
+}
+
+// ReadConfig is LoadConfig logging to logger instead of the standard logger.
+func ReadConfig(configPath string, logger *log.Logger) ([]FormatConfig, error) {
 	var configs []FormatConfig
 	configData, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Printf("Configuration file '%s' not found. Returning empty configuration.", configPath)
+			logger.Printf("Configuration file '%s' not found. Returning empty configuration.", configPath)
 			return configs, nil // Return empty slice, not an error
 		}
 		return nil, fmt.Errorf("failed to read configuration file '%s': %w", configPath, err)
@@ -32,7 +37,7 @@ func LoadConfig(configPath string) ([]FormatConfig, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse configuration file '%s': %w", configPath, err)
 	}
-	log.Printf("Loaded %d format configurations from %s.", len(configs), configPath)
+	logger.Printf("Loaded %d format configurations from %s.", len(configs), configPath)
 	return configs, nil
 }
 
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownFormat is returned when a format requested on the command line
+// does not match any configured format or source file.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ErrNoSelection is returned when prompting is disabled and no formats were
+// requested on the command line.
+var ErrNoSelection = errors.New("no formats selected")
+
+// RunOptions holds the command-line choices that let FIG run without user
+// interaction (e.g., from go generate, make, or CI).
+type RunOptions struct {
+	Formats  []string // Formats to process by name; bypasses the selection dialogue
+	All      bool     // Process every format; bypasses the selection dialogue
+	Yes      bool     // Answer yes to every y/N prompt
+	Tests    bool     // Generate test scripts without asking
+	NoPrompt bool     // Never read from stdin; unanswered y/N prompts default to no
+	// AllowUnknownKeys makes validation only warn about YAML keys that match
+	// no attribute (e.g., misspelled ones) instead of rejecting the file.
+	AllowUnknownKeys bool
+	DryRun           bool // Only list the files generation would create, change or delete
+	Diff             bool // Print unified diffs of the files generation would change; implies DryRun
+}
+
+// SelectsInteractively reports whether formats have to be picked through the dialogue.
+func (o RunOptions) SelectsInteractively() bool {
+	return !o.All && len(o.Formats) == 0
+}
+
+// ParseFormatList splits a comma-separated list of format names (e.g., "bmp,jpg").
+func ParseFormatList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SelectFormats returns the configurations matching names, in the order given.
+// A name matches a format's Name or PackageName, ignoring case.
+func SelectFormats(configs []FormatConfig, names []string) ([]FormatConfig, error) {
+	var selected []FormatConfig
+	seen := make(map[string]bool)
+	for _, name := range names {
+		found := false
+		for _, cfg := range configs {
+			if strings.EqualFold(cfg.Name, name) || strings.EqualFold(cfg.PackageName, name) {
+				if !seen[cfg.Name] {
+					selected = append(selected, cfg)
+					seen[cfg.Name] = true
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w '%s': not found in configuration", ErrUnknownFormat, name)
+		}
+	}
+	return selected, nil
+}
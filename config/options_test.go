@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFormatList(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"bmp", []string{"bmp"}},
+		{" bmp, jpg ,,", []string{"bmp", "jpg"}},
+	}
+	for _, tt := range tests {
+		if got := ParseFormatList(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFormatList(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestSelectFormats(t *testing.T) {
+	configs := []FormatConfig{
+		{Name: "BMP", PackageName: "bmp"},
+		{Name: "JPEG", PackageName: "jpg"},
+	}
+	tests := []struct {
+		names   []string
+		want    []string
+		wantErr error
+	}{
+		{names: []string{"jpg", "BMP"}, want: []string{"JPEG", "BMP"}},
+		{names: []string{"jpeg", "JPG"}, want: []string{"JPEG"}},
+		{names: []string{"bmp", "png"}, wantErr: ErrUnknownFormat},
+	}
+	for _, tt := range tests {
+		selected, err := SelectFormats(configs, tt.names)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("SelectFormats(%q) error = %v, want %v", tt.names, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, cfg := range selected {
+			got = append(got, cfg.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SelectFormats(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestSelectsInteractively(t *testing.T) {
+	tests := []struct {
+		opts RunOptions
+		want bool
+	}{
+		{RunOptions{}, true},
+		{RunOptions{NoPrompt: true, Yes: true}, true},
+		{RunOptions{All: true}, false},
+		{RunOptions{Formats: []string{"bmp"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.SelectsInteractively(); got != tt.want {
+			t.Errorf("%+v.SelectsInteractively() = %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+}
@@ -106,5 +106,13 @@ func ShowConfigSelection(formatConfigs []config.FormatConfig) ([]config.FormatCo
 	return uniqueConfigs, nil
 }
 
+// confirmReader is shared by every Confirm call so that answers piped on
+// stdin are not lost in a discarded buffer between questions.
+var confirmReader = bufio.NewReader(os.Stdin)
 
-
+// Confirm asks a y/N question and reports whether the user answered yes.
+func Confirm(question string) bool {
+	fmt.Printf("%s (y/N): ", question)
+	response, _ := confirmReader.ReadString('\n')
+	return strings.EqualFold(strings.TrimSpace(response), "y")
+}
@@ -0,0 +1,180 @@
+// Package fig is the Go API of FIG. It validates format YAML and generates Go
+// code for it without prompting, exiting or writing to the standard logger, so
+// that build tools can embed it. The fig command is a thin wrapper around it.
+package fig
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"FIG/config"
+	"FIG/generator"
+	"FIG/utils"
+)
+
+// Options configures a Generator. The zero value generates code without test
+// scripts, rejects unknown YAML keys and discards progress messages.
+type Options struct {
+	Tests bool // Generate the test script of every format
+	// ConfirmTests, if set, is asked for each format whether to generate its
+	// test script when Tests is false (the fig command prompts with it).
+	ConfirmTests     func(format string) bool
+	AllowUnknownKeys bool // Only warn about YAML keys that match no attribute
+	// GoModulePath is the module path test scripts import the generated
+	// packages with. It is detected with 'go list -m' if empty.
+	GoModulePath string
+	// FS receives the generated files. nil writes them to disk, replacing the
+	// .go files of each output directory; a generator.MapFS keeps them in
+	// memory without touching the disk.
+	FS     generator.WriteFS
+	Logger *log.Logger // Progress messages and diagnostics; nil discards them
+}
+
+// Generator validates format YAML and generates Go code for it.
+type Generator struct {
+	opts   Options
+	logger *log.Logger
+}
+
+// New returns a Generator with the given options.
+func New(opts Options) *Generator {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	return &Generator{opts: opts, logger: logger}
+}
+
+// Result summarizes a generation run.
+type Result struct {
+	Formats []FormatResult // In the order they were generated
+}
+
+// FormatResult is the outcome of generating one format.
+type FormatResult struct {
+	Name      string
+	OutputDir string
+	Files     []string // Paths written, including those written before an error
+	Err       error    // nil if the format was generated
+}
+
+// Files returns the paths of the files written for every format.
+func (r *Result) Files() []string {
+	var files []string
+	for _, format := range r.Formats {
+		files = append(files, format.Files...)
+	}
+	return files
+}
+
+// Failed returns the names of the formats that failed.
+func (r *Result) Failed() []string {
+	var failed []string
+	for _, format := range r.Formats {
+		if format.Err != nil {
+			failed = append(failed, format.Name)
+		}
+	}
+	return failed
+}
+
+// Validate validates and reforms a source YAML file without writing anything.
+// It returns the reformed YAML and the diagnostics found; the error is non-nil
+// if the file is invalid.
+func (g *Generator) Validate(yamlPath string) ([]byte, []utils.Diagnostic, error) {
+	return utils.ValidateYAML(yamlPath, g.validationOptions())
+}
+
+// ValidateSource is Validate for a source YAML in memory. name only identifies
+// the source in diagnostics (e.g., "sources/bmp.yml").
+func (g *Generator) ValidateSource(name string, source []byte) ([]byte, []utils.Diagnostic, error) {
+	return utils.ValidateYAMLSource(name, source, g.validationOptions())
+}
+
+// validationOptions returns the options validation runs with.
+func (g *Generator) validationOptions() utils.ValidationOptions {
+	return utils.ValidationOptions{AllowUnknownKeys: g.opts.AllowUnknownKeys, Logger: g.logger}
+}
+
+// GenerateConfigured generates the formats of a configuration file (such as
+// config/formats.json) with the given names, or all of them if none are given.
+// Names match a format's Name or PackageName, ignoring case.
+func (g *Generator) GenerateConfigured(configPath string, names ...string) (*Result, error) {
+	formats, err := config.ReadConfig(configPath, g.logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		if formats, err = config.SelectFormats(formats, names); err != nil {
+			return nil, err
+		}
+	}
+	return g.Generate(formats...)
+}
+
+// Generate generates the code of each format, after removing the old .go files
+// of its output directory when writing to disk. The source YAML (YAMLFile) is
+// validated and its reformed YAML written again, so edits to it are generated;
+// the reformed YAML already in the output directory is only used if the source
+// is missing. A format that fails doesn't stop the others; the error then names
+// the ones that failed, and the Result tells what was written for each.
+func (g *Generator) Generate(formats ...config.FormatConfig) (*Result, error) {
+	result := &Result{}
+	if len(formats) == 0 {
+		return result, nil
+	}
+	g.logger.Printf("Processing %d selected format(s) for generation.", len(formats))
+
+	goModulePath := g.goModulePath()
+	for _, format := range formats {
+		result.Formats = append(result.Formats, g.generate(format, nil, goModulePath))
+	}
+
+	if failed := result.Failed(); len(failed) > 0 {
+		return result, fmt.Errorf("%d of %d format(s) failed: %s", len(failed), len(formats), strings.Join(failed, ", "))
+	}
+	return result, nil
+}
+
+// GenerateSource validates a source YAML in memory and generates the format
+// from it, without reading the format's YAMLFile (which only names the source
+// in messages) or its reformed YAML. With a generator.MapFS as Options.FS,
+// nothing touches the disk: the reformed YAML and the code are both written to
+// memory.
+func (g *Generator) GenerateSource(format config.FormatConfig, source []byte) (FormatResult, error) {
+	result := g.generate(format, source, g.goModulePath())
+	return result, result.Err
+}
+
+// generate generates one format, from source if it is not nil.
+func (g *Generator) generate(format config.FormatConfig, source []byte, goModulePath string) FormatResult {
+	g.logger.Printf("--- Processing format: %s ---", format.Name)
+	tests := g.opts.Tests || (g.opts.ConfirmTests != nil && g.opts.ConfirmTests(format.Name))
+	files, err := generator.GenerateFormat(format, generator.Options{
+		Tests:            tests,
+		AllowUnknownKeys: g.opts.AllowUnknownKeys,
+		GoModulePath:     goModulePath,
+		Source:           source,
+		FS:               g.opts.FS,
+		Logger:           g.logger,
+	})
+	if err != nil {
+		g.logger.Printf("ERROR: %s: %v", format.Name, err)
+	}
+	return FormatResult{Name: format.Name, OutputDir: format.OutputDir, Files: files, Err: err}
+}
+
+// goModulePath returns the module path test scripts import the generated
+// packages with, detecting it only if test scripts may be generated.
+func (g *Generator) goModulePath() string {
+	if g.opts.GoModulePath != "" || !g.opts.Tests && g.opts.ConfirmTests == nil {
+		return g.opts.GoModulePath
+	}
+	path, err := utils.GetGoModulePath()
+	if err != nil {
+		g.logger.Printf("Warning: Could not determine Go module path: %v. Test imports might be incorrect.", err)
+	}
+	return path
+}
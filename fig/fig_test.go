@@ -0,0 +1,565 @@
+package fig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"FIG/config"
+	"FIG/generator"
+)
+
+// writeFile writes content to path, creating its directory.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testFormats writes the sources of a valid and an invalid format to dir and
+// returns their configurations.
+func testFormats(t *testing.T, dir string) []config.FormatConfig {
+	t.Helper()
+	formats := []config.FormatConfig{
+		{Name: "Good", YAMLFile: filepath.Join(dir, "sources", "good.yml"), OutputDir: filepath.Join(dir, "formats", "good"), PackageName: "good"},
+		{Name: "Bad", YAMLFile: filepath.Join(dir, "sources", "bad.yml"), OutputDir: filepath.Join(dir, "formats", "bad"), PackageName: "bad"},
+	}
+	writeFile(t, formats[0].YAMLFile, "structs:\n  Header:\n    fields:\n      - {name: Size, type: uint32}\n")
+	writeFile(t, formats[1].YAMLFile, "structs:\n  Header:\n    fields:\n      - {name: Size, type: uint128}\n")
+	return formats
+}
+
+// silenceStandardLogger fails the test if anything is logged to the standard
+// logger until the returned function is called.
+func silenceStandardLogger(t *testing.T) func() {
+	t.Helper()
+	var logged bytes.Buffer
+	logger := log.Writer()
+	log.SetOutput(&logged)
+	return func() {
+		log.SetOutput(logger)
+		if logged.Len() > 0 {
+			t.Errorf("logged to the standard logger:\n%s", logged.String())
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	formats := testFormats(t, dir)
+	defer silenceStandardLogger(t)()
+
+	result, err := New(Options{Tests: true, GoModulePath: "example.com/m"}).Generate(formats...)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 format(s) failed: Bad") {
+		t.Fatalf("Generate() error = %v, want the Bad format to fail", err)
+	}
+	if len(result.Formats) != 2 || result.Formats[0].Err != nil || result.Formats[1].Err == nil {
+		t.Fatalf("Generate() result = %+v", result.Formats)
+	}
+	if failed := result.Failed(); len(failed) != 1 || failed[0] != "Bad" {
+		t.Errorf("Failed() = %v, want [Bad]", failed)
+	}
+
+	var names []string
+	for _, path := range result.Formats[0].Files {
+		if filepath.Dir(path) != formats[0].OutputDir {
+			t.Errorf("Generate() wrote %s outside %s", path, formats[0].OutputDir)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Generate() listed %s: %v", path, err)
+		}
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), "Header.go good.yml good_test.go"; got != want {
+		t.Errorf("Generate() wrote %s, want %s", got, want)
+	}
+	if files := result.Files(); len(files) != len(result.Formats[0].Files)+len(result.Formats[1].Files) {
+		t.Errorf("Files() = %v", files)
+	}
+	code, err := ioutil.ReadFile(filepath.Join(formats[0].OutputDir, "good_test.go"))
+	if err != nil || !strings.Contains(string(code), `"example.com/m/`) {
+		t.Errorf("good_test.go doesn't import the package with the module path: %v\n%s", err, code)
+	}
+}
+
+func TestGenerateConfirmTests(t *testing.T) {
+	formats := testFormats(t, t.TempDir())[:1]
+	var asked []string
+	g := New(Options{GoModulePath: "example.com/m", ConfirmTests: func(format string) bool {
+		asked = append(asked, format)
+		return false
+	}})
+	if _, err := g.Generate(formats...); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if len(asked) != 1 || asked[0] != "Good" {
+		t.Errorf("ConfirmTests asked for %v, want [Good]", asked)
+	}
+	if _, err := os.Stat(filepath.Join(formats[0].OutputDir, "good_test.go")); !os.IsNotExist(err) {
+		t.Errorf("Generate() wrote the declined test script: %v", err)
+	}
+}
+
+func TestGenerateConfigured(t *testing.T) {
+	dir := t.TempDir()
+	formats := testFormats(t, dir)
+	configData, err := json.Marshal(formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "formats.json")
+	writeFile(t, configPath, string(configData))
+	defer silenceStandardLogger(t)()
+
+	g := New(Options{})
+	result, err := g.GenerateConfigured(configPath, "good")
+	if err != nil || len(result.Formats) != 1 || result.Formats[0].Name != "Good" {
+		t.Errorf("GenerateConfigured(good) = %+v, %v", result, err)
+	}
+	if _, err := g.GenerateConfigured(configPath, "png"); err == nil {
+		t.Error("GenerateConfigured(png) error = nil")
+	}
+	if result, err := g.GenerateConfigured(configPath); err == nil || len(result.Formats) != 2 {
+		t.Errorf("GenerateConfigured() = %+v, %v, want both formats, one failing", result, err)
+	}
+	if result, err := g.GenerateConfigured(filepath.Join(dir, "missing.json")); err != nil || len(result.Formats) != 0 {
+		t.Errorf("GenerateConfigured() of a missing configuration = %+v, %v, want nothing", result, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	formats := testFormats(t, t.TempDir())
+	var logged bytes.Buffer
+	g := New(Options{Logger: log.New(&logged, "", 0)})
+	defer silenceStandardLogger(t)()
+
+	reformed, diagnostics, err := g.Validate(formats[0].YAMLFile)
+	if err != nil || !strings.Contains(string(reformed), "name: Size") || len(diagnostics) != 0 {
+		t.Errorf("Validate(good) = %s, %v, %v", reformed, diagnostics, err)
+	}
+	_, diagnostics, err = g.Validate(formats[1].YAMLFile)
+	if err == nil || len(diagnostics) != 1 || !strings.Contains(logged.String(), "uint128") {
+		t.Errorf("Validate(bad) = %v, %v, want an error logged to the Logger:\n%s", diagnostics, err, logged.String())
+	}
+	if _, err := os.Stat(formats[0].OutputDir); !os.IsNotExist(err) {
+		t.Errorf("Validate() wrote to %s: %v", formats[0].OutputDir, err)
+	}
+}
+
+// featureYAML uses most of what FIG generates: endianness, numeric types,
+// constants, enums, bit fields, a layout with a typed context, string
+// encodings, padding, repeated nested structs, conditions and functions.
+const featureYAML = `name: Feature
+endian: big
+functions:
+  AlignTo: {go: alignTo, args: 2}
+enums:
+  Kind:
+    type: uint8
+    strict: true
+    values:
+      - {name: Small, value: 1}
+      - {name: Large, value: 2}
+layout: [Head, Body]
+structs:
+  Head:
+    fields:
+      - {name: Magic, type: "[]byte", magic: "0x4645"}
+      - {name: Version, type: uint16, endian: little}
+      - {name: Ratio, type: float32}
+      - {name: Big, type: int64}
+      - {name: Kind, enum: Kind}
+      - name: Flags
+        type: uint8
+        bits:
+          - {name: Compressed, bits: 0}
+          - {name: Level, bits: 4-7}
+      - {name: Count, type: uint8}
+  Body:
+    context: File
+    fields:
+      - {name: Name, type: string, encoding: cstring, max_length: 16}
+      - {name: Title, type: string, encoding: pascal8}
+      - {name: Code, type: string, length: 4, pad: " "}
+      - {name: Raw, type: string, length: 2}
+      - {name: Items, type: "[]Item", count: "ctx.Head.Count"}
+      - {name: Extra, type: uint32, condition: "bits(ctx.Head.Flags, 0, 0) == 1"}
+      - {name: Blob, type: "[]byte", length: "AlignTo(ctx.Head.Count, 4)"}
+  Item:
+    fields:
+      - {name: V, type: uint16}
+`
+
+// nativeFeatureYAML is featureYAML with native expressions.
+var nativeFeatureYAML = strings.Replace(featureYAML, "endian: big\n", "endian: big\nexpressions: native\n", 1)
+
+// alignToSource defines the function featureYAML declares, in package pkg.
+const alignToSource = `package %s
+
+func alignTo(n, k int64) int64 {
+	return (n + k - 1) / k * k
+}
+`
+
+// generateInMemory generates source with GenerateSource into a MapFS, as the
+// package named after outputDir.
+func generateInMemory(t *testing.T, outputDir, source string, opts Options) (generator.MapFS, FormatResult, error) {
+	t.Helper()
+	files := generator.MapFS{}
+	opts.FS = files
+	if opts.GoModulePath == "" {
+		opts.GoModulePath = "FIG"
+	}
+	pkg := filepath.Base(outputDir)
+	format := config.FormatConfig{Name: pkg, YAMLFile: "sources/" + pkg + ".yml", OutputDir: outputDir, PackageName: pkg}
+	result, err := New(opts).GenerateSource(format, []byte(source))
+	return files, result, err
+}
+
+func TestGenerateSourceInMemory(t *testing.T) {
+	source, err := ioutil.ReadFile("../sources/bmp.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputDir := filepath.Join(t.TempDir(), "formats", "bmp")
+	files := generator.MapFS{}
+	g := New(Options{Tests: true, GoModulePath: "FIG", FS: files})
+	result, err := g.GenerateSource(config.FormatConfig{Name: "BMP", YAMLFile: "missing/bmp.yml", OutputDir: outputDir, PackageName: "bmp"}, source)
+	if err != nil {
+		t.Fatalf("GenerateSource() error = %v", err)
+	}
+
+	var names []string
+	for _, path := range result.Files {
+		if filepath.Dir(path) != outputDir {
+			t.Errorf("GenerateSource() wrote %s outside %s", path, outputDir)
+		}
+		if _, ok := files[path]; !ok {
+			t.Errorf("GenerateSource() listed %s, which is not in the MapFS", path)
+		}
+		names = append(names, filepath.Base(path))
+	}
+	if len(files) != len(result.Files) {
+		t.Errorf("MapFS has %d file(s), GenerateSource() listed %d", len(files), len(result.Files))
+	}
+	sort.Strings(names)
+	want := []string{"File.go", "FileHeader.go", "ImageData.go", "InfoHeader.go", "bmp.yml", "bmp_test.go", "enums.go", "errors.go"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("GenerateSource() wrote %v, want %v", names, want)
+	}
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("GenerateSource() into a MapFS touched the disk: %v", err)
+	}
+}
+
+func TestGenerateSourceValidationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"syntax", "structs: [\n", "error parsing initial YAML structure"},
+		{"unknown key", "structs:\n  A:\n    fields:\n      - {name: X, type: uint8, lenght: 2}\n", "critical validation error"},
+		{"unknown type", "structs:\n  A:\n    fields:\n      - {name: X, type: float128}\n", "critical validation error"},
+		{"bad expression", "structs:\n  A:\n    fields:\n      - {name: X, type: \"[]byte\", length: \"s.Y +\"}\n", "critical validation error"},
+		{"bad native expression", "expressions: native\nstructs:\n  A:\n    fields:\n      - {name: X, type: \"[]byte\", length: \"s.Missing\"}\n", "critical validation error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, result, err := generateInMemory(t, "formats/invalid", tt.source, Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("GenerateSource() error = %v, want %q", err, tt.wantErr)
+			}
+			if result.Err != err {
+				t.Errorf("FormatResult.Err = %v, want %v", result.Err, err)
+			}
+			if len(files) > 0 || len(result.Files) > 0 {
+				t.Errorf("GenerateSource() wrote %v despite the error", result.Files)
+			}
+		})
+	}
+
+	// Unknown keys are only warnings with AllowUnknownKeys
+	source := "structs:\n  A:\n    fields:\n      - {name: X, type: uint8, lenght: 2}\n"
+	if _, _, err := generateInMemory(t, "formats/lenient", source, Options{AllowUnknownKeys: true}); err != nil {
+		t.Errorf("GenerateSource() with AllowUnknownKeys error = %v", err)
+	}
+}
+
+func TestGenerateSourceFeatures(t *testing.T) {
+	type check struct {
+		file    string
+		want    []string // Substrings of the file
+		notWant []string
+	}
+	tests := []struct {
+		name   string
+		source string
+		checks []check
+	}{
+		{
+			name:   "govaluate",
+			source: featureYAML,
+			checks: []check{
+				{"Head.go", []string{
+					"binary.Read(r, binary.LittleEndian, &s.Version)",               // Field endianness
+					"binary.Read(r, binary.BigEndian, &s.Ratio)",                    // Format endianness, floats
+					"binary.Write(w, binary.BigEndian, s.Big)",                      // Signed integers
+					"return &ValueMismatchError{Struct: \"Head\", Field: \"Magic\"", // Constants are checked
+					"_, err = io.WriteString(w, \"FE\")",                            // and always written
+					"Kind Kind",                                                     // Enum fields
+					"if !s.Kind.IsValid() {",                                        // Strict enums
+					"func (s *Head) Compressed() bool {",                            // Bit fields
+					"func (s *Head) SetLevel(v uint8) {",
+					"func (s *Head) Read(r io.Reader, ctx interface{}) error {",
+					"func (s *Head) Write(w io.Writer, ctx interface{}) error {",
+				}, nil},
+				{"Body.go", []string{
+					"func (s *Body) Read(r io.Reader, ctx *File) error {", // Typed context
+					"func (s *Body) Write(w io.Writer, ctx *File) error {",
+					"ctx must be a non-nil *File",
+					"utils.MustCompileExpressionWith(`ctx.Head.Count`, expressionFunctions)",          // Precompiled expressions
+					"no terminator within max_length 16",                                              // cstring
+					"too long for a uint8 length prefix",                                              // pascal8
+					"s.Code = string(bytes.TrimRight(b, \" \"))",                                      // Padding is stripped
+					"count expression ('%s') expects %d element(s), got %d",                           // Write checks counts
+					"bodyExtraConditionExpr.Eval(utils.ExpressionParameters{\"s\": s, \"ctx\": ctx})", // Conditions use ctx
+				}, nil},
+				{"File.go", []string{
+					"func (f *File) ReadFile(r io.Reader) error {", // Layout
+					"if err := f.Head.Read(r, f); err != nil {",
+					"if err := f.Body.Write(w, f); err != nil {",
+				}, nil},
+				{"expression_functions.go", []string{`"AlignTo": alignTo,`}, nil},
+				{"enums.go", []string{"KindLarge Kind = 2", "func (v Kind) String() string {", "func (v Kind) IsValid() bool {"}, nil},
+				{"errors.go", []string{"type ValueMismatchError struct {"}, nil},
+				{"feature_test.go", []string{
+					"Magic: []byte(\"FE\"),      // Constant", // Constants and strict enums are set in the test data
+					"Kind:  feature.KindSmall, // Strict enum",
+					"originalStruct.Write(writeFile, writeCtx)",
+				}, nil},
+			},
+		},
+		{
+			name:   "native",
+			source: nativeFeatureYAML,
+			checks: []check{
+				{"Body.go", []string{
+					"count := int(int64(ctx.Head.Count))",                   // Translated expressions
+					"present := bitRange(int64(ctx.Head.Flags), 0, 0) == 1", // Built-in functions
+					"size := int(alignTo(int64(ctx.Head.Count), 4))",        // Declared functions
+					"count expression ('%s') expects %d element(s), got %d",
+				}, []string{"govaluate", "FIG/utils", "MustCompileExpression"}},
+				{"expression_functions.go", []string{"func bitRange(x, lo, hi int64) int64 {"}, []string{"FIG/utils"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, _, err := generateInMemory(t, "formats/feature", tt.source, Options{Tests: true})
+			if err != nil {
+				t.Fatalf("GenerateSource() error = %v", err)
+			}
+			for _, c := range tt.checks {
+				code, ok := files[filepath.Join("formats", "feature", c.file)]
+				if !ok {
+					t.Errorf("%s was not generated", c.file)
+					continue
+				}
+				for _, want := range c.want {
+					if !bytes.Contains(code, []byte(want)) {
+						t.Errorf("%s doesn't contain %q", c.file, want)
+					}
+				}
+				for _, notWant := range c.notWant {
+					if bytes.Contains(code, []byte(notWant)) {
+						t.Errorf("%s contains %q", c.file, notWant)
+					}
+				}
+			}
+		})
+	}
+}
+
+// roundTripTest exercises the package generated from featureYAML, imported as
+// feature, beyond the generated test script.
+const roundTripTest = `package %[1]s_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	feature "figtest/formats/%[1]s"
+)
+
+func sample() feature.File {
+	f := feature.File{
+		Head: feature.Head{Version: 0x0102, Ratio: 1.5, Big: -7, Kind: feature.KindLarge, Count: 2},
+		Body: feature.Body{Name: "name", Title: "title", Code: "ab", Raw: "xy", Items: []feature.Item{{V: 1}, {V: 2}}, Extra: 42, Blob: []byte{1, 2, 3, 4}},
+	}
+	f.Head.SetCompressed(true)
+	f.Head.SetLevel(9)
+	return f
+}
+
+func TestFeatureRoundTrip(t *testing.T) {
+	f := sample()
+	var buf bytes.Buffer
+	if err := f.WriteFile(&buf); err != nil {
+		t.Fatalf("WriteFile() error = %%v", err)
+	}
+	var got feature.File
+	if err := got.ReadFile(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ReadFile() error = %%v", err)
+	}
+	f.Head.Magic = []byte("FE") // Written whatever the struct holds
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("ReadFile() = %%+v, want %%+v", got, f)
+	}
+	if got.Head.Level() != 9 || !got.Head.Compressed() {
+		t.Errorf("bit fields = %%d, %%v, want 9, true", got.Head.Level(), got.Head.Compressed())
+	}
+
+	// Without the flag, Extra is neither written nor read
+	f.Head.SetCompressed(false)
+	buf.Reset()
+	if err := f.WriteFile(&buf); err != nil {
+		t.Fatalf("WriteFile() error = %%v", err)
+	}
+	got = feature.File{}
+	if err := got.ReadFile(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ReadFile() error = %%v", err)
+	}
+	if got.Body.Extra != 0 {
+		t.Errorf("ReadFile() read Extra = %%d, whose condition is false", got.Body.Extra)
+	}
+}
+
+func TestFeatureWriteErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(f *feature.File)
+		wantErr string
+	}{
+		{"count", func(f *feature.File) { f.Body.Items = f.Body.Items[:1] }, "expects 2 element(s), got 1"},
+		{"unpadded length", func(f *feature.File) { f.Body.Raw = "x" }, "shorter than its length 2"},
+		{"too long", func(f *feature.File) { f.Body.Code = "abcde" }, "Code"},
+		{"expression length", func(f *feature.File) { f.Body.Blob = []byte{1} }, "Blob"},
+		{"max_length", func(f *feature.File) { f.Body.Name = strings.Repeat("n", 17) }, "longer than max_length 16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := sample()
+			tt.edit(&f)
+			if err := f.WriteFile(io.Discard); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("WriteFile() error = %%v, want %%q", err, tt.wantErr)
+			}
+		})
+	}
+	var body feature.Body
+	if err := body.Write(io.Discard, nil); err == nil {
+		t.Errorf("Write() with a nil context succeeded")
+	}
+}
+
+func TestFeatureReadErrors(t *testing.T) {
+	f := sample()
+	var buf bytes.Buffer
+	if err := f.WriteFile(&buf); err != nil {
+		t.Fatalf("WriteFile() error = %%v", err)
+	}
+	data := buf.Bytes()
+
+	magic := append([]byte("XX"), data[2:]...)
+	var mismatch *feature.ValueMismatchError
+	if err := new(feature.File).ReadFile(bytes.NewReader(magic)); !errors.As(err, &mismatch) || mismatch.Field != "Magic" {
+		t.Errorf("ReadFile() with another magic error = %%v, want a ValueMismatchError", err)
+	}
+	kind := append([]byte(nil), data...)
+	kind[2+2+4+8] = 7
+	if err := new(feature.File).ReadFile(bytes.NewReader(kind)); err == nil || !strings.Contains(err.Error(), "invalid Kind value 7") {
+		t.Errorf("ReadFile() with an undeclared Kind error = %%v", err)
+	}
+	if err := new(feature.File).ReadFile(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Errorf("ReadFile() of truncated data succeeded")
+	}
+}
+`
+
+// writeModule writes a go.mod to dir declaring the module figtest, which
+// uses this module through a replace directive, along with this module's
+// go.sum for the dependencies.
+func writeModule(t *testing.T, dir string) {
+	t.Helper()
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goMod, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goMod = regexp.MustCompile(`(?m)^module .*$`).ReplaceAll(goMod, []byte("module figtest"))
+	writeFile(t, filepath.Join(dir, "go.mod"), string(goMod)+"\nrequire FIG v0.0.0\n\nreplace FIG => "+root+"\n")
+	goSum, err := ioutil.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "go.sum"), string(goSum))
+}
+
+func TestGenerateSourceRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles and tests generated packages")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	// The packages are generated into a temporary module that requires this
+	// one, where the test scripts import them as figtest/formats/<package>
+	moduleDir := t.TempDir()
+	writeModule(t, moduleDir)
+
+	var packages []string
+	for pkg, source := range map[string]string{"figroundtrip": featureYAML, "figroundtripnative": nativeFeatureYAML} {
+		dir := filepath.Join(moduleDir, "formats", pkg)
+		files, result, err := generateInMemory(t, dir, source, Options{Tests: true, GoModulePath: "figtest"})
+		if err != nil {
+			t.Fatalf("GenerateSource(%s) error = %v", pkg, err)
+		}
+		for _, path := range result.Files {
+			if err := (generator.DiskFS{}).WriteFile(path, files[path]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeFile(t, filepath.Join(dir, "functions.go"), fmt.Sprintf(alignToSource, pkg))
+		writeFile(t, filepath.Join(dir, "roundtrip_test.go"), fmt.Sprintf(roundTripTest, pkg))
+		packages = append(packages, "./formats/"+pkg)
+	}
+
+	cmd := exec.Command(goTool, append([]string{"test", "-count=1"}, packages...)...)
+	cmd.Dir = moduleDir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test %s: %v\n%s", strings.Join(packages, " "), err, output)
+	}
+}
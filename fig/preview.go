@@ -0,0 +1,133 @@
+package fig
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"FIG/config"
+	"FIG/generator"
+	"FIG/utils"
+)
+
+// ChangeKind is the effect generation has on a file.
+type ChangeKind string
+
+// Kinds of FileChange.
+const (
+	Created   ChangeKind = "created"
+	Changed   ChangeKind = "changed"
+	Deleted   ChangeKind = "deleted" // A generated .go file that is not generated again
+	Unchanged ChangeKind = "unchanged"
+	Kept      ChangeKind = "kept" // A hand-written file, or a generated one modified by hand since
+)
+
+// FileChange is the effect generation would have on one file on disk.
+type FileChange struct {
+	Path string
+	Kind ChangeKind
+	Old  []byte // Current content; nil if the file doesn't exist
+	New  []byte // Generated content; nil if the file would be deleted
+}
+
+// Diff returns the change as a unified diff, or "" if the file is unchanged
+// or kept.
+func (c FileChange) Diff() string {
+	oldName, newName := c.Path, c.Path
+	switch c.Kind {
+	case Unchanged, Kept:
+		return ""
+	case Created:
+		oldName = "/dev/null"
+	case Deleted:
+		newName = "/dev/null"
+	}
+	return utils.UnifiedDiff(oldName, newName, c.Old, c.New)
+}
+
+// Preview generates the formats in memory and compares the result with the
+// disk without writing anything. It selects the YAML of each format like
+// Generate does, so it shows the effect of editing the source, but it never
+// asks ConfirmTests. It returns the
+// change to every file generation would create, change, delete, leave
+// unchanged or keep because it wasn't generated or was modified by hand, by
+// format, along with the Result of the generation in memory. Formats that fail
+// are left out of the changes.
+func (g *Generator) Preview(formats ...config.FormatConfig) ([]FileChange, *Result, error) {
+	files := generator.MapFS{}
+	preview := *g
+	preview.opts.FS = files
+	preview.opts.ConfirmTests = nil
+	result, err := preview.Generate(formats...)
+
+	var changes []FileChange
+	for i, format := range result.Formats {
+		if format.Err != nil {
+			continue
+		}
+		kept := generator.KeptFiles(formats[i], generator.Options{Tests: preview.opts.Tests})
+		formatChanges, compareErr := compareFormat(format, files, kept)
+		if compareErr != nil {
+			return changes, result, compareErr
+		}
+		changes = append(changes, formatChanges...)
+	}
+	return changes, result, err
+}
+
+// compareFormat compares the files generated in memory for a format with the
+// disk, including the generated files regeneration would delete from its
+// output directory and the files it would keep. kept names the generated files
+// that are not generated again, which stay unchanged.
+func compareFormat(format FormatResult, files generator.MapFS, kept []string) ([]FileChange, error) {
+	plan, err := utils.PlanReset(format.OutputDir, kept...)
+	if err != nil {
+		return nil, err
+	}
+	left := make(map[string]bool)
+	for _, path := range plan.Left() {
+		left[filepath.Clean(path)] = true
+	}
+
+	var changes []FileChange
+	generated := make(map[string]bool)
+	for _, path := range format.Files {
+		generated[filepath.Clean(path)] = true
+		change := FileChange{Path: path, New: files[path]}
+		old, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			change.Kind = Created
+		case err != nil:
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		case left[filepath.Clean(path)]:
+			change.Kind, change.Old = Kept, old
+		case bytes.Equal(old, change.New):
+			change.Kind, change.Old = Unchanged, old
+		default:
+			change.Kind, change.Old = Changed, old
+		}
+		changes = append(changes, change)
+	}
+
+	stale := map[ChangeKind][]string{Deleted: plan.Remove, Kept: plan.Modified, Unchanged: plan.Kept}
+	for _, kind := range []ChangeKind{Deleted, Kept, Unchanged} {
+		for _, path := range stale[kind] {
+			if generated[filepath.Clean(path)] {
+				continue
+			}
+			old, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			change := FileChange{Path: path, Kind: kind, Old: old}
+			if kind == Unchanged {
+				change.New = old
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
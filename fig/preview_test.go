@@ -0,0 +1,248 @@
+package fig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"FIG/config"
+	"FIG/generator"
+	"FIG/utils"
+)
+
+const previewYAML = `name: Preview
+structs:
+  Header:
+    fields:
+      - {name: Size, type: uint32}
+  Palette:
+    fields:
+      - {name: Colors, type: "[]byte", length: 4}
+`
+
+func TestPreview(t *testing.T) {
+	dir := t.TempDir()
+	format := config.FormatConfig{
+		Name:        "Preview",
+		YAMLFile:    filepath.Join(dir, "sources", "preview.yml"),
+		OutputDir:   filepath.Join(dir, "formats", "preview"),
+		PackageName: "preview",
+	}
+	writeSource := func(source string) {
+		t.Helper()
+		if err := (generator.DiskFS{}).WriteFile(format.YAMLFile, []byte(source)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSource(previewYAML)
+	if _, err := New(Options{Tests: true, GoModulePath: "FIG"}).Generate(format); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(format.OutputDir, "helpers.go"), []byte("package preview\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		tests  bool
+		edit   string                // Generated file to edit by hand first
+		want   map[string]ChangeKind // By file name; every other generated file is unchanged
+	}{
+		{name: "unchanged", source: previewYAML},
+		{name: "unchanged with tests", source: previewYAML, tests: true},
+		{
+			name:   "edited source",
+			source: strings.Replace(previewYAML, "type: uint32", "type: uint16", 1),
+			want:   map[string]ChangeKind{"preview.yml": Changed, "Header.go": Changed},
+		},
+		{
+			name:   "struct removed from the source",
+			source: previewYAML[:strings.Index(previewYAML, "  Palette:")],
+			want:   map[string]ChangeKind{"preview.yml": Changed, "Palette.go": Deleted},
+		},
+		{
+			name:   "file edited by hand",
+			source: strings.Replace(previewYAML, "type: uint32", "type: uint16", 1),
+			edit:   "Header.go",
+			want:   map[string]ChangeKind{"preview.yml": Changed, "Header.go": Kept},
+		},
+		{
+			name:   "struct added to the source",
+			source: previewYAML + "  Trailer:\n    fields:\n      - {name: End, type: uint8}\n",
+			want:   map[string]ChangeKind{"preview.yml": Changed, "Trailer.go": Created},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeSource(tt.source)
+			if tt.edit != "" {
+				path := filepath.Join(format.OutputDir, tt.edit)
+				generated, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				edited := append(append([]byte(nil), generated...), "// Edited by hand\n"...)
+				if err := ioutil.WriteFile(path, edited, 0644); err != nil {
+					t.Fatal(err)
+				}
+				defer ioutil.WriteFile(path, generated, 0644)
+			}
+			before := snapshot(t, format.OutputDir)
+			g := New(Options{
+				Tests:        tt.tests,
+				GoModulePath: "FIG",
+				ConfirmTests: func(string) bool {
+					t.Errorf("Preview() asked whether to generate test scripts")
+					return true
+				},
+			})
+			changes, result, err := g.Preview(format)
+			if err != nil {
+				t.Fatalf("Preview() error = %v", err)
+			}
+			if len(result.Formats) != 1 {
+				t.Fatalf("Preview() Result has %d format(s), want 1", len(result.Formats))
+			}
+
+			got := make(map[string]ChangeKind)
+			for _, change := range changes {
+				name := filepath.Base(change.Path)
+				if _, seen := got[name]; seen {
+					t.Errorf("Preview() lists %s twice", name)
+				}
+				got[name] = change.Kind
+				if change.Kind != tt.want[name] && (tt.want[name] != "" || change.Kind != Unchanged) {
+					t.Errorf("Preview() %s: %s, want %s", name, change.Kind, tt.want[name])
+				}
+			}
+			for name, kind := range tt.want {
+				if got[name] != kind {
+					t.Errorf("Preview() %s: %q, want %s", name, got[name], kind)
+				}
+			}
+			if got["preview_test.go"] != Unchanged {
+				t.Errorf("Preview() test script: %q, want unchanged", got["preview_test.go"])
+			}
+			if kind, listed := got["helpers.go"]; listed {
+				t.Errorf("Preview() lists the hand-written helpers.go: %s", kind)
+			}
+
+			after := snapshot(t, format.OutputDir)
+			if len(after) != len(before) {
+				t.Fatalf("Preview() changed the files of the output directory: %v, then %v", before, after)
+			}
+			for name, content := range before {
+				if after[name] != content {
+					t.Errorf("Preview() changed %s", name)
+				}
+			}
+		})
+	}
+}
+
+// TestPreviewThenGenerate checks that generating after a preview writes what
+// the preview reported, file by file.
+func TestPreviewThenGenerate(t *testing.T) {
+	dir := t.TempDir()
+	format := config.FormatConfig{
+		Name:        "Preview",
+		YAMLFile:    filepath.Join(dir, "sources", "preview.yml"),
+		OutputDir:   filepath.Join(dir, "formats", "preview"),
+		PackageName: "preview",
+	}
+	writeFile(t, format.YAMLFile, previewYAML)
+	if _, err := New(Options{Tests: true, GoModulePath: "FIG"}).Generate(format); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	writeFile(t, filepath.Join(format.OutputDir, "helpers.go"), "package preview\n")
+	header, err := ioutil.ReadFile(filepath.Join(format.OutputDir, "Header.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(format.OutputDir, "Header.go"), string(header)+"// Edited by hand\n")
+	source := strings.Replace(previewYAML, "  Palette:", "  Trailer:\n    fields:\n      - {name: End, type: uint8}\n  Palette:", 1)
+	writeFile(t, format.YAMLFile, strings.Replace(source, "length: 4", "length: 8", 1))
+
+	g := New(Options{GoModulePath: "FIG"})
+	changes, _, err := g.Preview(format)
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if _, err := g.Generate(format); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	kinds := make(map[string]ChangeKind)
+	for _, change := range changes {
+		name := filepath.Base(change.Path)
+		kinds[name] = change.Kind
+		content, err := ioutil.ReadFile(change.Path)
+		switch {
+		case change.Kind == Deleted:
+			if !os.IsNotExist(err) {
+				t.Errorf("Generate() didn't delete %s, which Preview() reported deleted: %v", name, err)
+			}
+		case err != nil:
+			t.Errorf("Generate() didn't write %s, which Preview() reported %s: %v", name, change.Kind, err)
+		case change.Kind == Kept && string(content) != string(change.Old):
+			t.Errorf("Generate() changed %s, which Preview() reported kept", name)
+		case change.Kind != Kept && string(content) != string(change.New):
+			t.Errorf("Generate() wrote %s differently from what Preview() reported %s:\n%s", name, change.Kind, change.Diff())
+		}
+	}
+	want := map[string]ChangeKind{
+		"preview.yml":     Changed,
+		"Header.go":       Kept,
+		"Palette.go":      Changed,
+		"Trailer.go":      Created,
+		"preview_test.go": Unchanged,
+	}
+	for name, kind := range want {
+		if kinds[name] != kind {
+			t.Errorf("Preview() %s: %q, want %s", name, kinds[name], kind)
+		}
+	}
+
+	// Every file generation left in the output directory was previewed
+	for name := range snapshot(t, format.OutputDir) {
+		if _, listed := kinds[name]; !listed && name != "helpers.go" && name != utils.ManifestFileName {
+			t.Errorf("Generate() left %s, which Preview() didn't report", name)
+		}
+	}
+}
+
+func TestPreviewMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	format := config.FormatConfig{Name: "Missing", YAMLFile: filepath.Join(dir, "missing.yml"), OutputDir: filepath.Join(dir, "missing"), PackageName: "missing"}
+	changes, result, err := New(Options{}).Preview(format)
+	if err == nil || len(changes) > 0 {
+		t.Fatalf("Preview() = %v, %v, want an error", changes, err)
+	}
+	if len(result.Formats) != 1 || result.Formats[0].Err == nil {
+		t.Errorf("Preview() Result = %+v, want the format to fail", result)
+	}
+}
+
+// snapshot returns the content of the files of dir, by name.
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(content)
+	}
+	return files
+}
@@ -0,0 +1,63 @@
+// generator/enum_template.go
+package generator
+
+// EnumTemplateData holds the info needed to generate the enum types of a format.
+type EnumTemplateData struct {
+	PackageName string
+	Enums       []EnumData // In name order
+}
+
+// EnumData describes one generated enum type.
+type EnumData struct {
+	Name        string
+	Type        string // Underlying integer type
+	Description string
+	Values      []EnumValueData
+}
+
+// EnumValueData describes one constant of an enum.
+type EnumValueData struct {
+	ConstName   string // Go constant name (e.g., "CompressionRGB")
+	Name        string // Name returned by String (e.g., "RGB")
+	Literal     string // Go literal of the value (e.g., "0", "0xFFD8")
+	Description string
+}
+
+// EnumTemplate stores the Go code template for the enum types of a format:
+// a named type, a constant per value, and String and IsValid methods.
+var EnumTemplate = `// Code generated by FormatModule tool. DO NOT EDIT.
+package {{.PackageName}}
+
+import "fmt"
+{{range .Enums}}
+{{- $enum := .}}
+// {{.Name}} is {{if .Description}}{{.Description}}{{else}}an enumeration of {{.Type}} values{{end}}.
+type {{.Name}} {{.Type}}
+
+// Values of {{.Name}}.
+const (
+	{{- range .Values}}
+	{{.ConstName}} {{$enum.Name}} = {{.Literal}}{{if .Description}} // {{.Description}}{{end}}
+	{{- end}}
+)
+
+// String returns the name of the value, or "{{.Name}}(<number>)" if it is not declared.
+func (v {{.Name}}) String() string {
+	switch v {
+	{{- range .Values}}
+	case {{.ConstName}}:
+		return {{printf "%q" .Name}}
+	{{- end}}
+	}
+	return fmt.Sprintf("{{.Name}}(%d)", {{.Type}}(v))
+}
+
+// IsValid reports whether the value is one of the declared values of {{.Name}}.
+func (v {{.Name}}) IsValid() bool {
+	switch v {
+	case {{range $i, $v := .Values}}{{if $i}}, {{end}}{{$v.ConstName}}{{end}}:
+		return true
+	}
+	return false
+}
+{{end}}`
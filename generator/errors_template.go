@@ -0,0 +1,28 @@
+// generator/errors_template.go
+package generator
+
+// ErrorsTemplateData holds the info needed to generate the error types of a format.
+type ErrorsTemplateData struct {
+	PackageName string
+}
+
+// ErrorsTemplate stores the Go code template for the error types returned by
+// the generated Read methods.
+var ErrorsTemplate = `// Code generated by FormatModule tool. DO NOT EDIT.
+package {{.PackageName}}
+
+import "fmt"
+
+// ValueMismatchError is returned by Read when a field declared with a constant
+// value, such as a signature or magic number, holds something else.
+type ValueMismatchError struct {
+	Struct string      // Struct being read
+	Field  string      // Constant field
+	Want   interface{} // Declared value
+	Got    interface{} // Value read
+}
+
+func (e *ValueMismatchError) Error() string {
+	return fmt.Sprintf("reading %s.%s: expected %#v, got %#v", e.Struct, e.Field, e.Want, e.Got)
+}
+`
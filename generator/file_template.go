@@ -0,0 +1,51 @@
+// generator/file_template.go
+package generator
+
+// FileTemplateData holds the info needed to generate the File type for a format's layout.
+type FileTemplateData struct {
+	PackageName string
+	FormatName  string
+	TypeName    string   // Name of the generated type (app_structs.FileTypeName)
+	Parts       []string // Layout structs, in file order
+}
+
+// FileTemplate stores the Go code template for the File type, which reads and
+// writes every struct of the format's layout in order.
+var FileTemplate = `// Code generated by FormatModule tool. DO NOT EDIT.
+package {{.PackageName}}
+
+import (
+	"fmt"
+	"io"
+)
+
+// {{.TypeName}} represents a complete {{.FormatName}} file: {{range $i, $p := .Parts}}{{if $i}}, {{end}}{{$p}}{{end}}, in that order.
+type {{.TypeName}} struct {
+	{{- range .Parts}}
+	{{.}} {{.}}
+	{{- end}}
+}
+
+// ReadFile populates every part of the file by reading them from an io.Reader in layout order.
+// Each part is read with the {{.TypeName}} itself as its context, so length and count
+// expressions can refer to parts read before it (e.g., ctx.{{index .Parts 0}}.SomeField).
+func (f *{{.TypeName}}) ReadFile(r io.Reader) error {
+	{{- range .Parts}}
+	if err := f.{{.}}.Read(r, f); err != nil {
+		return fmt.Errorf("reading {{.}}: %w", err)
+	}
+	{{- end}}
+	return nil
+}
+
+// WriteFile serializes every part of the file into an io.Writer in layout order.
+// Each part is written with the {{.TypeName}} as its context, like ReadFile reads it.
+func (f *{{.TypeName}}) WriteFile(w io.Writer) error {
+	{{- range .Parts}}
+	if err := f.{{.}}.Write(w, f); err != nil {
+		return fmt.Errorf("writing {{.}}: %w", err)
+	}
+	{{- end}}
+	return nil
+}
+`
@@ -0,0 +1,168 @@
+package generator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"FIG/config"
+	"FIG/utils"
+)
+
+// Options controls the generation of one format.
+type Options struct {
+	Tests            bool   // Also generate the test script
+	AllowUnknownKeys bool   // Validation option, used if the YAML has to be validated
+	GoModulePath     string // Module path the test script imports the generated package with
+	// Source is the source YAML of the format. If set, it is validated and
+	// reformed instead of reading the YAMLFile, which then only names the
+	// source in messages.
+	Source []byte
+	// FS receives the generated files, including the reformed YAML if it is
+	// created. nil writes to disk, after removing the .go files of the output
+	// directory; other file systems are only written to.
+	FS     WriteFS
+	Logger *log.Logger // Progress messages; nil uses the standard logger
+}
+
+// GenerateFormat resets the output directory of one format and generates its
+// code and, with opts.Tests, its test script. It returns the paths of the files
+// written, including those written before an error.
+func GenerateFormat(cfg config.FormatConfig, opts Options) ([]string, error) {
+	out := newOutput(opts.FS, opts.Logger)
+	err := generateFormat(out, cfg, opts)
+	return out.files, err
+}
+
+// generateFormat does the work of GenerateFormat, writing the files to out. On
+// disk, it only replaces the files FIG generated before, and records the files
+// it generates in the manifest of the output directory.
+func generateFormat(out *output, config config.FormatConfig, opts Options) error {
+	if _, onDisk := out.fs.(DiskFS); !onDisk {
+		return generateFiles(out, config, opts)
+	}
+
+	// --- Reset Generated Go Files ---
+	out.logf("Running generator reset for %s...", config.OutputDir)
+	plan, err := utils.Reset(config.OutputDir, out.logger, KeptFiles(config, opts)...) // Reset cleans only generated .go files in the target dir
+	if err != nil {
+		return err
+	}
+	out.logf("Reset complete.")
+
+	// Only the files the reset left are kept; the others, including the
+	// reformed YAML, are written again
+	out.keep = make(map[string]bool)
+	for _, path := range plan.Left() {
+		out.keep[filepath.Clean(path)] = true
+	}
+	err = generateFiles(out, config, opts)
+	// Record what was written even if generation failed, so it is replaced next time
+	if manifestErr := updateManifest(out, config.OutputDir, plan); manifestErr != nil && err == nil {
+		err = manifestErr
+	}
+	return err
+}
+
+// KeptFiles returns the names of the generated files that generating a format
+// with opts doesn't generate again, and so keeps: the test script, without
+// opts.Tests.
+func KeptFiles(cfg config.FormatConfig, opts Options) []string {
+	if opts.Tests {
+		return nil
+	}
+	return []string{TestScriptName(cfg.PackageName)}
+}
+
+// updateManifest records the Go files just generated in the manifest of
+// outputDir, along with the generated files kept, so they are still recognized
+// next time.
+func updateManifest(out *output, outputDir string, plan utils.ResetPlan) error {
+	previous, err := utils.ReadManifest(outputDir)
+	if err != nil {
+		return err
+	}
+	manifest := utils.Manifest{Files: make(map[string]string)}
+	for _, path := range append(plan.Modified, plan.Kept...) {
+		name := filepath.Base(path)
+		if hash, listed := previous.Files[name]; listed {
+			manifest.Files[name] = hash
+		}
+	}
+	for _, path := range out.files {
+		if filepath.Ext(path) == ".go" {
+			manifest.Files[filepath.Base(path)] = out.hashes[path]
+		}
+	}
+	return utils.WriteManifest(outputDir, manifest)
+}
+
+// generateFiles generates the reformed YAML if needed, the code and the test
+// script of a format.
+func generateFiles(out *output, config config.FormatConfig, opts Options) error {
+	// --- Determine Reformed YAML ---
+	reformedYamlPath := filepath.Join(config.OutputDir, filepath.Base(config.YAMLFile))
+	reformed, err := reformedYAML(out, config, opts, reformedYamlPath)
+	if err != nil {
+		return err
+	}
+
+	// --- Generate Code ---
+	out.logf("Starting code generation...")
+	if err := generateCode(out, reformedYamlPath, reformed, config.OutputDir, config.PackageName); err != nil {
+		return fmt.Errorf("error generating code: %w", err) // Skip test generation if code generation failed
+	}
+	out.logf("%s: Code generation completed successfully.", config.Name)
+
+	// --- Generate Test Script ---
+	if opts.Tests {
+		out.logf("Generating test script for %s...", config.Name)
+		if err := generateTestScript(out, reformedYamlPath, reformed, config.OutputDir, config.PackageName, opts.GoModulePath); err != nil {
+			return fmt.Errorf("failed to generate test script: %w", err)
+		}
+	}
+	return nil
+}
+
+// reformedYAML returns the reformed YAML of a format: opts.Source validated,
+// else the YAMLFile validated, so that edits to the source are generated, else
+// the reformed YAML already in the output directory if there is no YAMLFile.
+// YAML validated here is written to out at reformedYamlPath. Generation writes
+// to disk and previews (in memory) both select their input here, so a preview
+// shows what generation writes.
+func reformedYAML(out *output, config config.FormatConfig, opts Options, reformedYamlPath string) ([]byte, error) {
+	source := opts.Source
+	if source != nil {
+		out.logf("Validating/Reforming the source of %s given in memory...", config.Name)
+	} else {
+		var err error
+		source, err = ioutil.ReadFile(config.YAMLFile)
+		if os.IsNotExist(err) {
+			reformed, err := ioutil.ReadFile(reformedYamlPath)
+			if err != nil {
+				return nil, fmt.Errorf("source YAML %s not found, and failed to read reformed YAML: %w", config.YAMLFile, err)
+			}
+			out.logf("Warning: Source YAML %s not found. Using reformed YAML: %s", config.YAMLFile, reformedYamlPath)
+			return reformed, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source YAML %s: %w", config.YAMLFile, err)
+		}
+		out.logf("Validating/Reforming source YAML: %s", config.YAMLFile)
+	}
+
+	validationOpts := utils.ValidationOptions{AllowUnknownKeys: opts.AllowUnknownKeys, Logger: out.logger}
+	reformed, _, err := utils.ValidateYAMLSource(config.YAMLFile, source, validationOpts)
+	if err != nil {
+		return nil, fmt.Errorf("on-the-fly validation/reformation failed: %w", err)
+	}
+
+	if written, err := out.writeFile(reformedYamlPath, reformed); err != nil {
+		return nil, err
+	} else if written {
+		out.logf("Saved validated/reformed YAML to: %s", reformedYamlPath)
+	}
+	return reformed, nil
+}
@@ -0,0 +1,120 @@
+package generator
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"FIG/config"
+	"FIG/utils"
+)
+
+const regenerationYAML = `name: Regen
+structs:
+  Header:
+    fields:
+      - {name: Magic, type: string, value: RG}
+      - {name: Size, type: uint32}
+  Body:
+    fields:
+      - {name: Data, type: "[]byte", length: 4}
+`
+
+func TestGenerateFormatRegeneration(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "formats", "regen")
+	cfg := config.FormatConfig{Name: "Regen", YAMLFile: "sources/regen.yml", OutputDir: dir, PackageName: "regen"}
+	opts := Options{Source: []byte(regenerationYAML), GoModulePath: "FIG", Logger: log.New(io.Discard, "", 0)}
+	generate := func(tests bool) {
+		t.Helper()
+		opts.Tests = tests
+		if _, err := GenerateFormat(cfg, opts); err != nil {
+			t.Fatalf("GenerateFormat() error = %v", err)
+		}
+	}
+	read := func(name string) string {
+		t.Helper()
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	generate(true)
+	manifest, err := utils.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Header.go", "Body.go", "errors.go", "regen_test.go"} {
+		if manifest.Files[name] != utils.ContentHash([]byte(read(name))) {
+			t.Errorf("manifest doesn't record %s", name)
+		}
+	}
+	if _, listed := manifest.Files["regen.yml"]; listed {
+		t.Errorf("manifest records the reformed YAML")
+	}
+
+	// Hand-written and hand-edited files survive regeneration
+	edited := read("Body.go") + "\n// Edited by hand\n"
+	write("Body.go", edited)
+	write("helpers.go", "package regen\n")
+	write("kind_string.go", "// Code generated by \"stringer -type=Kind\"; DO NOT EDIT.\n\npackage regen\n")
+	generate(false)
+	if got := read("Body.go"); got != edited {
+		t.Errorf("regeneration replaced Body.go, which was edited by hand")
+	}
+	for _, name := range []string{"helpers.go", "kind_string.go", "regen_test.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("regeneration removed %s: %v", name, err)
+		}
+	}
+
+	// The edited file is still recognized as generated, so it is kept again
+	// rather than taken for a user file, and generated again once deleted
+	manifest, err = utils.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := manifest.Ownership("Body.go", []byte(edited)); got != utils.ModifiedByHand {
+		t.Errorf("Ownership(Body.go) = %v, want ModifiedByHand", got)
+	}
+	if got := manifest.Ownership("regen_test.go", []byte(read("regen_test.go"))); got != utils.GeneratedFile {
+		t.Errorf("Ownership(regen_test.go) = %v after regenerating without tests, want GeneratedFile", got)
+	}
+	if err := os.Remove(filepath.Join(dir, "Body.go")); err != nil {
+		t.Fatal(err)
+	}
+	generate(false)
+	if got := read("Body.go"); got == edited {
+		t.Errorf("regeneration didn't generate Body.go again after it was deleted")
+	}
+
+	// The reformed YAML is written again from an edited source, like the code
+	opts.Source = []byte(strings.Replace(regenerationYAML, "type: uint32", "type: uint16", 1))
+	generate(false)
+	if got := read("regen.yml"); !strings.Contains(got, "type: uint16") || strings.Contains(got, "type: uint32") {
+		t.Errorf("regeneration kept the reformed YAML of the previous source:\n%s", got)
+	}
+	if got := read("Header.go"); !strings.Contains(got, "uint16") || strings.Contains(got, "uint32") {
+		t.Errorf("regeneration didn't generate Header.go from the edited source:\n%s", got)
+	}
+
+	// A corrupt manifest stops regeneration before anything is removed
+	write(utils.ManifestFileName, "{")
+	if _, err := GenerateFormat(cfg, opts); err == nil {
+		t.Errorf("GenerateFormat() with a corrupt manifest succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Header.go")); err != nil {
+		t.Errorf("GenerateFormat() with a corrupt manifest removed Header.go: %v", err)
+	}
+}
@@ -3,28 +3,297 @@ package generator
 import (
 	"bytes"
 	"fmt"
-	"go/format"
-	"io/ioutil"
-	"log"
 	"path/filepath"
-	"sort"
+	"regexp"
+	"strconv"
+	"strings"
 	"text/template"
 
+	"FIG/app_structs"
+	"FIG/utils"
+
 	"gopkg.in/yaml.v2"
 )
 
-func generateTestScript(reformedYamlPath, outputDir, packageName, goModulePath string) error {
-	// 1. Parse the reformed YAML to find struct names
-	yamlData, err := ioutil.ReadFile(reformedYamlPath)
-	if err != nil {
-		return fmt.Errorf("failed to read reformed YAML %s: %w", reformedYamlPath, err)
+// numericSampleValues are the Go literals used to populate numeric fields in
+// generated round-trip tests. They are chosen so that byte order mistakes and
+// sign handling errors change the decoded value.
+var numericSampleValues = map[string]string{
+	"uint8":   "0x12",
+	"uint16":  "0x1234",
+	"uint32":  "0x12345678",
+	"uint64":  "0x123456789ABCDEF0",
+	"int8":    "-0x12",
+	"int16":   "-0x1234",
+	"int32":   "-0x12345678",
+	"int64":   "-0x123456789ABCDEF0",
+	"float32": "3.25",
+	"float64": "-6.5e10",
+}
+
+// sampleValue returns a Go literal for the field suitable for a round-trip
+// test, or false if the field cannot be populated without format knowledge
+// (dynamic lengths, conditions, unknown types).
+func sampleValue(fileFormat app_structs.FileFormat, packageName string, field app_structs.Field) (string, bool) {
+	if field.IsConditional() {
+		return "", false
+	}
+	if field.IsRepeated() {
+		count := 2 // Any number of elements round-trips when reading until end of stream
+		if !field.IsCountToEOF() {
+			var err error
+			if count, err = strconv.Atoi(field.Count); err != nil {
+				return "", false // Count depends on other fields
+			}
+		}
+		elemType := field.ElementType()
+		elemValue, ok := sampleValue(fileFormat, packageName, app_structs.Field{Name: field.Name, Type: elemType, Enum: field.Enum})
+		if !ok {
+			return "", false
+		}
+		if fileFormat.IsStructType(elemType) || field.Enum != "" {
+			elemType = packageName + "." + field.GoElementType()
+		}
+		elems := make([]string, count)
+		for i := range elems {
+			elems[i] = elemValue
+		}
+		return fmt.Sprintf("[]%s{%s}", elemType, strings.Join(elems, ", ")), true
+	}
+	if field.Value != "" { // Read rejects anything but the constant
+		literal, err := field.ValueLiteral()
+		if err != nil {
+			return "", false
+		}
+		if field.Type == "[]byte" {
+			return "[]byte(" + literal + ")", true
+		}
+		return literal, true
+	}
+	if enum, ok := fileFormat.Enums[field.Enum]; ok && len(enum.Values) > 0 {
+		return packageName + "." + enum.Values[0].ConstName(field.Enum), true // Strict enums reject other values
+	}
+	if value, ok := numericSampleValues[field.Type]; ok {
+		return value, true
+	}
+	if fileFormat.IsStructType(field.Type) {
+		fields, ok := sampleFields(fileFormat, packageName, fileFormat.Structs[field.Type])
+		if !ok {
+			return "", false
+		}
+		parts := make([]string, len(fields))
+		for i, f := range fields {
+			parts[i] = f.Name + ": " + f.Value
+		}
+		return fmt.Sprintf("%s.%s{%s}", packageName, field.Type, strings.Join(parts, ", ")), true
+	}
+	if field.Type == "string" && field.IsSelfDelimited() {
+		value := "FIG"
+		if field.MaxLength > 0 && field.MaxLength < len(value) {
+			value = value[:field.MaxLength]
+		}
+		return strconv.Quote(value), true
 	}
+	length, err := strconv.Atoi(field.Length)
+	if err != nil || length <= 0 {
+		return "", false
+	}
+	switch field.Type {
+	case "string":
+		fill := "A"
+		if field.PadByte() == 'A' { // Padded strings lose trailing pad bytes on Read
+			fill = "B"
+		}
+		return strconv.Quote(strings.Repeat(fill, length)), true
+	case "[]byte":
+		return fmt.Sprintf("bytes.Repeat([]byte{0xA5}, %d)", length), true
+	}
+	return "", false
+}
 
-	// Use a temporary struct to get just the struct keys
-	var tempFormat struct {
-		Structs map[string]interface{} `yaml:"structs"`
+// sampleFields returns sample values for every field of structDef, or false
+// if any field cannot be populated.
+func sampleFields(fileFormat app_structs.FileFormat, packageName string, structDef app_structs.Struct) ([]TestFieldData, bool) {
+	fields := make([]TestFieldData, 0, len(structDef.Fields))
+	for _, field := range structDef.Fields {
+		value, ok := sampleValue(fileFormat, packageName, field)
+		if !ok {
+			return nil, false
+		}
+		fields = append(fields, TestFieldData{Name: field.Name, Value: value})
 	}
-	err = yaml.Unmarshal(yamlData, &tempFormat)
+	return fields, true
+}
+
+// requiredFields returns the values of the fields of structDef that Read
+// rejects the zero value of, leaving out conditional ones: constants, and
+// strict enums that don't declare 0.
+func requiredFields(fileFormat app_structs.FileFormat, packageName string, structDef app_structs.Struct) []TestFieldData {
+	var fields []TestFieldData
+	for _, field := range structDef.Fields {
+		comment := "Constant: Write always writes this value and Read returns it"
+		if field.Value == "" {
+			enum, ok := fileFormat.Enums[field.Enum]
+			if !ok || !enum.Strict || field.IsRepeated() || enum.HasValue(0) {
+				continue
+			}
+			comment = "Strict enum: Read rejects values that are not declared"
+		}
+		if value, ok := sampleValue(fileFormat, packageName, field); ok {
+			fields = append(fields, TestFieldData{Name: field.Name, Value: value, Comment: comment})
+		}
+	}
+	return fields
+}
+
+// roundTripStructs returns test data for every struct whose fields can all be
+// populated by sampleValue.
+func roundTripStructs(fileFormat app_structs.FileFormat, packageName string, structNames []string) []TestStructData {
+	var result []TestStructData
+	for _, name := range structNames {
+		structDef := fileFormat.Structs[name]
+		if len(structDef.Fields) == 0 {
+			continue
+		}
+		if fields, ok := sampleFields(fileFormat, packageName, structDef); ok {
+			result = append(result, TestStructData{Name: name, Fields: fields})
+		}
+	}
+	return result
+}
+
+// fileParts returns sample values for every struct of the format's layout,
+// or nil if there is no layout or any part cannot be populated.
+func fileParts(fileFormat app_structs.FileFormat, packageName string) []TestFieldData {
+	var parts []TestFieldData
+	for _, part := range fileFormat.Layout {
+		value, ok := sampleValue(fileFormat, packageName, app_structs.Field{Name: part, Type: part})
+		if !ok {
+			return nil
+		}
+		parts = append(parts, TestFieldData{Name: part, Value: value})
+	}
+	return parts
+}
+
+// numericSampleLiteral returns a literal of the struct (or generated context
+// type) with every numeric field set to its sample value and the other fields
+// left zero, e.g. "bmp.InfoHeader{Width: 0x12345678}". It gives expressions
+// realistic inputs.
+func numericSampleLiteral(fileFormat app_structs.FileFormat, packageName, structName string) string {
+	var parts []string
+	fields, _ := fileFormat.TypeFields(structName)
+	for _, field := range fields {
+		if value, ok := numericSampleValues[field.Type]; ok {
+			parts = append(parts, field.Name+": "+value)
+		}
+	}
+	return fmt.Sprintf("%s.%s{%s}", packageName, structName, strings.Join(parts, ", "))
+}
+
+// expressionBenchmarks returns a benchmark for every length/count expression
+// of the format whose ctx can be reproduced: s is the struct itself, and ctx is
+// the File holding the layout structs read before it, or a sample of the
+// struct's declared context type. Expressions referring to ctx in other
+// structs, or calling the format's declared functions (which only the
+// generated package can resolve), are skipped.
+func expressionBenchmarks(fileFormat app_structs.FileFormat, packageName string, structNames []string) []ExpressionBenchmarkData {
+	if fileFormat.IsNativeMode() {
+		return nil // Native expressions are plain Go; there is nothing to precompile
+	}
+	var result []ExpressionBenchmarkData
+	for _, structName := range structNames {
+		ctx := "nil"
+		for i, part := range fileFormat.Layout {
+			if part != structName {
+				continue
+			}
+			var earlier []string
+			for _, prev := range fileFormat.Layout[:i] {
+				earlier = append(earlier, prev+": "+numericSampleLiteral(fileFormat, packageName, prev))
+			}
+			ctx = fmt.Sprintf("&%s.%s{%s}", packageName, app_structs.FileTypeName, strings.Join(earlier, ", "))
+		}
+		if contextType := fileFormat.ContextType(structName); ctx == "nil" && contextType != "" && contextType != app_structs.FileTypeName {
+			ctx = "&" + numericSampleLiteral(fileFormat, packageName, contextType)
+		}
+
+		for _, field := range fileFormat.Structs[structName].Fields {
+			kind, expr := "length", field.Length
+			if field.IsRepeated() {
+				kind, expr = "count", field.Count
+				if !field.IsExpressionCount() {
+					continue
+				}
+			} else if _, err := strconv.Atoi(expr); err == nil || expr == "" || expr == "NEEDS_MANUAL_LENGTH" {
+				continue
+			}
+			if ctx == "nil" && utils.ExpressionUsesContext(expr) || callsDeclaredFunction(fileFormat, expr) {
+				continue
+			}
+			result = append(result, ExpressionBenchmarkData{
+				Name:   structName + "_" + field.Name + strings.Title(kind),
+				Struct: structName,
+				Field:  field.Name,
+				Kind:   kind,
+				Expr:   expr,
+				S:      "&" + numericSampleLiteral(fileFormat, packageName, structName),
+				Ctx:    ctx,
+			})
+		}
+	}
+	return result
+}
+
+// bitFieldTests returns test data for every struct with bit fields.
+func bitFieldTests(fileFormat app_structs.FileFormat, structNames []string) []BitFieldTestData {
+	var result []BitFieldTestData
+	for _, structName := range structNames {
+		test := BitFieldTestData{Struct: structName}
+		for _, field := range fileFormat.Structs[structName].Fields {
+			if len(field.Bits) == 0 {
+				continue
+			}
+			testField := BitFieldTestField{Field: field.Name}
+			packed := uint64(0)
+			for _, bitField := range field.Bits {
+				lo, hi, _ := bitField.Range()
+				mask := uint64(1)<<uint(hi-lo+1) - 1
+				packed |= mask << uint(lo)
+				accessor := BitFieldTestAccessor{Name: bitField.Name, Max: fmt.Sprintf("0x%X", mask), Zero: "0"}
+				if bitField.IsFlag() {
+					accessor.Max, accessor.Zero = "true", "false"
+				}
+				testField.Accessors = append(testField.Accessors, accessor)
+			}
+			testField.Packed = fmt.Sprintf("0x%X", packed)
+			test.Fields = append(test.Fields, testField)
+		}
+		if len(test.Fields) > 0 {
+			result = append(result, test)
+		}
+	}
+	return result
+}
+
+// callsDeclaredFunction reports whether expr calls one of the functions
+// declared in the format's functions section.
+func callsDeclaredFunction(fileFormat app_structs.FileFormat, expr string) bool {
+	for name := range fileFormat.Functions {
+		if regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\s*\(`).MatchString(expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateTestScript writes the test file of a generated package, with
+// round-trip tests and benchmarks for the structs of the reformed YAML
+// (yamlData, read from reformedYamlPath).
+func generateTestScript(out *output, reformedYamlPath string, yamlData []byte, outputDir, packageName, goModulePath string) error {
+	// 1. Parse the reformed YAML to find struct names
+	var tempFormat app_structs.FileFormat
+	err := yaml.Unmarshal(yamlData, &tempFormat)
 	if err != nil {
 		return fmt.Errorf("failed to parse structs from reformed YAML %s: %w", reformedYamlPath, err)
 	}
@@ -33,20 +302,27 @@ func generateTestScript(reformedYamlPath, outputDir, packageName, goModulePath s
 		return fmt.Errorf("no structs found in reformed YAML %s, cannot generate test", reformedYamlPath)
 	}
 
-	// Get struct names and sort them alphabetically for consistency
-	structNames := make([]string, 0, len(tempFormat.Structs))
-	for name := range tempFormat.Structs {
-		structNames = append(structNames, name)
-	}
-	sort.Strings(structNames)
-	firstStructName := structNames[0] // Use the first one alphabetically
+	// Get struct names in the order they are declared in the YAML
+	structNames := tempFormat.OrderedStructNames()
+	firstStructName := structNames[0] // Use the first one declared
 
 	// 2. Prepare data for the test template
 	testData := TestTemplateData{
 		PackageName:     packageName,
 		FormatDir:       filepath.Base(filepath.Dir(outputDir)), // e.g., "formats"
 		FirstStructName: firstStructName,
+		CtxType:         "interface{}",
+		FirstStructRequired: requiredFields(tempFormat, packageName, tempFormat.Structs[firstStructName]),
 		GoModulePath:    goModulePath,
+		RoundTripStructs: roundTripStructs(tempFormat, packageName, structNames),
+		FileParts:        fileParts(tempFormat, packageName),
+		FileTypeName:     app_structs.FileTypeName,
+		ExpressionBenchmarks: expressionBenchmarks(tempFormat, packageName, structNames),
+		BitFieldTests:        bitFieldTests(tempFormat, structNames),
+	}
+
+	if contextType := tempFormat.ContextType(firstStructName); contextType != "" {
+		testData.CtxType = "*" + packageName + "." + contextType
 	}
 
 	// 3. Parse the test template
@@ -62,19 +338,13 @@ func generateTestScript(reformedYamlPath, outputDir, packageName, goModulePath s
 		return fmt.Errorf("failed to execute test template for %s: %w", packageName, err)
 	}
 
-	// 5. Format the generated code
-	formattedOutput, errFmt := format.Source(output.Bytes())
-	if errFmt != nil {
-		log.Printf("Warning: Failed to format generated test code for %s: %v. Writing unformatted code.", packageName, errFmt)
-		formattedOutput = output.Bytes() // Fallback
-	}
-
-	// 6. Write the test file
-	testFilePath := filepath.Join(outputDir, fmt.Sprintf("%s_test.go", packageName))
-	err = ioutil.WriteFile(testFilePath, formattedOutput, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write test file %s: %w", testFilePath, err)
-	}
+	// 5. Format and write the test file
+	testFilePath := filepath.Join(outputDir, TestScriptName(packageName))
+	return out.writeGoFile(testFilePath, output.Bytes(), "test code for "+packageName)
+}
 
-	return nil
-}
\ No newline at end of file
+// TestScriptName returns the file name of the test script generated for a
+// package.
+func TestScriptName(packageName string) string {
+	return packageName + "_test.go"
+}
@@ -5,12 +5,10 @@ import (
 	"bytes"
 	"fmt"
 	// "go/ast" // No longer needed for stub parsing
-	"go/format"
 	// "go/parser" // No longer needed for stub parsing
 	// "go/token" // No longer needed for stub parsing
 	"io/ioutil"
-	"log"
-	"os"
+	"math"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -18,12 +16,13 @@ import (
 	"text/template"
 
 	"FIG/app_structs"
+	"FIG/utils"
 
 	 
 	"gopkg.in/yaml.v2"
 )
 
-// TemplateData holds all necessary info for template execution (no changes needed)
+// TemplateData holds all necessary info for template execution
 type TemplateData struct {
 	PackageName      string
 	Imports          []string
@@ -35,15 +34,112 @@ type TemplateData struct {
 	NeedsErrVarRead  bool // True if any read operation generates code that uses 'err'
 	NeedsErrVarWrite bool // True if any write operation generates code that uses 'err'
 	NeedsBVar        bool // True if any string read operation generates code that uses 'b'
-	NeedsTmpUint8    bool 
+	// Expressions lists the length, count and condition expressions of the
+	// struct, which are parsed once into package-level variables.
+	Expressions []ExpressionVar
+	// NativeCtxType is the struct type Read asserts ctx to, set in native
+	// expression mode when an expression or condition refers to ctx and the
+	// struct declares no context.
+	NativeCtxType string
+	// ContextType is the declared type of Read's ctx parameter (a pointer to
+	// it is passed), or "" for ctx interface{}.
+	ContextType string
+	// ContextFields are the fields of the generated ContextType, set when the
+	// context is declared as a list of fields.
+	ContextFields []app_structs.Field
+	// CtxRequired is set when ctx is typed and an expression refers to it, so
+	// Read rejects a nil ctx up front.
+	CtxRequired bool
+	// HasFunctions is set when the format declares functions, which govaluate
+	// expressions are then compiled with (see generateExpressionFunctions).
+	HasFunctions bool
+	// BitFields lists the accessors generated for the bits of the struct's fields.
+	BitFields []BitAccessor
 }
 
-// atoi helper function (keep as is)
+// BitAccessor describes the getter and setter of a bit field (see app_structs.BitField).
+type BitAccessor struct {
+	Name        string
+	Description string
+	Field       string // Field holding the packed bits
+	Type        string // Type of the field, used for values of multi-bit ranges
+	GoType      string // Go type of the field (differs from Type for enums)
+	Lo, Hi      int
+	Mask        string // Go literal of the mask of the range, before shifting (e.g., "0xF")
+	FieldMask   string // Go literal of the mask of the range within the field (e.g., "0xF0")
+	Flag        bool   // Single bit, accessed as a bool
+}
+
+// bitAccessors returns the accessors of the bit fields of field.
+func bitAccessors(field app_structs.Field) []BitAccessor {
+	var accessors []BitAccessor
+	for _, bitField := range field.Bits {
+		lo, hi, _ := bitField.Range() // Checked by the validator
+		accessors = append(accessors, BitAccessor{
+			Name:        bitField.Name,
+			Description: bitField.Description,
+			Field:       field.Name,
+			Type:        field.Type,
+			GoType:      field.GoType(),
+			Lo:          lo,
+			Hi:          hi,
+			Mask:        fmt.Sprintf("0x%X", uint64(1)<<uint(hi-lo+1)-1),
+			FieldMask:   fmt.Sprintf("0x%X", (uint64(1)<<uint(hi-lo+1)-1)<<uint(lo)),
+			Flag:        bitField.IsFlag(),
+		})
+	}
+	return accessors
+}
+
+// ExpressionVar describes a package-level variable holding a precompiled expression.
+type ExpressionVar struct {
+	Name  string // Go variable name (see expressionVarName)
+	Expr  string // The YAML expression
+	Field string // Field the expression belongs to
+	Kind  string // "length", "count" or "condition"
+}
+
+// FieldTemplateData is the data passed to the per-field readField/writeField sub-templates.
+type FieldTemplateData struct {
+	StructName string
+	Field      app_structs.Field
+	Label      string // Prefix used in generated error messages (e.g., "conditional field ")
+}
+
+// ExprTemplateData is the data passed to the evalExpr sub-template, which
+// evaluates a length or count expression into a local int variable, and to the
+// evalCondition sub-template, which evaluates a condition into a local bool.
+type ExprTemplateData struct {
+	FieldTemplateData
+	Expr string // The YAML expression
+	Kind string // "length", "count" or "condition", used in error messages
+	Var  string // Name of the variable that receives the result
+	// ExprVar is the package-level variable holding the precompiled expression
+	ExprVar string
+}
+
+// exprData returns the data evaluating the kind ("length" or "count")
+// expression of a field into size or count.
+func exprData(d FieldTemplateData, kind string) ExprTemplateData {
+	exprVar := expressionVarName(d.StructName, d.Field.Name, kind)
+	if kind == "count" {
+		return ExprTemplateData{FieldTemplateData: d, Expr: d.Field.Count, Kind: kind, Var: "count", ExprVar: exprVar}
+	}
+	return ExprTemplateData{FieldTemplateData: d, Expr: d.Field.Length, Kind: kind, Var: "size", ExprVar: exprVar}
+}
+
+// atoi converts a template string to an int, returning 0 if it is not a number
 func atoi(s string) int {
 	i, _ := strconv.Atoi(s)
 	return i
 }
 
+// expressionVarName returns the name of the package-level variable holding the
+// precompiled kind ("length", "count" or "condition") expression of a field, e.g. "strDynLengthExpr".
+func expressionVarName(structName, fieldName, kind string) string {
+	return strings.ToLower(structName[:1]) + structName[1:] + fieldName + strings.Title(kind) + "Expr"
+}
+
 // ExpectedStruct removed
 // parseStubFileForExpectedStructs removed
 
@@ -52,38 +148,35 @@ func atoi(s string) int {
 // GenerateCode takes the YAML description, generates Go code, and handles imports dynamically.
 // Assumes YAML is pre-validated. targetStubName is ignored.
 func GenerateCode(yamlFile, outputDir, packageName, targetStubName string) error {
-	// ... (Read YAML, Unmarshal YAML, Ensure output dir exists) ...
-	log.Printf("Starting code generation for validated YAML: %s, outputting to: %s (package %s)", yamlFile, outputDir, packageName)
-
 	// 1. Read the YAML file (this is the *reformed* YAML)
 	data, err := ioutil.ReadFile(yamlFile)
 	if err != nil {
 		return fmt.Errorf("error reading YAML file %s: %w", yamlFile, err)
 	}
-	log.Println("Successfully read YAML file.")
+	return generateCode(newOutput(nil, nil), yamlFile, data, outputDir, packageName)
+}
+
+// generateCode generates the Go code of a validated YAML (data, read from
+// yamlFile) into outputDir, writing the files to out.
+func generateCode(out *output, yamlFile string, data []byte, outputDir, packageName string) error {
+	out.logf("Starting code generation for validated YAML: %s, outputting to: %s (package %s)", yamlFile, outputDir, packageName)
 
 	// 2. Unmarshal the YAML data
 	var fileFormat app_structs.FileFormat
-	err = yaml.Unmarshal(data, &fileFormat)
+	err := yaml.Unmarshal(data, &fileFormat)
 	if err != nil {
 		yamlErr, ok := err.(*yaml.TypeError)
 		if ok {
 			for _, msg := range yamlErr.Errors {
-				log.Printf("YAML unmarshal error: %s", msg)
+				out.logf("YAML unmarshal error: %s", msg)
 			}
 		}
 		return fmt.Errorf("error unmarshaling YAML from %s: %w", yamlFile, err)
 	}
-	log.Println("Successfully unmarshaled YAML data.")
+	out.logf("Successfully unmarshaled YAML data.")
 
-	// Ensure output directory exists
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to ensure output directory %s exists: %w", outputDir, err)
-	}
 
-
-	// 3. Parse the main template once...
-	// ... (template parsing logic remains the same) ...
+	// 3. Parse the main template once
 	tmpl := template.New("struct").Funcs(template.FuncMap{
 		"atoi": atoi,
 		"isExpressionLength": func(f app_structs.Field) bool {
@@ -94,9 +187,80 @@ func GenerateCode(yamlFile, outputDir, packageName, targetStubName string) error
 		"isConditional": func(f app_structs.Field) bool {
 			return f.IsConditional()
 		},
-		"generateConditionCheck": func(condition string) string {
-			// Assume condition is reasonable (validated in bootstrap)
-			return condition
+		"isRepeated": func(f app_structs.Field) bool {
+			return f.IsRepeated()
+		},
+		"isCountToEOF": func(f app_structs.Field) bool {
+			return f.IsCountToEOF()
+		},
+		"isExpressionCount": func(f app_structs.Field) bool {
+			return f.IsExpressionCount()
+		},
+		"elemType": func(f app_structs.Field) string {
+			return f.ElementType()
+		},
+		"exprData": exprData,
+		"padByte": func(f app_structs.Field) string {
+			return fmt.Sprintf("0x%02X", f.PadByte())
+		},
+		"isPadded": func(f app_structs.Field) bool {
+			return f.IsPadded()
+		},
+		"isSelfDelimited": func(f app_structs.Field) bool {
+			return f.IsSelfDelimited()
+		},
+		"lengthPrefixType": func(f app_structs.Field) string {
+			return f.LengthPrefixType()
+		},
+		"lengthPrefixMax": func(f app_structs.Field) uint64 {
+			switch f.LengthPrefixType() {
+			case "uint8":
+				return math.MaxUint8
+			case "uint16":
+				return math.MaxUint16
+			}
+			return math.MaxUint32
+		},
+		"padCutset": func(f app_structs.Field) string {
+			return strconv.Quote(string([]byte{f.PadByte()}))
+		},
+		"isNumeric": app_structs.IsNumericType,
+		"isStruct":  fileFormat.IsStructType,
+		"goType": func(f app_structs.Field) string {
+			return f.GoType()
+		},
+		"goElemType": func(f app_structs.Field) string {
+			return f.GoElementType()
+		},
+		"strictEnum": func(f app_structs.Field) bool {
+			return f.Enum != "" && fileFormat.Enums[f.Enum].Strict
+		},
+		"valueLiteral": func(f app_structs.Field) (string, error) {
+			return f.ValueLiteral()
+		},
+		"fieldData": func(structName string, f app_structs.Field) FieldTemplateData {
+			data := FieldTemplateData{StructName: structName, Field: f}
+			if f.IsConditional() {
+				data.Label = "conditional field "
+			}
+			return data
+		},
+		"conditionData": func(d FieldTemplateData) ExprTemplateData {
+			exprVar := expressionVarName(d.StructName, d.Field.Name, "condition")
+			return ExprTemplateData{FieldTemplateData: d, Expr: d.Field.Condition, Kind: "condition", Var: "present", ExprVar: exprVar}
+		},
+		"isNative": fileFormat.IsNativeMode,
+		"nativeExpr": func(d ExprTemplateData) (string, error) {
+			native, err := utils.TranslateExpression(&fileFormat, d.StructName, d.Expr, d.Kind == "condition")
+			return native.Code, err
+		},
+		// byteOrder returns the encoding/binary ByteOrder expression for a field,
+		// honoring the field's endian override and the format-level default.
+		"byteOrder": func(f app_structs.Field) string {
+			if f.ByteOrder(fileFormat.Endian) == app_structs.EndianBig {
+				return "binary.BigEndian"
+			}
+			return "binary.LittleEndian"
 		},
 		// Add a helper to check for the manual length placeholder
 		"needsManualLength": func(f app_structs.Field) bool {
@@ -107,12 +271,21 @@ func GenerateCode(yamlFile, outputDir, packageName, targetStubName string) error
 	if err != nil {
 		return fmt.Errorf("error parsing base template: %w", err)
 	}
-	log.Println("Successfully parsed base template.")
+	for _, fieldTemplate := range []string{ReadFieldTemplate, WriteFieldTemplate} {
+		if _, err = tmpl.Parse(fieldTemplate); err != nil {
+			return fmt.Errorf("error parsing field template: %w", err)
+		}
+	}
+	out.logf("Successfully parsed base template.")
 
 
+	// Native helper functions called by the expressions of any struct
+	nativeFunctions := make(map[string]bool)
+
 	// 4. For each struct defined in the YAML, execute the template
-	for structName, structDef := range fileFormat.Structs {
-		log.Printf("Generating code for struct: %s", structName)
+	for _, structName := range fileFormat.OrderedStructNames() {
+		structDef := fileFormat.Structs[structName]
+		out.logf("Generating code for struct: %s", structName)
 
 		// 4A. Determine required imports, build field map, AND check variable needs
 		requiredImports := map[string]bool{"io": true}
@@ -122,52 +295,101 @@ func GenerateCode(yamlFile, outputDir, packageName, targetStubName string) error
 		needsErrVarRead := false
 		needsErrVarWrite := false
 		needsBVar := false
-		needsTmpUint8 := false // <-- Initialize flag
-		needsGovaluate := false
+		needsBytes := false
+		needsStrings := false
 		needsGeneratorHelpers := false
+		var expressions []ExpressionVar
+		var bitFields []BitAccessor
+		nativeUsesCtx := false
+		usesCtx := false
 
 		// Iterate through fields to determine needs accurately
 		for _, field := range structDef.Fields {
+			if fileFormat.IsNativeMode() {
+				fieldUsesCtx, err := translateFieldExpressions(&fileFormat, structName, field, nativeFunctions, requiredImports)
+				if err != nil {
+					return fmt.Errorf("struct '%s': field '%s': %w", structName, field.Name, err)
+				}
+				nativeUsesCtx = nativeUsesCtx || fieldUsesCtx
+			}
+			for _, expr := range []string{field.Length, field.Count, field.Condition} {
+				usesCtx = usesCtx || utils.ExpressionUsesContext(expr)
+			}
 			fieldMap[field.Name] = field.Type
+			bitFields = append(bitFields, bitAccessors(field)...)
 			fieldUsesErrRead := false
 			fieldUsesErrWrite := false
 
 			_, errConv := strconv.Atoi(field.Length)
-			if errConv != nil && field.Length != "" && field.Length != "NEEDS_MANUAL_LENGTH" {
-				needsGovaluate = true
+			if errConv != nil && field.Length != "" && field.Length != "NEEDS_MANUAL_LENGTH" && !field.IsRepeated() && !fileFormat.IsNativeMode() {
 				needsGeneratorHelpers = true
+				expressions = append(expressions, ExpressionVar{Name: expressionVarName(structName, field.Name, "length"), Expr: field.Length, Field: field.Name, Kind: "length"})
+			}
+			if field.IsConditional() && !fileFormat.IsNativeMode() {
+				needsGeneratorHelpers = true
+				expressions = append(expressions, ExpressionVar{Name: expressionVarName(structName, field.Name, "condition"), Expr: field.Condition, Field: field.Name, Kind: "condition"})
 			}
 
-			switch field.Type {
-			case "uint8": // <-- Check for uint8
-				needsTmpUint8 = true // <-- Set the flag if found
-				needsBinary = true
+			switch {
+			case field.IsRepeated():
 				needsFmt = true
 				fieldUsesErrRead = true
 				fieldUsesErrWrite = true
-			case "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64", "float32", "float64":
+				if app_structs.IsNumericType(field.ElementType()) {
+					needsBinary = true
+				}
+				if field.IsCountToEOF() {
+					needsBytes = true
+				}
+				if field.IsExpressionCount() && !fileFormat.IsNativeMode() {
+					needsGeneratorHelpers = true
+					expressions = append(expressions, ExpressionVar{Name: expressionVarName(structName, field.Name, "count"), Expr: field.Count, Field: field.Name, Kind: "count"})
+				}
+
+			case app_structs.IsNumericType(field.Type):
 				needsBinary = true
 				needsFmt = true
 				fieldUsesErrRead = true
 				fieldUsesErrWrite = true
-			case "string":
+			case field.Type == "string" && field.IsSelfDelimited():
+				needsFmt = true
+				needsBVar = true
+				fieldUsesErrRead = true
+				fieldUsesErrWrite = true
+				if field.Encoding == app_structs.EncodingCString {
+					needsStrings = true
+				} else {
+					needsBinary = true
+				}
+
+			case field.Type == "string":
 				needsFmt = true
 				needsBVar = true
 				if field.Length != "" && field.Length != "NEEDS_MANUAL_LENGTH" {
 					fieldUsesErrRead = true
+					// Read strips the padding of padded strings with bytes.TrimRight, whatever their length
+					needsBytes = needsBytes || field.IsPadded()
 				}
 				fieldUsesErrWrite = true
 
-			case "[]byte":
+			case field.Type == "[]byte":
 				needsFmt = true
 				if field.Length != "" && field.Length != "NEEDS_MANUAL_LENGTH" {
 					fieldUsesErrRead = true
+					// Write pads short values with bytes.Repeat if padding is requested, unless the value is constant
+					needsBytes = needsBytes || field.IsPadded() && field.Value == ""
 				}
 				fieldUsesErrWrite = true
 
+			case fileFormat.IsStructType(field.Type):
+				// Nested struct: delegate to its own Read/Write methods
+				needsFmt = true
+				fieldUsesErrRead = true
+				fieldUsesErrWrite = true
+
 			default:
 				needsFmt = true
-				log.Printf("Info: Field '%s.%s' has custom type '%s'. Manual Read/Write implementation might be needed.", structName, field.Name, field.Type)
+				out.logf("Info: Field '%s.%s' has custom type '%s'. Manual Read/Write implementation might be needed.", structName, field.Name, field.Type)
 			}
 
 			if fieldUsesErrRead {
@@ -179,16 +401,18 @@ func GenerateCode(yamlFile, outputDir, packageName, targetStubName string) error
 		}
 
 		// Determine imports based on flags
-		// ... (import logic remains the same) ...
 		if needsBinary {
 			requiredImports["encoding/binary"] = true
 		}
+		if needsBytes {
+			requiredImports["bytes"] = true
+		}
+		if needsStrings {
+			requiredImports["strings"] = true
+		}
 		if needsFmt || len(structDef.Fields) > 0 { // Include fmt if fields exist or errors are possible
 			requiredImports["fmt"] = true
 		}
-		if needsGovaluate {
-			requiredImports["github.com/knetic/govaluate"] = true
-		}
 		if needsGeneratorHelpers {
 			// Assuming GetExpressionFunctions is in the 'generator' package
 			// Adjust if moved to a different shared package
@@ -214,36 +438,243 @@ func GenerateCode(yamlFile, outputDir, packageName, targetStubName string) error
 			NeedsErrVarRead:  needsErrVarRead,
 			NeedsErrVarWrite: needsErrVarWrite,
 			NeedsBVar:        needsBVar,
-			NeedsTmpUint8:    needsTmpUint8, // <-- Pass the flag
+			Expressions:      expressions,
+			ContextType:      fileFormat.ContextType(structName),
+			ContextFields:    structDef.Context.Fields,
+			HasFunctions:     len(fileFormat.Functions) > 0,
+			BitFields:        bitFields,
+		}
+		if templateData.ContextType != "" {
+			templateData.CtxRequired = usesCtx
+		} else if nativeUsesCtx {
+			templateData.NativeCtxType, _ = utils.ContextStruct(&fileFormat, structName) // Checked by translateFieldExpressions
 		}
 
 		// 4C. Execute the template
-		// ... (template execution, formatting, writing remain the same) ...
 		var output bytes.Buffer
 		err = tmpl.Execute(&output, templateData)
 		if err != nil {
 			return fmt.Errorf("error executing template for struct %s: %w", structName, err)
 		}
-		log.Printf("Successfully executed template for %s.", structName)
+		out.logf("Successfully executed template for %s.", structName)
 
 		// 5. Write the generated code to a file
 		goFileName := strings.Title(structName) + ".go"
 		outputPath := filepath.Join(outputDir, goFileName)
+		if err := out.writeGoFile(outputPath, output.Bytes(), "code for "+structName); err != nil {
+			return err
+		}
+
+	} // End loop through structs
 
-		formattedOutput, errFmt := format.Source(output.Bytes())
-		if errFmt != nil {
-			log.Printf("Warning: Failed to format generated code for %s: %v. Writing unformatted code.", structName, errFmt)
-			formattedOutput = output.Bytes() // Fallback
+	// 6. Generate the plain Go versions of the expression functions used in native mode,
+	// or the table of declared functions govaluate expressions are compiled with
+	if len(nativeFunctions) > 0 {
+		if err := generateNativeFunctions(out, nativeFunctions, outputDir, packageName); err != nil {
+			return err
 		}
+	} else if len(fileFormat.Functions) > 0 && !fileFormat.IsNativeMode() {
+		if err := generateExpressionFunctions(out, fileFormat, outputDir, packageName); err != nil {
+			return err
+		}
+	}
 
-		err = ioutil.WriteFile(outputPath, formattedOutput, 0644)
-		if err != nil {
-			return fmt.Errorf("error writing generated code to file %s: %w", outputPath, err)
+	// 7. Generate the enum types referred to by fields
+	if len(fileFormat.Enums) > 0 {
+		if err := generateEnums(out, fileFormat, outputDir, packageName); err != nil {
+			return err
 		}
-		log.Printf("Generated %s", outputPath)
+	}
 
-	} // End loop through structs
+	// 8. Generate the error types returned by Read for constant fields
+	if fileFormat.HasConstantFields() {
+		if err := generateErrors(out, outputDir, packageName); err != nil {
+			return err
+		}
+	}
+
+	// 9. Generate the File type wiring the layout structs together
+	if fileFormat.HasLayout() {
+		if err := generateFileType(out, fileFormat, outputDir, packageName); err != nil {
+			return err
+		}
+	}
 
-	log.Println("Code generation completed successfully.")
+	out.logf("Code generation completed successfully.")
 	return nil
 }
+
+// generateFileType writes the File type for the format's layout, with
+// ReadFile/WriteFile methods that process each layout struct in order.
+func generateFileType(out *output, fileFormat app_structs.FileFormat, outputDir, packageName string) error {
+	typeName := app_structs.FileTypeName
+	out.logf("Generating code for layout type: %s", typeName)
+
+	tmpl, err := template.New("file").Parse(FileTemplate)
+	if err != nil {
+		return fmt.Errorf("error parsing file template: %w", err)
+	}
+	formatName := fileFormat.Name
+	if formatName == "" {
+		formatName = packageName
+	}
+	var output bytes.Buffer
+	err = tmpl.Execute(&output, FileTemplateData{
+		PackageName: packageName,
+		FormatName:  formatName,
+		TypeName:    typeName,
+		Parts:       fileFormat.Layout,
+	})
+	if err != nil {
+		return fmt.Errorf("error executing template for %s: %w", typeName, err)
+	}
+
+	return out.writeGoFile(filepath.Join(outputDir, typeName+".go"), output.Bytes(), "code for "+typeName)
+}
+
+// generateEnums writes enums.go, with a named type, constants and String and
+// IsValid methods for every enum of the format.
+func generateEnums(out *output, fileFormat app_structs.FileFormat, outputDir, packageName string) error {
+	out.logf("Generating code for %d enum(s)", len(fileFormat.Enums))
+	names := make([]string, 0, len(fileFormat.Enums))
+	for name := range fileFormat.Enums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data := EnumTemplateData{PackageName: packageName}
+	for _, name := range names {
+		enum := fileFormat.Enums[name]
+		enumData := EnumData{Name: name, Type: enum.Type, Description: enum.Description}
+		for _, value := range enum.Values {
+			literal := strconv.FormatInt(value.Value, 10)
+			if value.Value >= 0x100 { // Wide values, such as markers, read better in hex
+				literal = fmt.Sprintf("0x%X", value.Value)
+			}
+			enumData.Values = append(enumData.Values, EnumValueData{
+				ConstName:   value.ConstName(name),
+				Name:        value.Name,
+				Literal:     literal,
+				Description: value.Description,
+			})
+		}
+		data.Enums = append(data.Enums, enumData)
+	}
+
+	tmpl, err := template.New("enums").Parse(EnumTemplate)
+	if err != nil {
+		return fmt.Errorf("error parsing enum template: %w", err)
+	}
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, data); err != nil {
+		return fmt.Errorf("error executing enum template: %w", err)
+	}
+
+	return out.writeGoFile(filepath.Join(outputDir, "enums.go"), output.Bytes(), "enums")
+}
+
+// generateErrors writes errors.go, with the error types returned by the
+// generated Read methods.
+func generateErrors(out *output, outputDir, packageName string) error {
+	tmpl, err := template.New("errors").Parse(ErrorsTemplate)
+	if err != nil {
+		return fmt.Errorf("error parsing errors template: %w", err)
+	}
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, ErrorsTemplateData{PackageName: packageName}); err != nil {
+		return fmt.Errorf("error executing errors template: %w", err)
+	}
+
+	return out.writeGoFile(filepath.Join(outputDir, "errors.go"), output.Bytes(), "error types")
+}
+
+// translateFieldExpressions type-checks the native translation of a field's
+// expressions (length, count and condition), recording the native functions
+// they call and the imports of the declared functions they call. It reports
+// whether any of them refers to ctx.
+func translateFieldExpressions(fileFormat *app_structs.FileFormat, structName string, field app_structs.Field, functions, imports map[string]bool) (bool, error) {
+	type source struct {
+		expr      string
+		condition bool
+	}
+	var sources []source
+	if field.IsRepeated() {
+		if field.IsExpressionCount() {
+			sources = append(sources, source{expr: field.Count})
+		}
+	} else if _, err := strconv.Atoi(field.Length); err != nil && field.Length != "" && field.Length != "NEEDS_MANUAL_LENGTH" {
+		sources = append(sources, source{expr: field.Length})
+	}
+	if field.IsConditional() {
+		sources = append(sources, source{expr: field.Condition, condition: true})
+	}
+
+	usesCtx := false
+	for _, src := range sources {
+		native, err := utils.TranslateExpression(fileFormat, structName, src.expr, src.condition)
+		if err != nil {
+			return false, err
+		}
+		usesCtx = usesCtx || native.UsesCtx
+		for _, name := range native.Functions {
+			functions[name] = true
+		}
+		for _, path := range native.Imports {
+			imports[path] = true
+		}
+	}
+	return usesCtx, nil
+}
+
+// generateNativeFunctions writes the plain Go implementations of the
+// expression functions called by native expressions, so the generated package
+// doesn't depend on FIG/utils.
+func generateNativeFunctions(out *output, functions map[string]bool, outputDir, packageName string) error {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var output bytes.Buffer
+	fmt.Fprintf(&output, "// Code generated by FormatModule tool. DO NOT EDIT.\npackage %s\n", packageName)
+	for _, name := range names {
+		fmt.Fprintf(&output, "\n%s", utils.NativeExpressionFunctions[name].Source)
+	}
+
+	return out.writeGoFile(filepath.Join(outputDir, "expression_functions.go"), output.Bytes(), "expression functions")
+}
+
+// generateExpressionFunctions writes the expressionFunctions table that
+// govaluate expressions are compiled with, mapping the names of the format's
+// declared functions to the Go functions implementing them.
+func generateExpressionFunctions(out *output, fileFormat app_structs.FileFormat, outputDir, packageName string) error {
+	names := make([]string, 0, len(fileFormat.Functions))
+	imports := map[string]bool{"FIG/utils": true}
+	for name, function := range fileFormat.Functions {
+		names = append(names, name)
+		if function.Import != "" {
+			imports[function.Import] = true
+		}
+	}
+	sort.Strings(names)
+	importsList := make([]string, 0, len(imports))
+	for path := range imports {
+		importsList = append(importsList, path)
+	}
+	sort.Strings(importsList)
+
+	var output bytes.Buffer
+	fmt.Fprintf(&output, "// Code generated by FormatModule tool. DO NOT EDIT.\npackage %s\n\nimport (\n", packageName)
+	for _, path := range importsList {
+		fmt.Fprintf(&output, "\t%q\n", path)
+	}
+	output.WriteString(")\n\n// expressionFunctions are the functions declared by the format, available to its expressions.\n")
+	output.WriteString("var expressionFunctions = utils.ExpressionFunctions(map[string]interface{}{\n")
+	for _, name := range names {
+		fmt.Fprintf(&output, "\t%q: %s,\n", name, fileFormat.Functions[name].Go)
+	}
+	output.WriteString("})\n")
+
+	return out.writeGoFile(filepath.Join(outputDir, "expression_functions.go"), output.Bytes(), "expression functions")
+}
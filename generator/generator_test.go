@@ -0,0 +1,1354 @@
+package generator
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"FIG/utils"
+)
+
+// generatePackage validates and reforms source like bootstrap does, then
+// generates its code into the package formats/<name> of a temporary module
+// that requires this one. It returns the directory of the package.
+func generatePackage(t *testing.T, name, source string) string {
+	t.Helper()
+	moduleDir := t.TempDir()
+	writeModule(t, moduleDir)
+	sourcePath := filepath.Join(moduleDir, "sources", name+".yml")
+	writeFile(t, sourcePath, source)
+
+	logger := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logger)
+	dir := filepath.Join(moduleDir, "formats", name)
+	reformed, err := utils.ValidateAndReformYAML(sourcePath, dir, utils.ValidationOptions{})
+	if err != nil {
+		t.Fatalf("ValidateAndReformYAML() error = %v", err)
+	}
+	if err := GenerateCode(reformed, dir, name, ""); err != nil {
+		t.Fatalf("GenerateCode() error = %v", err)
+	}
+	return dir
+}
+
+// generateTests generates the test script of the package in dir, which
+// generatePackage generated from the source name.yml.
+func generateTests(t *testing.T, dir, name string) {
+	t.Helper()
+	logger := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logger)
+	if err := generateTestScript(newOutput(nil, nil), filepath.Join(dir, name+".yml"), []byte(readFile(t, dir, name+".yml")), dir, name, "figtest"); err != nil {
+		t.Fatalf("generateTestScript() error = %v", err)
+	}
+}
+
+// writeModule writes a go.mod to dir declaring the module figtest, which
+// uses this module through a replace directive, along with this module's
+// go.sum for the dependencies.
+func writeModule(t *testing.T, dir string) {
+	t.Helper()
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goMod, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goMod = regexp.MustCompile(`(?m)^module .*$`).ReplaceAll(goMod, []byte("module figtest"))
+	writeFile(t, filepath.Join(dir, "go.mod"), string(goMod)+"\nrequire FIG v0.0.0\n\nreplace FIG => "+root+"\n")
+	goSum, err := ioutil.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "go.sum"), string(goSum))
+}
+
+// runGoTest adds tests to the generated package in dir, as the file
+// fig_test.go of the external test package, and runs go test on it,
+// running every benchmark once.
+func runGoTest(t *testing.T, dir, tests string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping go test of the generated package in short mode")
+	}
+	writeFile(t, filepath.Join(dir, "fig_test.go"), tests)
+	cmd := exec.Command("go", "test", "-count=1", "-bench=.", "-benchtime=1x", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test of the generated package: %v\n%s", err, out)
+	}
+}
+
+// readFile returns the content of the file name in dir.
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkContains checks that the generated file name in dir contains every
+// substring of want.
+func checkContains(t *testing.T, dir, name string, want ...string) {
+	t.Helper()
+	code := readFile(t, dir, name)
+	for _, w := range want {
+		if !strings.Contains(code, w) {
+			t.Errorf("%s doesn't contain %q:\n%s", name, w, code)
+		}
+	}
+}
+
+const endianYAML = `name: Endian
+endian: BIG
+structs:
+  Header:
+    fields:
+      - {name: Big, type: uint16}
+      - {name: Little, type: uint32, endian: LE}
+      - {name: Default, type: int32}
+`
+
+func TestGenerateEndian(t *testing.T) {
+	dir := generatePackage(t, "endian", endianYAML)
+	reformed := readFile(t, dir, "endian.yml")
+	for _, want := range []string{"endian: big", "endian: little"} {
+		if !strings.Contains(reformed, want) {
+			t.Errorf("reformed YAML doesn't contain %q:\n%s", want, reformed)
+		}
+	}
+	checkContains(t, dir, "Header.go", "binary.BigEndian", "binary.LittleEndian")
+
+	runGoTest(t, dir, `package endian_test
+
+import (
+	"bytes"
+	"testing"
+
+	"figtest/formats/endian"
+)
+
+func TestEndianRoundTrip(t *testing.T) {
+	want := []byte{0x01, 0x02, 0x04, 0x03, 0x02, 0x01, 0xff, 0xff, 0xff, 0xfe}
+	var h endian.Header
+	if err := h.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if h.Big != 0x0102 || h.Little != 0x01020304 || h.Default != -2 {
+		t.Errorf("Read() = %+v", h)
+	}
+	var buf bytes.Buffer
+	if err := h.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+}
+`)
+}
+
+const numericYAML = `name: Numeric
+layout: [Little, Big]
+structs:
+  Little:
+    fields:
+      - {name: U8, type: uint8}
+      - {name: U16, type: uint16}
+      - {name: U32, type: uint32}
+      - {name: U64, type: uint64}
+      - {name: I8, type: int8}
+      - {name: I16, type: int16}
+      - {name: I32, type: int32}
+      - {name: I64, type: int64}
+      - {name: F32, type: float32}
+      - {name: F64, type: float64}
+  Big:
+    fields:
+      - {name: U8, type: uint8, endian: big}
+      - {name: U16, type: uint16, endian: big}
+      - {name: U32, type: uint32, endian: big}
+      - {name: U64, type: uint64, endian: big}
+      - {name: I8, type: int8, endian: big}
+      - {name: I16, type: int16, endian: big}
+      - {name: I32, type: int32, endian: big}
+      - {name: I64, type: int64, endian: big}
+      - {name: F32, type: float32, endian: big}
+      - {name: F64, type: float64, endian: big}
+  Conditional:
+    fields:
+      - {name: Flags, type: uint8}
+      - {name: I16, type: int16, condition: "s.Flags&1 != 0"}
+      - {name: U64, type: uint64, endian: big, condition: "s.Flags&2 != 0"}
+      - {name: F64, type: float64, condition: "s.Flags&4 != 0"}
+`
+
+func TestGenerateNumericTypes(t *testing.T) {
+	dir := generatePackage(t, "numeric", numericYAML)
+	generateTests(t, dir, "numeric")
+	// The example test uses the first struct declared, not the first alphabetically
+	checkContains(t, dir, "numeric_test.go", "func TestGeneratedCode_Little(", "func TestRoundTrip_Big(", "func TestRoundTrip_Little(", "func TestRoundTrip_File(", "func BenchmarkReadFile(")
+
+	runGoTest(t, dir, `package numeric_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"figtest/formats/numeric"
+)
+
+// fields mirrors the fields of numeric.Little and numeric.Big, so that
+// encoding/binary encodes the bytes the generated code must produce.
+type fields struct {
+	U8  uint8
+	U16 uint16
+	U32 uint32
+	U64 uint64
+	I8  int8
+	I16 int16
+	I32 int32
+	I64 int64
+	F32 float32
+	F64 float64
+}
+
+var sample = fields{0x12, 0x1234, 0x12345678, 0x123456789abcdef0, -0x12, -0x1234, -0x12345678, -0x123456789abcdef0, 3.25, -6.5e10}
+
+func encode(t *testing.T, order binary.ByteOrder, data interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, order, data); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLittleRoundTrip(t *testing.T) {
+	want := encode(t, binary.LittleEndian, sample)
+	value := numeric.Little(sample)
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got numeric.Little
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != value {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+
+func TestBigRoundTrip(t *testing.T) {
+	want := encode(t, binary.BigEndian, sample)
+	value := numeric.Big(sample)
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got numeric.Big
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != value {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+
+func TestConditionalRoundTrip(t *testing.T) {
+	tests := []struct {
+		value numeric.Conditional
+		want  []byte
+	}{
+		{
+			value: numeric.Conditional{Flags: 5, I16: -0x1234, F64: -6.5e10},
+			want: encode(t, binary.LittleEndian, struct {
+				Flags uint8
+				I16   int16
+				F64   float64
+			}{5, -0x1234, -6.5e10}),
+		},
+		{
+			value: numeric.Conditional{Flags: 2, U64: 0x123456789abcdef0},
+			want:  append([]byte{2}, encode(t, binary.BigEndian, uint64(0x123456789abcdef0))...),
+		},
+		{
+			value: numeric.Conditional{},
+			want:  []byte{0},
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.value.Write(&buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), tt.want) {
+			t.Errorf("Write(%+v) = % x, want % x", tt.value, buf.Bytes(), tt.want)
+		}
+		var got numeric.Conditional
+		if err := got.Read(bytes.NewReader(tt.want), nil); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.value {
+			t.Errorf("Read() = %+v, want %+v", got, tt.value)
+		}
+	}
+}
+`)
+}
+
+const nestedYAML = `name: Nested
+structs:
+  Outer:
+    fields:
+      - {name: Count, type: uint8}
+      - {name: Inner, type: Inner}
+      - {name: Trailer, type: uint16}
+  Inner:
+    fields:
+      - {name: Tag, type: string, length: 2}
+      - {name: Data, type: "[]byte", length: 3}
+`
+
+func TestGenerateNestedStructs(t *testing.T) {
+	dir := generatePackage(t, "nested", nestedYAML)
+	checkContains(t, dir, "Outer.go", "Inner Inner", "s.Inner.Read(r, s)", "s.Inner.Write(w, s)")
+
+	runGoTest(t, dir, `package nested_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"figtest/formats/nested"
+)
+
+func TestNestedRoundTrip(t *testing.T) {
+	value := nested.Outer{
+		Count:   3,
+		Inner:   nested.Inner{Tag: "IN", Data: []byte{1, 2, 3}},
+		Trailer: 0xabcd,
+	}
+	want := []byte{3, 'I', 'N', 1, 2, 3, 0xcd, 0xab}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got nested.Outer
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+`)
+}
+
+const repeatedYAML = `name: Repeated
+structs:
+  Table:
+    fields:
+      - {name: Fixed, type: "[]uint16", count: 2, endian: big}
+      - {name: Num, type: uint8}
+      - {name: Entries, type: "[]Entry", count: "s.Num"}
+      - {name: Rest, type: "[]uint8", count: eof}
+  Entry:
+    fields:
+      - {name: Size, type: uint8}
+      - {name: Data, type: "[]byte", length: "s.Size"}
+      - {name: Index, type: "[]byte", length: "ctx.Num - 1"}
+`
+
+func TestGenerateRepeatedFields(t *testing.T) {
+	dir := generatePackage(t, "repeated", repeatedYAML)
+	checkContains(t, dir, "Table.go", "tableEntriesCountExpr = utils.MustCompileExpression(`s.Num`)", "tableEntriesCountExpr.Eval(")
+	checkContains(t, dir, "Entry.go", "entryDataLengthExpr  = utils.MustCompileExpression(`s.Size`)", "entryIndexLengthExpr = utils.MustCompileExpression(`ctx.Num - 1`)")
+	if code := readFile(t, dir, "Entry.go"); strings.Contains(code, "govaluate") {
+		t.Errorf("Entry.go parses expressions in Read/Write:\n%s", code)
+	}
+
+	runGoTest(t, dir, `package repeated_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"figtest/formats/repeated"
+)
+
+func TestRepeatedRoundTrip(t *testing.T) {
+	value := repeated.Table{
+		Fixed: []uint16{0x0102, 0x0304},
+		Num:   2,
+		Entries: []repeated.Entry{
+			{Size: 1, Data: []byte{0xaa}, Index: []byte{7}},
+			{Size: 2, Data: []byte{0xbb, 0xcc}, Index: []byte{8}},
+		},
+		Rest: []uint8{9, 10, 11},
+	}
+	want := []byte{1, 2, 3, 4, 2, 1, 0xaa, 7, 2, 0xbb, 0xcc, 8, 9, 10, 11}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got repeated.Table
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+
+func TestRepeatedErrors(t *testing.T) {
+	value := repeated.Table{Fixed: []uint16{1, 2, 3}}
+	err := value.Write(&bytes.Buffer{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "expected 2 element(s), got 3") {
+		t.Errorf("Write() of 3 Fixed elements: error = %v", err)
+	}
+	value = repeated.Table{Fixed: []uint16{1, 2}, Num: 1, Entries: make([]repeated.Entry, 2)}
+	err = value.Write(&bytes.Buffer{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "count expression ('s.Num') expects 1 element(s), got 2") {
+		t.Errorf("Write() of 2 Entries with Num 1: error = %v", err)
+	}
+
+	var got repeated.Table
+	err = got.Read(bytes.NewReader([]byte{1, 2, 3, 4, 2, 1, 0xaa, 7, 2, 0xbb}), nil)
+	if err == nil || !strings.Contains(err.Error(), "reading Entries[1] (Entry)") {
+		t.Errorf("Read() of a truncated entry: error = %v", err)
+	}
+}
+`)
+}
+
+const paddedYAML = `name: Padded
+structs:
+  Record:
+    fields:
+      - {name: Len, type: uint8}
+      - {name: Name, type: string, length: 4, pad: " "}
+      - {name: Data, type: "[]byte", length: 3}
+      - {name: Short, type: string, length: 2, truncate: true}
+      - {name: Dynamic, type: "[]byte", length: "s.Len", pad: 0xFF}
+  Payload:
+    fields:
+      - {name: Body, type: "[]byte", length: "ctx.Len"}
+      - {name: Label, type: string, length: "ctx.Len", encoding: padded, pad: " "}
+`
+
+func TestGeneratePadding(t *testing.T) {
+	dir := generatePackage(t, "padded", paddedYAML)
+	if reformed := readFile(t, dir, "padded.yml"); !strings.Contains(reformed, `pad: "0x20"`) {
+		t.Errorf("reformed YAML doesn't contain the canonical pad:\n%s", reformed)
+	}
+
+	runGoTest(t, dir, `package padded_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"figtest/formats/padded"
+)
+
+func TestPaddedWrite(t *testing.T) {
+	value := padded.Record{Len: 3, Name: "ab", Data: []byte{1, 0, 0}, Short: "xyz", Dynamic: []byte{2}}
+	want := []byte{3, 'a', 'b', ' ', ' ', 1, 0, 0, 'x', 'y', 2, 0xff, 0xff}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+
+	var got padded.Record
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Padding is stripped from strings, but []byte values read back with it
+	read := padded.Record{Len: 3, Name: "ab", Data: []byte{1, 0, 0}, Short: "xy", Dynamic: []byte{2, 0xff, 0xff}}
+	if !reflect.DeepEqual(got, read) {
+		t.Errorf("Read() = %+v, want %+v", got, read)
+	}
+}
+
+// Write evaluates the ctx lengths of Payload with the context it receives
+func TestPayloadRoundTrip(t *testing.T) {
+	ctx := &padded.Record{Len: 3}
+	value := padded.Payload{Body: []byte{1, 2, 3}, Label: "ab"}
+	want := []byte{1, 2, 3, 'a', 'b', ' '}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got padded.Payload
+	if err := got.Read(bytes.NewReader(buf.Bytes()), ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+
+	value.Body = []byte{1, 2}
+	err := value.Write(&bytes.Buffer{}, ctx)
+	if err == nil || !strings.Contains(err.Error(), "writing Body ([]byte): value is 2 byte(s), shorter than its length 3") {
+		t.Errorf("Write() of a short Body: error = %v", err)
+	}
+}
+
+func TestPaddedWriteWrongLength(t *testing.T) {
+	tests := []struct {
+		value   padded.Record
+		wantErr string
+	}{
+		{padded.Record{Name: "abcde"}, "writing Name (string): value is 5 byte(s), longer than its length 4"},
+		{padded.Record{Data: []byte{1, 2, 3, 4}}, "writing Data ([]byte): value is 4 byte(s), longer than its length 3"},
+		{padded.Record{Len: 1, Data: []byte{1, 2, 3}, Short: "xy", Dynamic: []byte{1, 2}}, "writing Dynamic ([]byte): value is 2 byte(s), longer than its length 1"},
+		{padded.Record{Data: []byte{1}}, "writing Data ([]byte): value is 1 byte(s), shorter than its length 3"},
+		{padded.Record{Data: []byte{1, 2, 3}, Short: "x"}, "writing Short (string): value is 1 byte(s), shorter than its length 2"},
+	}
+	for _, tt := range tests {
+		err := tt.value.Write(&bytes.Buffer{}, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Write(%+v) error = %v, want %q", tt.value, err, tt.wantErr)
+		}
+	}
+}
+`)
+}
+
+const encodingsYAML = `name: Encodings
+structs:
+  Strings:
+    fields:
+      - {name: C, type: string, encoding: cstring, max_length: 8}
+      - {name: P8, type: string, encoding: pascal}
+      - {name: P16, type: string, encoding: pascal16, endian: big}
+      - {name: P32, type: string, encoding: pascal32}
+      - {name: Padded, type: string, encoding: padded, length: 6, pad: " "}
+`
+
+func TestGenerateEncodings(t *testing.T) {
+	dir := generatePackage(t, "encodings", encodingsYAML)
+	generateTests(t, dir, "encodings")
+	checkContains(t, dir, "encodings_test.go", "func TestRoundTrip_Strings(")
+
+	runGoTest(t, dir, `package encodings_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"figtest/formats/encodings"
+)
+
+func TestEncodingsRoundTrip(t *testing.T) {
+	value := encodings.Strings{C: "abc", P8: "de", P16: "f", P32: "", Padded: "gh"}
+	want := []byte{
+		'a', 'b', 'c', 0,
+		2, 'd', 'e',
+		0, 1, 'f',
+		0, 0, 0, 0,
+		'g', 'h', ' ', ' ', ' ', ' ',
+	}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got encodings.Strings
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != value {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+
+func TestEncodingsErrors(t *testing.T) {
+	writes := []struct {
+		value   encodings.Strings
+		wantErr string
+	}{
+		{encodings.Strings{C: "abcdefghi"}, "writing C (cstring): value is 9 byte(s), longer than max_length 8"},
+		{encodings.Strings{C: "a\x00b"}, "writing C (cstring): value contains a NUL byte"},
+		{encodings.Strings{P8: strings.Repeat("x", 256)}, "writing P8 (pascal8): value is 256 byte(s), too long for a uint8 length prefix"},
+	}
+	for _, tt := range writes {
+		err := tt.value.Write(&bytes.Buffer{}, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Write() error = %v, want %q", err, tt.wantErr)
+		}
+	}
+
+	reads := []struct {
+		data    string
+		wantErr string
+	}{
+		{"abcdefghij", "reading C (cstring): no terminator within max_length 8"},
+		{"abc", "reading C (cstring)"},
+		{"abc\x00\x05de", "reading P8 (pascal8[5])"},
+	}
+	for _, tt := range reads {
+		var got encodings.Strings
+		err := got.Read(strings.NewReader(tt.data), nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Read(%q) error = %v, want %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
+`)
+}
+
+const layoutYAML = `name: Layout
+layout: [Header, Body]
+structs:
+  Header:
+    fields:
+      - {name: Magic, type: uint16, endian: big}
+      - {name: Size, type: uint8}
+  Body:
+    fields:
+      - {name: Data, type: "[]byte", length: "ctx.Header.Size"}
+      - {name: Checksum, type: uint16}
+`
+
+func TestGenerateLayout(t *testing.T) {
+	dir := generatePackage(t, "layout", layoutYAML)
+	generateTests(t, dir, "layout")
+	checkContains(t, dir, "layout_test.go", "func BenchmarkRead_Header(", "func BenchmarkExpression_Body_DataLength(")
+	checkContains(t, dir, "File.go", "type File struct", "Header Header", "Body   Body", "func (f *File) ReadFile(r io.Reader) error", "func (f *File) WriteFile(w io.Writer) error")
+
+	runGoTest(t, dir, `package layout_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"figtest/formats/layout"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	value := layout.File{
+		Header: layout.Header{Magic: 0x4c59, Size: 3},
+		Body:   layout.Body{Data: []byte{1, 2, 3}, Checksum: 0x0102},
+	}
+	want := []byte{'L', 'Y', 3, 1, 2, 3, 2, 1}
+	var buf bytes.Buffer
+	if err := value.WriteFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteFile() = % x, want % x", buf.Bytes(), want)
+	}
+	var got layout.File
+	if err := got.ReadFile(bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("ReadFile() = %+v, want %+v", got, value)
+	}
+
+	err := got.ReadFile(bytes.NewReader(want[:4]))
+	if err == nil || !strings.HasPrefix(err.Error(), "reading Body: ") {
+		t.Errorf("ReadFile() of a truncated file: error = %v", err)
+	}
+}
+`)
+}
+
+const contextConditionsYAML = `name: Conditions
+layout: [Header, Body]
+structs:
+  Header:
+    fields:
+      - {name: Flags, type: uint8}
+  Body:
+    fields:
+      - {name: Extra, type: uint16, condition: "(ctx.Header.Flags & 1) != 0"}
+      - {name: Inner, type: Inner, condition: "ctx.Header.Flags > 1"}
+  Inner:
+    fields:
+      - {name: V, type: uint8}
+`
+
+func TestGenerateContextConditions(t *testing.T) {
+	for _, mode := range []string{"govaluate", "native"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := generatePackage(t, "conditions", "expressions: "+mode+"\n"+contextConditionsYAML)
+			// Write evaluates the conditions with its ctx instead of checking the fields are set
+			if code := readFile(t, dir, "Body.go"); strings.Contains(code, "reflect") || strings.Contains(code, "s.Extra != 0") {
+				t.Errorf("Body.go checks whether the fields are set:\n%s", code)
+			}
+			if mode == "govaluate" {
+				checkContains(t, dir, "Body.go", "bodyExtraConditionExpr", "bodyInnerConditionExpr")
+			}
+
+			runGoTest(t, dir, `package conditions_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"figtest/formats/conditions"
+)
+
+func TestContextConditionsRoundTrip(t *testing.T) {
+	tests := []struct {
+		value conditions.File
+		want  []byte
+	}{
+		{
+			value: conditions.File{Header: conditions.Header{Flags: 3}, Body: conditions.Body{Extra: 5, Inner: conditions.Inner{V: 7}}},
+			want:  []byte{3, 5, 0, 7},
+		},
+		{
+			value: conditions.File{Header: conditions.Header{Flags: 1}, Body: conditions.Body{Extra: 5}},
+			want:  []byte{1, 5, 0},
+		},
+		// Present fields holding their zero value are written too
+		{
+			value: conditions.File{Header: conditions.Header{Flags: 3}},
+			want:  []byte{3, 0, 0, 0},
+		},
+		{value: conditions.File{}, want: []byte{0}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.value.WriteFile(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), tt.want) {
+			t.Errorf("WriteFile(%+v) = % x, want % x", tt.value, buf.Bytes(), tt.want)
+		}
+		var got conditions.File
+		if err := got.ReadFile(bytes.NewReader(tt.want)); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.value) {
+			t.Errorf("ReadFile(% x) = %+v, want %+v", tt.want, got, tt.value)
+		}
+	}
+}
+`)
+		})
+	}
+}
+
+const typedContextYAML = `name: Typed
+structs:
+  Inner:
+    context: Outer
+    fields:
+      - {name: V, type: uint8}
+  Item:
+    context: Outer
+    fields:
+      - {name: Data, type: "[]byte", length: "ctx.Size"}
+  Outer:
+    fields:
+      - {name: Size, type: uint8}
+      - {name: In, type: Inner}
+      - {name: Items, type: "[]Item", count: 2}
+  Payload:
+    context:
+      - {name: SegmentLength, type: uint16}
+    fields:
+      - {name: Data, type: "[]byte", length: "ctx.SegmentLength - 2"}
+`
+
+func TestGenerateTypedContext(t *testing.T) {
+	for _, mode := range []string{"govaluate", "native"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := generatePackage(t, "typed", "expressions: "+mode+"\n"+typedContextYAML)
+			generateTests(t, dir, "typed")
+			checkContains(t, dir, "typed_test.go", "var readCtx *typed.Outer = nil")
+			checkContains(t, dir, "Item.go", "func (s *Item) Read(r io.Reader, ctx *Outer) error")
+			checkContains(t, dir, "Payload.go", "type PayloadContext struct", "SegmentLength uint16", "func (s *Payload) Read(r io.Reader, ctx *PayloadContext) error")
+			if mode == "govaluate" {
+				checkContains(t, dir, "typed_test.go", "func BenchmarkExpression_Payload_DataLength(")
+			}
+
+			runGoTest(t, dir, `package typed_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"figtest/formats/typed"
+)
+
+func TestTypedContextRoundTrip(t *testing.T) {
+	value := typed.Outer{Size: 2, In: typed.Inner{V: 9}, Items: []typed.Item{{Data: []byte{1, 2}}, {Data: []byte{3, 4}}}}
+	want := []byte{2, 9, 1, 2, 3, 4}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got typed.Outer
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+
+func TestDeclaredContext(t *testing.T) {
+	var payload typed.Payload
+	if err := payload.Read(bytes.NewReader([]byte{1, 2, 3}), &typed.PayloadContext{SegmentLength: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3}; !bytes.Equal(payload.Data, want) {
+		t.Errorf("Read() = % x, want % x", payload.Data, want)
+	}
+
+	err := payload.Read(bytes.NewReader([]byte{1, 2, 3}), nil)
+	if err == nil || !strings.Contains(err.Error(), "ctx must be a non-nil *PayloadContext") {
+		t.Errorf("Read() with a nil ctx: error = %v", err)
+	}
+}
+`)
+		})
+	}
+}
+
+const functionsYAML = `name: Funcs
+functions:
+  AlignTo: {go: binutil.AlignTo, import: figtest/binutil, args: 2}
+  Twice: {go: twice, args: 1}
+structs:
+  Record:
+    fields:
+      - {name: Size, type: uint8}
+      - {name: Data, type: "[]byte", length: "AlignTo(s.Size, 4)"}
+      - {name: Tail, type: "[]byte", length: "Twice(s.Size)", condition: "Twice(s.Size) > 4"}
+`
+
+func TestGenerateDeclaredFunctions(t *testing.T) {
+	for _, mode := range []string{"govaluate", "native"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := generatePackage(t, "funcs", "expressions: "+mode+"\n"+functionsYAML)
+			if mode == "govaluate" {
+				checkContains(t, dir, "expression_functions.go", `"figtest/binutil"`, `"AlignTo": binutil.AlignTo,`, `"Twice":   twice,`)
+				checkContains(t, dir, "Record.go", "utils.MustCompileExpressionWith(`AlignTo(s.Size, 4)`, expressionFunctions)")
+			} else {
+				checkContains(t, dir, "Record.go", `"figtest/binutil"`, "binutil.AlignTo(int64(s.Size), 4)", "twice(int64(s.Size))")
+			}
+			// The functions come from an imported package and from a hand-written file
+			writeFile(t, filepath.Join(dir, "..", "..", "binutil", "binutil.go"), "package binutil\n\nfunc AlignTo(n, align int64) int64 { return (n + align - 1) / align * align }\n")
+			writeFile(t, filepath.Join(dir, "twice.go"), "package funcs\n\nfunc twice(n int64) int64 { return 2 * n }\n")
+
+			runGoTest(t, dir, `package funcs_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"figtest/formats/funcs"
+)
+
+func TestDeclaredFunctionsRoundTrip(t *testing.T) {
+	tests := []struct {
+		value funcs.Record
+		want  []byte
+	}{
+		{funcs.Record{Size: 3, Data: []byte{1, 2, 3, 4}, Tail: []byte{5, 6, 7, 8, 9, 10}}, []byte{3, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{funcs.Record{Size: 1, Data: []byte{1, 2, 3, 4}}, []byte{1, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.value.Write(&buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), tt.want) {
+			t.Errorf("Write(%+v) = % x, want % x", tt.value, buf.Bytes(), tt.want)
+		}
+		var got funcs.Record
+		if err := got.Read(bytes.NewReader(tt.want), nil); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.value) {
+			t.Errorf("Read(% x) = %+v, want %+v", tt.want, got, tt.value)
+		}
+	}
+}
+`)
+		})
+	}
+}
+
+const builtinsYAML = `name: Builtins
+structs:
+  Record:
+    fields:
+      - {name: Size, type: uint8}
+      - {name: Flags, type: uint8}
+      - {name: Name, type: string, length: "bits(s.Flags, 0, 3)"}
+      - {name: Pad, type: "[]byte", length: "pad_to(len(s.Name) + 1, 4)"}
+      - {name: Data, type: "[]byte", length: "align(s.Size, 4) + ceil_div(s.Size, 4) - popcount(s.Flags)"}
+      - {name: Rest, type: "[]byte", length: "min(s.Size, 2) + max(bits(s.Flags, 4, 7), 1)"}
+`
+
+func TestGenerateBuiltinFunctions(t *testing.T) {
+	for _, mode := range []string{"govaluate", "native"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := generatePackage(t, "builtins", "expressions: "+mode+"\n"+builtinsYAML)
+			if mode == "native" {
+				checkContains(t, dir, "Record.go", "bitRange(int64(s.Flags), 0, 3)", "padTo(int64(len(s.Name))+1, 4)")
+				checkContains(t, dir, "expression_functions.go", "func alignUp(", "func padTo(", "func ceilDiv(", "func bitRange(", "func popcount(", "func minOf(", "func maxOf(")
+			}
+
+			runGoTest(t, dir, `package builtins_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"figtest/formats/builtins"
+)
+
+func TestBuiltinsRoundTrip(t *testing.T) {
+	value := builtins.Record{
+		Size:  5,
+		Flags: 0x22,
+		Name:  "ab",
+		Pad:   []byte{0},
+		Data:  []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Rest:  []byte{9, 10, 11, 12},
+	}
+	want := []byte{5, 0x22, 'a', 'b', 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got builtins.Record
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+`)
+		})
+	}
+}
+
+const enumsYAML = `name: Enums
+enums:
+  Kind:
+    type: uint8
+    description: the kind of a record
+    values:
+      - {name: Empty, value: 0}
+      - {name: Data, value: 1, description: Carries data}
+  Marker:
+    type: uint16
+    strict: true
+    values:
+      - {name: SOI, value: 0xFFD8}
+      - {name: EOI, value: 0xFFD9}
+structs:
+  Record:
+    fields:
+      - {name: Kind, enum: Kind}
+      - {name: Data, type: "[]byte", length: 2, condition: "s.Kind == 1"}
+      - {name: Marker, type: uint16, enum: Marker}
+      - {name: Markers, type: "[]uint16", count: 2, enum: Marker}
+`
+
+func TestGenerateEnums(t *testing.T) {
+	for _, mode := range []string{"govaluate", "native"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := generatePackage(t, "enums", "expressions: "+mode+"\n"+enumsYAML)
+			checkContains(t, dir, "enums.go", "// Kind is the kind of a record.", "type Kind uint8", "KindData  Kind = 1 // Carries data", "MarkerSOI Marker = 0xFFD8", "func (v Marker) IsValid() bool")
+			checkContains(t, dir, "Record.go", "Kind Kind ", "Markers []Marker ")
+
+			runGoTest(t, dir, `package enums_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"figtest/formats/enums"
+)
+
+func TestEnumsRoundTrip(t *testing.T) {
+	value := enums.Record{
+		Kind:    enums.KindData,
+		Data:    []byte{1, 2},
+		Marker:  enums.MarkerEOI,
+		Markers: []enums.Marker{enums.MarkerSOI, enums.MarkerEOI},
+	}
+	want := []byte{1, 1, 2, 0xd9, 0xff, 0xd8, 0xff, 0xd9, 0xff}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got enums.Record
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+
+	// Kind is not strict, so undeclared values are read
+	if err := got.Read(bytes.NewReader([]byte{7, 0xd9, 0xff, 0xd8, 0xff, 0xd9, 0xff}), nil); err != nil || got.Kind != 7 {
+		t.Errorf("Read() of an undeclared Kind = %v, %v", got.Kind, err)
+	}
+}
+
+func TestEnumStrings(t *testing.T) {
+	tests := []struct {
+		value interface{ String() string }
+		want  string
+	}{
+		{enums.KindData, "Data"},
+		{enums.Kind(7), "Kind(7)"},
+		{enums.MarkerSOI, "SOI"},
+	}
+	for _, tt := range tests {
+		if got := tt.value.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+	if !enums.MarkerEOI.IsValid() || enums.Marker(1).IsValid() {
+		t.Errorf("IsValid() doesn't match the declared values")
+	}
+}
+
+func TestStrictEnums(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		wantErr string
+	}{
+		{[]byte{0, 1, 0, 0xd8, 0xff, 0xd9, 0xff}, "reading Marker: invalid Marker value 1"},
+		{[]byte{0, 0xd9, 0xff, 0xd8, 0xff, 0, 0}, "reading Markers[1]: invalid Marker value 0"},
+	}
+	for _, tt := range tests {
+		var got enums.Record
+		err := got.Read(bytes.NewReader(tt.data), nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Read(% x) error = %v, want %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
+`)
+		})
+	}
+}
+
+const bitFieldsYAML = `name: Bitfields
+enums:
+  Kind:
+    type: uint8
+    values:
+      - {name: Plain, value: 0}
+structs:
+  Header:
+    fields:
+      - name: Flags
+        type: uint8
+        bits:
+          - {name: Compressed, bits: 0, description: data is compressed}
+          - {name: Version, bits: 4-7}
+      - name: Mode
+        type: uint16
+        bits:
+          - {name: Level, bits: 8-11}
+      - name: Kind
+        enum: Kind
+        bits:
+          - {name: Subkind, bits: 4-7}
+      - {name: Data, type: "[]byte", length: "bits(s.Flags, 4, 7)", condition: "bits(s.Flags, 0, 0) == 1"}
+`
+
+func TestGenerateBitFields(t *testing.T) {
+	dir := generatePackage(t, "bitfields", bitFieldsYAML)
+	generateTests(t, dir, "bitfields")
+	checkContains(t, dir, "bitfields_test.go", "func TestBitFields_Header(", "if s.Flags != 0xF1 {", "if s.Mode != 0xF00 {")
+	checkContains(t, dir, "Header.go",
+		"// Compressed reports whether bit 0 of Flags is set (data is compressed).",
+		"func (s *Header) Compressed() bool",
+		"func (s *Header) Version() uint8",
+		"func (s *Header) SetLevel(v uint16)",
+		"s.Kind = s.Kind&^0xF0 | Kind(v&0xF)<<4")
+
+	runGoTest(t, dir, `package bitfields_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"figtest/formats/bitfields"
+)
+
+func TestBitFieldAccessors(t *testing.T) {
+	var h bitfields.Header
+	h.SetCompressed(true)
+	h.SetVersion(2)
+	h.SetLevel(0x1f) // Only the low 4 bits fit
+	h.SetSubkind(3)
+	if h.Flags != 0x21 || h.Mode != 0xf00 || h.Kind != 0x30 {
+		t.Errorf("packed fields = %#x, %#x, %#x, want 0x21, 0xf00, 0x30", h.Flags, h.Mode, h.Kind)
+	}
+	if !h.Compressed() || h.Version() != 2 || h.Level() != 0xf || h.Subkind() != 3 {
+		t.Errorf("accessors = %v, %v, %v, %v", h.Compressed(), h.Version(), h.Level(), h.Subkind())
+	}
+	h.SetCompressed(false)
+	if h.Flags != 0x20 {
+		t.Errorf("Flags = %#x after SetCompressed(false), want 0x20", h.Flags)
+	}
+}
+
+func TestBitFieldsRoundTrip(t *testing.T) {
+	value := bitfields.Header{Flags: 0x21, Mode: 0x0300, Kind: 0x10, Data: []byte{1, 2}}
+	want := []byte{0x21, 0, 3, 0x10, 1, 2}
+	var buf bytes.Buffer
+	if err := value.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+	var got bitfields.Header
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+`)
+}
+
+const constantsYAML = `name: Constants
+endian: big
+structs:
+  Header:
+    fields:
+      - {name: Signature, type: string, magic: BM}
+      - {name: Marker, type: uint16, value: 0xFFD8}
+      - {name: Magic, type: "[]byte", value: "0x89504E47"}
+      - {name: Size, type: uint8}
+`
+
+func TestGenerateConstantFields(t *testing.T) {
+	dir := generatePackage(t, "constants", constantsYAML)
+	checkContains(t, dir, "errors.go", "type ValueMismatchError struct")
+	checkContains(t, dir, "Header.go", "// Marker is a constant: its value is written whatever the struct holds")
+	// The generated TestGeneratedCode sets the constants, which Read returns
+	generateTests(t, dir, "constants")
+	checkContains(t, dir, "constants_test.go", "// Constant: Write always writes this value and Read returns it")
+
+	runGoTest(t, dir, `package constants_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"figtest/formats/constants"
+)
+
+func TestConstantsRoundTrip(t *testing.T) {
+	want := []byte{'B', 'M', 0xff, 0xd8, 0x89, 'P', 'N', 'G', 7}
+	// Write emits the constants whatever the struct holds
+	var buf bytes.Buffer
+	if err := (&constants.Header{Signature: "XX", Size: 7}).Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write() = % x, want % x", buf.Bytes(), want)
+	}
+
+	var got constants.Header
+	if err := got.Read(bytes.NewReader(want), nil); err != nil {
+		t.Fatal(err)
+	}
+	value := constants.Header{Signature: "BM", Marker: 0xffd8, Magic: []byte("\x89PNG"), Size: 7}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Read() = %+v, want %+v", got, value)
+	}
+}
+
+func TestConstantMismatch(t *testing.T) {
+	tests := []struct {
+		data      []byte
+		field     string
+		want, got interface{}
+	}{
+		{[]byte{'B', 'A', 0xff, 0xd8, 0x89, 'P', 'N', 'G', 7}, "Signature", "BM", "BA"},
+		{[]byte{'B', 'M', 0xff, 0xd9, 0x89, 'P', 'N', 'G', 7}, "Marker", uint16(0xffd8), uint16(0xffd9)},
+		{[]byte{'B', 'M', 0xff, 0xd8, 'G', 'I', 'F', '8', 7}, "Magic", []byte("\x89PNG"), []byte("GIF8")},
+	}
+	for _, tt := range tests {
+		var h constants.Header
+		err := h.Read(bytes.NewReader(tt.data), nil)
+		var mismatch *constants.ValueMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("Read(% x) error = %v, want a *ValueMismatchError", tt.data, err)
+			continue
+		}
+		if mismatch.Struct != "Header" || mismatch.Field != tt.field || !reflect.DeepEqual(mismatch.Want, tt.want) || !reflect.DeepEqual(mismatch.Got, tt.got) {
+			t.Errorf("Read(% x) error = %+v, want a mismatch of %s: %v != %v", tt.data, mismatch, tt.field, tt.want, tt.got)
+		}
+	}
+}
+`)
+}
+
+const nativeYAML = `name: Native
+expressions: native
+layout: [Header, Image]
+structs:
+  Header:
+    fields:
+      - {name: Flags, type: uint8}
+      - {name: Extra, type: uint16, condition: "s.Flags & 1 != 0"}
+  Image:
+    fields:
+      - {name: Width, type: uint8}
+      - {name: Height, type: uint8}
+      - {name: Pixels, type: "[]byte", length: "CalculatePaddedSize(s.Width, s.Height, 8)"}
+      - {name: Rows, type: "[]Row", count: "ctx.Header.Flags >> 1"}
+  Row:
+    fields:
+      - {name: Data, type: "[]byte", length: "ctx.Width"}
+`
+
+func TestGenerateNativeExpressions(t *testing.T) {
+	dir := generatePackage(t, "native", nativeYAML)
+	checkContains(t, dir, "expression_functions.go", "func calculatePaddedSize(width, height, bitsPerPixel int64) int64")
+	for _, name := range []string{"Header.go", "Image.go", "Row.go", "File.go", "expression_functions.go"} {
+		if code := readFile(t, dir, name); strings.Contains(code, "FIG/utils") || strings.Contains(code, "govaluate") {
+			t.Errorf("%s depends on FIG/utils in native mode:\n%s", name, code)
+		}
+	}
+
+	runGoTest(t, dir, `package native_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"figtest/formats/native"
+)
+
+func TestNativeRoundTrip(t *testing.T) {
+	value := native.File{
+		Header: native.Header{Flags: 3, Extra: 0x0102},
+		Image: native.Image{
+			Width:  3,
+			Height: 2,
+			Pixels: []byte{1, 2, 3, 0, 4, 5, 6, 0},
+			Rows:   []native.Row{{Data: []byte{7, 8, 9}}},
+		},
+	}
+	want := []byte{3, 2, 1, 3, 2, 1, 2, 3, 0, 4, 5, 6, 0, 7, 8, 9}
+	var buf bytes.Buffer
+	if err := value.WriteFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteFile() = % x, want % x", buf.Bytes(), want)
+	}
+	var got native.File
+	if err := got.ReadFile(bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("ReadFile() = %+v, want %+v", got, value)
+	}
+
+	// Without the Extra flag, Extra is neither written nor read
+	value.Header = native.Header{Flags: 2}
+	buf.Reset()
+	if err := value.WriteFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte{2}, want[3:]...); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteFile() = % x, want % x", buf.Bytes(), want)
+	}
+}
+
+func TestNativeReadWithoutContext(t *testing.T) {
+	var row native.Row
+	if err := row.Read(bytes.NewReader([]byte{1, 2, 3}), nil); err == nil {
+		t.Errorf("Read() of a Row without its Image as ctx succeeded")
+	}
+}
+`)
+}
@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"FIG/utils"
+)
+
+// WriteFS is where generated files are written, so generation can target the
+// disk, memory (e.g., to preview output or test generation hermetically) or an
+// overlay.
+type WriteFS interface {
+	// WriteFile writes data to the file at path, replacing it if it exists.
+	WriteFile(path string, data []byte) error
+}
+
+// DiskFS writes files to disk, creating their directories as needed.
+type DiskFS struct{}
+
+// WriteFile writes data to path on disk.
+func (DiskFS) WriteFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to ensure output directory %s exists: %w", filepath.Dir(path), err)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// MapFS keeps written files in memory, by path. Create it with make.
+type MapFS map[string][]byte
+
+// WriteFile stores a copy of data under path.
+func (m MapFS) WriteFile(path string, data []byte) error {
+	m[path] = append([]byte(nil), data...)
+	return nil
+}
+
+// output is where one run of the generator logs its progress and writes its
+// files, so callers get the list of files written instead of scraping the log.
+type output struct {
+	logger *log.Logger
+	fs     WriteFS
+	files  []string          // Paths written, in order
+	hashes map[string]string // utils.ContentHash of each file written, by path
+	// keep lists the paths of existing files to leave alone. Regeneration sets
+	// it to the files its reset left, which belong to the user.
+	keep map[string]bool
+	kept []string // Paths left alone because of keep
+}
+
+// newOutput returns an output writing to fs and logging to logger. A nil fs
+// writes to disk and a nil logger logs to the standard logger.
+func newOutput(fs WriteFS, logger *log.Logger) *output {
+	if fs == nil {
+		fs = DiskFS{}
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &output{logger: logger, fs: fs, hashes: make(map[string]string)}
+}
+
+// logf logs a progress message.
+func (o *output) logf(format string, args ...interface{}) {
+	o.logger.Printf(format, args...)
+}
+
+// writeFile writes data to path and records it. It reports whether the file
+// was written, which it isn't if it is kept.
+func (o *output) writeFile(path string, data []byte) (bool, error) {
+	if o.keep[filepath.Clean(path)] {
+		o.logf("Info: Keeping %s instead of generating it: it was written or modified by hand. Delete it to generate it again.", path)
+		o.kept = append(o.kept, path)
+		return false, nil
+	}
+	if err := o.fs.WriteFile(path, data); err != nil {
+		return false, fmt.Errorf("error writing file %s: %w", path, err)
+	}
+	o.files = append(o.files, path)
+	o.hashes[path] = utils.ContentHash(data)
+	return true, nil
+}
+
+// writeGoFile formats the generated code and writes it to path. Code that
+// doesn't format is written as is, with a warning, so it can be inspected.
+// what names the code in the warning (e.g., "enums").
+func (o *output) writeGoFile(path string, code []byte, what string) error {
+	formatted, err := format.Source(code)
+	if err != nil {
+		o.logf("Warning: Failed to format generated %s: %v. Writing unformatted code.", what, err)
+		formatted = code // Fallback
+	}
+	if written, err := o.writeFile(path, formatted); err != nil || !written {
+		return err
+	}
+	if _, inMemory := o.fs.(MapFS); inMemory {
+		o.logf("Generated %s (in memory)", path)
+	} else {
+		o.logf("Generated %s", path)
+	}
+	return nil
+}
@@ -14,216 +14,55 @@ import (
 // {{.StructName}} represents the {{.StructName}} structure.
 type {{.StructName}} struct {
     {{range .Fields}}
-    {{.Name}} {{.Type}} ` + "`{{if .Tags}}{{.Tags}}{{end}}`" + ` // {{.Description}}
+    {{.Name}} {{goType .}} ` + "`{{if .Tags}}{{.Tags}}{{end}}`" + ` // {{.Description}}
     {{end}}
 }
-
+{{if .Expressions}}
+// Length, count and condition expressions of {{.StructName}}, parsed once when the package is loaded.
+var (
+	{{- range .Expressions}}
+	{{.Name}} = {{if $.HasFunctions}}utils.MustCompileExpressionWith(` + "`{{.Expr}}`" + `, expressionFunctions){{else}}utils.MustCompileExpression(` + "`{{.Expr}}`" + `){{end}} // {{.Field}} {{.Kind}}
+	{{- end}}
+)
+{{end}}
+{{- if .ContextFields}}
+// {{.ContextType}} is the context passed to {{.StructName}}.Read: the values its expressions refer to as ctx.
+type {{.ContextType}} struct {
+    {{range .ContextFields}}
+    {{.Name}} {{.Type}} // {{.Description}}
+    {{end}}
+}
+{{end}}
+{{- if .ContextType}}
+// Read populates the struct fields by reading from an io.Reader.
+// ctx is the {{.ContextType}} that length, count and condition expressions refer to as 'ctx.'.
+func (s *{{.StructName}}) Read(r io.Reader, ctx *{{.ContextType}}) error {
+{{- else}}
 // Read populates the struct fields by reading from an io.Reader, using optional context.
 // The context can be used by dynamic length calculations.
 func (s *{{.StructName}}) Read(r io.Reader, ctx interface{}) error {
+{{- end}}
 	{{if .NeedsErrVarRead}}var err error{{end}} // Declare err only if needed for Read
 	{{if .NeedsBVar}}var b []byte{{end}}
-	{{if .NeedsTmpUint8}}var tmpUint8 uint8{{end}} // <-- Declare tmpUint8 once if needed
+	{{if .CtxRequired}}
+	if ctx == nil { return fmt.Errorf("reading {{.StructName}}: ctx must be a non-nil *{{.ContextType}}") }
+	{{end}}
+	{{if .NativeCtxType}}
+	typedCtx, _ := ctx.(*{{.NativeCtxType}}) // Context of the native expressions below
+	if typedCtx == nil { return fmt.Errorf("reading {{.StructName}}: ctx must be a non-nil *{{.NativeCtxType}}, got %T", ctx) }
+	{{end}}
 
     {{range $index, $field := .Fields}}
 	// Read {{$field.Name}} ({{$field.Type}})
 	{{if isConditional $field}}
-	// Conditional field: {{$field.Name}}
-	if {{generateConditionCheck $field.Condition}} {
-		// ... (string, []byte, other numeric types logic remains the same) ...
-		{{if eq $field.Type "uint8"}} // Handle uint8
-		// Use assignment '=' instead of 'var'
-		err = binary.Read(r, binary.LittleEndian, &tmpUint8) // <-- Assign to existing tmpUint8
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} (uint8): %w", err) }
-		s.{{$field.Name}} = tmpUint8
-		// ... (other types like uint16, uint32 etc.) ...
-		{{else if eq $field.Type "uint16"}}
-		err = binary.Read(r, binary.LittleEndian, &s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} (uint16): %w", err) }
-		{{else if eq $field.Type "uint32"}}
-		err = binary.Read(r, binary.LittleEndian, &s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} (uint32): %w", err) }
-		{{else if eq $field.Type "int32"}}
-		err = binary.Read(r, binary.LittleEndian, &s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} (int32): %w", err) }
-		// Add other numeric types (int8, int16, int64, uint64, float32, float64) here if needed
-		{{else if eq $field.Type "string"}}
-			{{if $field.Length}}
-				{{if needsManualLength $field}}
-		// TODO: Manual implementation required for reading conditional string field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for conditional string field '{{$field.Name}}'")
-				{{else if isExpressionLength $field}}
-		// Dynamic length string field: {{$field.Name}} using expression: {{$field.Length}}
-		expressionStr := ` + "`{{$field.Length}}`" + `
-		expression, errExpr := govaluate.NewEvaluableExpressionWithFunctions(expressionStr, utils.GetExpressionFunctions()) // Use helpers from generator pkg
-		if errExpr != nil {
-			return fmt.Errorf("parsing length expression for conditional field {{$field.Name}} ('%s'): %w", expressionStr, errExpr)
-		}
-		parameters := map[string]interface{}{"s": s, "ctx": ctx}
-		evalResult, errEval := expression.Evaluate(parameters)
-		if errEval != nil {
-			return fmt.Errorf("evaluating length expression for conditional field {{$field.Name}} ('%s'): %w", expressionStr, errEval)
-		}
-		var size int
-		switch v := evalResult.(type) { // Type conversion logic
-		case float64: size = int(v); case float32: size = int(v); case int: size = v; case int64: size = int(v); case int32: size = int(v)
-		case uint: size = int(v); case uint64: size = int(v); case uint32: size = int(v); case uint16: size = int(v); case uint8: size = int(v)
-		default: return fmt.Errorf("length expression for conditional field {{$field.Name}} ('%s') evaluated to non-numeric type %T", expressionStr, evalResult)
-		}
-		if size < 0 { return fmt.Errorf("length expression for conditional field {{$field.Name}} ('%s') evaluated to negative size %d", expressionStr, size) }
-		b = make([]byte, size)
-		_, err = io.ReadFull(r, b)
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} (string[dynamic length %s]): %w", expressionStr, err) }
-		s.{{$field.Name}} = string(b)
-				{{else}}
-					{{$length := $field.Length | atoi}}
-					{{if gt $length 0}}
-		b = make([]byte, {{$length}})
-		_, err = io.ReadFull(r, b)
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} (string[{{$length}}]): %w", err) }
-		s.{{$field.Name}} = string(b)
-					{{else}}
-		return fmt.Errorf("invalid length {{$length}} for conditional string field {{$field.Name}}")
-					{{end}}
-				{{end}}
-			{{else}}
-		return fmt.Errorf("cannot automatically read conditional string field {{$field.Name}} without a defined length")
-			{{end}}
-		{{else if eq $field.Type "[]byte"}}
-			{{if $field.Length}}
-				{{if needsManualLength $field}}
-		// TODO: Manual implementation required for reading conditional []byte field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for conditional []byte field '{{$field.Name}}'")
-				{{else if isExpressionLength $field}}
-		// Dynamic length []byte field: {{$field.Name}} using expression: {{$field.Length}}
-		expressionStr := ` + "`{{$field.Length}}`" + `
-		expression, errExpr := govaluate.NewEvaluableExpressionWithFunctions(expressionStr, utils.GetExpressionFunctions()) // Use helpers
-		if errExpr != nil { return fmt.Errorf("parsing length expression for conditional field {{$field.Name}} ('%s'): %w", expressionStr, errExpr) }
-		parameters := map[string]interface{}{"s": s, "ctx": ctx}
-		evalResult, errEval := expression.Evaluate(parameters)
-		if errEval != nil { return fmt.Errorf("evaluating length expression for conditional field {{$field.Name}} ('%s'): %w", expressionStr, errEval) }
-		var size int
-		switch v := evalResult.(type) { // Type conversion logic
-		case float64: size = int(v); case float32: size = int(v); case int: size = v; case int64: size = int(v); case int32: size = int(v)
-		case uint: size = int(v); case uint64: size = int(v); case uint32: size = int(v); case uint16: size = int(v); case uint8: size = int(v)
-		default: return fmt.Errorf("length expression for conditional field {{$field.Name}} ('%s') evaluated to non-numeric type %T", expressionStr, evalResult)
+	{ // Conditional field: {{$field.Name}}
+		{{template "evalCondition" conditionData (fieldData $.StructName $field)}}
+		if present {
+			{{template "readField" fieldData $.StructName $field}}
 		}
-		if size < 0 { return fmt.Errorf("length expression for conditional field {{$field.Name}} ('%s') evaluated to negative size %d", expressionStr, size) }
-		s.{{$field.Name}} = make([]byte, size)
-		_, err = io.ReadFull(r, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} ([]byte[dynamic length %s]): %w", expressionStr, err) }
-				{{else}}
-					{{$length := $field.Length | atoi}}
-					{{if gt $length 0}}
-		s.{{$field.Name}} = make([]byte, {{$length}})
-		_, err = io.ReadFull(r, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading conditional field {{$field.Name}} ([]byte[{{$length}}]): %w", err) }
-					{{else}}
-		return fmt.Errorf("invalid length {{$length}} for conditional []byte field {{$field.Name}}")
-					{{end}}
-				{{end}}
-			{{else}}
-		return fmt.Errorf("cannot automatically read conditional []byte field {{$field.Name}} without a defined length")
-			{{end}}
-		{{else}}
-		return fmt.Errorf("unsupported type '%s' for conditional field {{$field.Name}} in Read method", "{{$field.Type}}")
-		{{end}}
 	} {{/* End conditional block */}}
 	{{else}} {{/* Start non-conditional block */}}
-		// ... (string, []byte, other numeric types logic remains the same) ...
-		{{if eq $field.Type "uint8"}} // Handle uint8
-		// Use assignment '=' instead of 'var'
-		err = binary.Read(r, binary.LittleEndian, &tmpUint8) // <-- Assign to existing tmpUint8
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} (uint8): %w", err) }
-		s.{{$field.Name}} = tmpUint8
-		// ... (other types like uint16, uint32 etc.) ...
-		{{else if eq $field.Type "uint16"}}
-		err = binary.Read(r, binary.LittleEndian, &s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} (uint16): %w", err) }
-		{{else if eq $field.Type "uint32"}}
-		err = binary.Read(r, binary.LittleEndian, &s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} (uint32): %w", err) }
-		{{else if eq $field.Type "int32"}}
-		err = binary.Read(r, binary.LittleEndian, &s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} (int32): %w", err) }
-		// Add other numeric types here if needed
-		{{else if eq $field.Type "string"}}
-			{{if $field.Length}}
-				{{if needsManualLength $field}}
-		// TODO: Manual implementation required for reading string field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for string field '{{$field.Name}}'")
-				{{else if isExpressionLength $field}}
-		// Dynamic length string field: {{$field.Name}} using expression: {{$field.Length}}
-		expressionStr := ` + "`{{$field.Length}}`" + `
-		expression, errExpr := govaluate.NewEvaluableExpressionWithFunctions(expressionStr, utils.GetExpressionFunctions()) // Use helpers
-		if errExpr != nil { return fmt.Errorf("parsing length expression for {{$field.Name}} ('%s'): %w", expressionStr, errExpr) }
-		parameters := map[string]interface{}{"s": s, "ctx": ctx}
-		evalResult, errEval := expression.Evaluate(parameters)
-		if errEval != nil { return fmt.Errorf("evaluating length expression for {{$field.Name}} ('%s'): %w", expressionStr, errEval) }
-		var size int
-		switch v := evalResult.(type) { // Type conversion logic
-		case float64: size = int(v); case float32: size = int(v); case int: size = v; case int64: size = int(v); case int32: size = int(v)
-		case uint: size = int(v); case uint64: size = int(v); case uint32: size = int(v); case uint16: size = int(v); case uint8: size = int(v)
-		default: return fmt.Errorf("length expression for {{$field.Name}} ('%s') evaluated to non-numeric type %T", expressionStr, evalResult)
-		}
-		if size < 0 { return fmt.Errorf("length expression for {{$field.Name}} ('%s') evaluated to negative size %d", expressionStr, size) }
-		b = make([]byte, size)
-		_, err = io.ReadFull(r, b)
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} (string[dynamic length %s]): %w", expressionStr, err) }
-		s.{{$field.Name}} = string(b)
-				{{else}}
-					{{$length := $field.Length | atoi}}
-					{{if gt $length 0}}
-		b = make([]byte, {{$length}})
-		_, err = io.ReadFull(r, b)
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} (string[{{$length}}]): %w", err) }
-		s.{{$field.Name}} = string(b)
-					{{else}}
-		return fmt.Errorf("invalid length {{$length}} for string field {{$field.Name}}")
-					{{end}}
-				{{end}}
-			{{else}}
-		return fmt.Errorf("cannot automatically read string field {{$field.Name}} without a defined length")
-			{{end}}
-		{{else if eq $field.Type "[]byte"}}
-			{{if $field.Length}}
-				{{if needsManualLength $field}}
-		// TODO: Manual implementation required for reading []byte field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for []byte field '{{$field.Name}}'")
-				{{else if isExpressionLength $field}}
-		// Dynamic length []byte field: {{$field.Name}} using expression: {{$field.Length}}
-		expressionStr := ` + "`{{$field.Length}}`" + `
-		expression, errExpr := govaluate.NewEvaluableExpressionWithFunctions(expressionStr, utils.GetExpressionFunctions()) // Use helpers
-		if errExpr != nil { return fmt.Errorf("parsing length expression for {{$field.Name}} ('%s'): %w", expressionStr, errExpr) }
-		parameters := map[string]interface{}{"s": s, "ctx": ctx}
-		evalResult, errEval := expression.Evaluate(parameters)
-		if errEval != nil { return fmt.Errorf("evaluating length expression for {{$field.Name}} ('%s'): %w", expressionStr, errEval) }
-		var size int
-		switch v := evalResult.(type) { // Type conversion logic
-		case float64: size = int(v); case float32: size = int(v); case int: size = v; case int64: size = int(v); case int32: size = int(v)
-		case uint: size = int(v); case uint64: size = int(v); case uint32: size = int(v); case uint16: size = int(v); case uint8: size = int(v)
-		default: return fmt.Errorf("length expression for {{$field.Name}} ('%s') evaluated to non-numeric type %T", expressionStr, evalResult)
-		}
-		if size < 0 { return fmt.Errorf("length expression for {{$field.Name}} ('%s') evaluated to negative size %d", expressionStr, size) }
-		s.{{$field.Name}} = make([]byte, size)
-		_, err = io.ReadFull(r, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} ([]byte[dynamic length %s]): %w", expressionStr, err) }
-				{{else}}
-					{{$length := $field.Length | atoi}}
-					{{if gt $length 0}}
-		s.{{$field.Name}} = make([]byte, {{$length}})
-		_, err = io.ReadFull(r, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("reading {{$field.Name}} ([]byte[{{$length}}]): %w", err) }
-					{{else}}
-		return fmt.Errorf("invalid length {{$length}} for []byte field {{$field.Name}}")
-					{{end}}
-				{{end}}
-			{{else}}
-		return fmt.Errorf("cannot automatically read []byte field {{$field.Name}} without a defined length")
-			{{end}}
-		{{else}}
-		return fmt.Errorf("unsupported type '%s' for field {{$field.Name}} in Read method", "{{$field.Type}}")
-		{{end}}
+		{{template "readField" fieldData $.StructName $field}}
 	{{end}} {{/* End non-conditional block */}}
     {{end}} {{/* End range .Fields */}}
 
@@ -235,96 +74,386 @@ func (s *{{.StructName}}) Read(r io.Reader, ctx interface{}) error {
 	{{/* Implicit: If not NeedsErrVarRead and Fields exist, all paths returned early */}}
 }
 
+{{- if .ContextType}}
 // Write serializes the struct fields into an io.Writer.
-func (s *{{.StructName}}) Write(w io.Writer) error {
+// ctx is the {{.ContextType}} that length, count and condition expressions refer to as 'ctx.', as in Read.
+func (s *{{.StructName}}) Write(w io.Writer, ctx *{{.ContextType}}) error {
+{{- else}}
+// Write serializes the struct fields into an io.Writer, using optional context.
+// The context is the one Read receives, so expressions evaluate as they do when reading.
+func (s *{{.StructName}}) Write(w io.Writer, ctx interface{}) error {
+{{- end}}
 	{{if .NeedsErrVarWrite}}var err error{{end}} // Declare err only if needed for Write
-	// NOTE: tmpUint8 is not needed for Write method as we write directly from s.FieldName
+	{{if .CtxRequired}}
+	if ctx == nil { return fmt.Errorf("writing {{.StructName}}: ctx must be a non-nil *{{.ContextType}}") }
+	{{end}}
+	{{if .NativeCtxType}}
+	typedCtx, _ := ctx.(*{{.NativeCtxType}}) // Context of the native expressions below
+	if typedCtx == nil { return fmt.Errorf("writing {{.StructName}}: ctx must be a non-nil *{{.NativeCtxType}}, got %T", ctx) }
+	{{end}}
 
     {{range $index, $field := .Fields}}
 	// Write {{$field.Name}} ({{$field.Type}})
 	{{if isConditional $field}}
-	// Conditional field: {{$field.Name}}
-	if {{generateConditionCheck $field.Condition}} {
-		// ... (string, []byte, other numeric types logic remains the same) ...
-		{{if eq $field.Type "uint8"}} // Handle numeric types
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}}) // Write directly
-		if err != nil { return fmt.Errorf("writing conditional field {{$field.Name}} (uint8): %w", err) }
-		// ... (other types like uint16, uint32 etc.) ...
-		{{else if eq $field.Type "uint16"}}
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing conditional field {{$field.Name}} (uint16): %w", err) }
-		{{else if eq $field.Type "uint32"}}
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing conditional field {{$field.Name}} (uint32): %w", err) }
-		{{else if eq $field.Type "int32"}}
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing conditional field {{$field.Name}} (int32): %w", err) }
-		// Add other numeric types here if needed
-		{{else if eq $field.Type "string"}}
-			{{if needsManualLength $field}}
-		// TODO: Manual implementation required for writing conditional string field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for writing conditional string field '{{$field.Name}}'")
+	{ // Conditional field: {{$field.Name}}
+		{{template "evalCondition" conditionData (fieldData $.StructName $field)}}
+		if present {
+			{{template "writeField" fieldData $.StructName $field}}
+		}
+	} {{/* End conditional block */}}
+	{{else}} {{/* Start non-conditional block */}}
+		{{template "writeField" fieldData $.StructName $field}}
+	{{end}} {{/* End non-conditional block */}}
+    {{end}} {{/* End range .Fields */}}
+
+	{{if .NeedsErrVarWrite}}
+	return nil // If we got here, all writes using 'err' were successful
+	{{else if not .Fields}}
+	return nil // No fields, trivially successful
+	{{end}}
+	{{/* Implicit: If not NeedsErrVarWrite and Fields exist, all paths returned early */}}
+}
+{{range .BitFields}}
+{{- if .Flag}}
+// {{.Name}} reports whether bit {{.Lo}} of {{.Field}} is set{{if .Description}} ({{.Description}}){{end}}.
+func (s *{{$.StructName}}) {{.Name}}() bool {
+	return s.{{.Field}}&(1<<{{.Lo}}) != 0
+}
+
+// Set{{.Name}} sets or clears bit {{.Lo}} of {{.Field}}.
+func (s *{{$.StructName}}) Set{{.Name}}(v bool) {
+	if v {
+		s.{{.Field}} |= 1 << {{.Lo}}
+	} else {
+		s.{{.Field}} &^= 1 << {{.Lo}}
+	}
+}
+{{else}}
+// {{.Name}} returns bits {{.Lo}}-{{.Hi}} of {{.Field}}{{if .Description}} ({{.Description}}){{end}}.
+func (s *{{$.StructName}}) {{.Name}}() {{.Type}} {
+	return {{.Type}}(s.{{.Field}}{{if .Lo}}>>{{.Lo}}{{end}}) & {{.Mask}}
+}
+
+// Set{{.Name}} stores v in bits {{.Lo}}-{{.Hi}} of {{.Field}}. Bits of v that don't fit are dropped.
+func (s *{{$.StructName}}) Set{{.Name}}(v {{.Type}}) {
+	s.{{.Field}} = s.{{.Field}}&^{{.FieldMask}} | {{.GoType}}(v&{{.Mask}}){{if .Lo}}<<{{.Lo}}{{end}}
+}
+{{end}}
+{{- end}}
+`
+
+// ReadFieldTemplate generates the Read code for a single field. It is shared by
+// conditional and non-conditional fields so both paths support the same types.
+// The dot is a FieldTemplateData.
+var ReadFieldTemplate = `{{define "readField"}}
+	{{$field := .Field}}
+	{{if isRepeated $field}}
+		{{$elem := elemType $field}}
+		{{if isCountToEOF $field}}
+		{ // Repeated field {{$field.Name}}: read {{$elem}} elements until end of stream
+		s.{{$field.Name}} = nil
+		for {
+			// Read one byte first so a clean end of stream can be told apart from a truncated element
+			var first [1]byte
+			_, err = io.ReadFull(r, first[:])
+			if err == io.EOF { break }
+			if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}}[%d] ({{$elem}}): %w", len(s.{{$field.Name}}), err) }
+			elemReader := io.MultiReader(bytes.NewReader(first[:]), r)
+			var elem {{goElemType $field}}
+			{{if isNumeric $elem}}
+			err = binary.Read(elemReader, {{byteOrder $field}}, &elem)
 			{{else}}
-		_, err = w.Write([]byte(s.{{$field.Name}}))
-		if err != nil { return fmt.Errorf("writing conditional field {{$field.Name}} (string): %w", err) }
-		// TODO: Add padding if fixed length string is required?
+			err = elem.Read(elemReader, s)
 			{{end}}
-		{{else if eq $field.Type "[]byte"}}
-			{{if needsManualLength $field}}
-		// TODO: Manual implementation required for writing conditional []byte field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for writing conditional []byte field '{{$field.Name}}'")
-			{{else}}
-		_, err = w.Write(s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing conditional field {{$field.Name}} ([]byte): %w", err) }
+			if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}}[%d] ({{$elem}}): %w", len(s.{{$field.Name}}), err) }
+			{{if strictEnum $field}}
+			if !elem.IsValid() { return fmt.Errorf("reading {{.Label}}{{$field.Name}}[%d]: invalid {{$field.Enum}} value %d", len(s.{{$field.Name}}), elem) }
 			{{end}}
+			s.{{$field.Name}} = append(s.{{$field.Name}}, elem)
+		}
+		}
+		{{else}}
+		{ // Repeated field {{$field.Name}}: {{$field.Count}} {{$elem}} element(s)
+		{{if isExpressionCount $field}}
+		{{template "evalExpr" exprData . "count"}}
 		{{else}}
-		return fmt.Errorf("unsupported type '%s' for conditional field {{$field.Name}} in Write method", "{{$field.Type}}")
+		count := {{$field.Count}}
 		{{end}}
-	} {{/* End conditional block */}}
-	{{else}} {{/* Start non-conditional block */}}
-		// ... (string, []byte, other numeric types logic remains the same) ...
-		{{if eq $field.Type "uint8"}} // Handle numeric types
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}}) // Write directly
-		if err != nil { return fmt.Errorf("writing {{$field.Name}} (uint8): %w", err) }
-		// ... (other types like uint16, uint32 etc.) ...
-		{{else if eq $field.Type "uint16"}}
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing {{$field.Name}} (uint16): %w", err) }
-		{{else if eq $field.Type "uint32"}}
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing {{$field.Name}} (uint32): %w", err) }
-		{{else if eq $field.Type "int32"}}
-		err = binary.Write(w, binary.LittleEndian, s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing {{$field.Name}} (int32): %w", err) }
-		// Add other numeric types here if needed
-		{{else if eq $field.Type "string"}}
+		s.{{$field.Name}} = make({{goType $field}}, count)
+		{{if isNumeric $elem}}
+		err = binary.Read(r, {{byteOrder $field}}, s.{{$field.Name}})
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} ([]{{$elem}}[%d]): %w", count, err) }
+		{{if strictEnum $field}}
+		for i, elem := range s.{{$field.Name}} {
+			if !elem.IsValid() { return fmt.Errorf("reading {{.Label}}{{$field.Name}}[%d]: invalid {{$field.Enum}} value %d", i, elem) }
+		}
+		{{end}}
+		{{else}}
+		for i := range s.{{$field.Name}} {
+			err = s.{{$field.Name}}[i].Read(r, s)
+			if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}}[%d] ({{$elem}}): %w", i, err) }
+		}
+		{{end}}
+		}
+		{{end}}
+	{{else if isNumeric $field.Type}}
+		err = binary.Read(r, {{byteOrder $field}}, &s.{{$field.Name}})
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} ({{$field.Type}}): %w", err) }
+		{{if strictEnum $field}}
+		if !s.{{$field.Name}}.IsValid() { return fmt.Errorf("reading {{.Label}}{{$field.Name}}: invalid {{$field.Enum}} value %d", s.{{$field.Name}}) }
+		{{end}}
+	{{else if and (eq $field.Type "string") (isSelfDelimited $field)}}
+		{{template "readEncodedString" .}}
+	{{else if eq $field.Type "string"}}
+		{{if $field.Length}}
 			{{if needsManualLength $field}}
-		// TODO: Manual implementation required for writing string field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for writing string field '{{$field.Name}}'")
+		// TODO: Manual implementation required for reading {{.Label}}string field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
+		return fmt.Errorf("manual implementation needed for {{.Label}}string field '{{$field.Name}}'")
+			{{else if isExpressionLength $field}}
+		{ // Dynamic length string field: {{$field.Name}} using expression: {{$field.Length}}
+		{{template "evalExpr" exprData . "length"}}
+		b = make([]byte, size)
+		_, err = io.ReadFull(r, b)
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} (string[dynamic length %s]): %w", expressionStr, err) }
+		s.{{$field.Name}} = {{template "stringFromB" $field}}
+		}
 			{{else}}
-		_, err = w.Write([]byte(s.{{$field.Name}}))
-		if err != nil { return fmt.Errorf("writing {{$field.Name}} (string): %w", err) }
-		// TODO: Add padding if fixed length string is required?
+				{{$length := $field.Length | atoi}}
+				{{if gt $length 0}}
+		b = make([]byte, {{$length}})
+		_, err = io.ReadFull(r, b)
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} (string[{{$length}}]): %w", err) }
+		s.{{$field.Name}} = {{template "stringFromB" $field}}
+				{{else}}
+		return fmt.Errorf("invalid length {{$length}} for {{.Label}}string field {{$field.Name}}")
+				{{end}}
 			{{end}}
-		{{else if eq $field.Type "[]byte"}}
+		{{else}}
+		return fmt.Errorf("cannot automatically read {{.Label}}string field {{$field.Name}} without a defined length")
+		{{end}}
+	{{else if eq $field.Type "[]byte"}}
+		{{if $field.Length}}
 			{{if needsManualLength $field}}
-		// TODO: Manual implementation required for writing []byte field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
-		return fmt.Errorf("manual implementation needed for writing []byte field '{{$field.Name}}'")
+		// TODO: Manual implementation required for reading {{.Label}}[]byte field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
+		return fmt.Errorf("manual implementation needed for {{.Label}}[]byte field '{{$field.Name}}'")
+			{{else if isExpressionLength $field}}
+		{ // Dynamic length []byte field: {{$field.Name}} using expression: {{$field.Length}}
+		{{template "evalExpr" exprData . "length"}}
+		s.{{$field.Name}} = make([]byte, size)
+		_, err = io.ReadFull(r, s.{{$field.Name}})
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} ([]byte[dynamic length %s]): %w", expressionStr, err) }
+		}
 			{{else}}
-		_, err = w.Write(s.{{$field.Name}})
-		if err != nil { return fmt.Errorf("writing {{$field.Name}} ([]byte): %w", err) }
+				{{$length := $field.Length | atoi}}
+				{{if gt $length 0}}
+		s.{{$field.Name}} = make([]byte, {{$length}})
+		_, err = io.ReadFull(r, s.{{$field.Name}})
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} ([]byte[{{$length}}]): %w", err) }
+				{{else}}
+		return fmt.Errorf("invalid length {{$length}} for {{.Label}}[]byte field {{$field.Name}}")
+				{{end}}
 			{{end}}
 		{{else}}
-		return fmt.Errorf("unsupported type '%s' for field {{$field.Name}} in Write method", "{{$field.Type}}")
+		return fmt.Errorf("cannot automatically read {{.Label}}[]byte field {{$field.Name}} without a defined length")
 		{{end}}
-	{{end}} {{/* End non-conditional block */}}
-    {{end}} {{/* End range .Fields */}}
+	{{else if isStruct $field.Type}}
+		// Nested struct: the enclosing struct is passed as its context
+		err = s.{{$field.Name}}.Read(r, s)
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} ({{$field.Type}}): %w", err) }
+	{{else}}
+		return fmt.Errorf("unsupported type '%s' for {{.Label}}field {{$field.Name}} in Read method", "{{$field.Type}}")
+	{{end}}
+	{{if $field.Value}}
+		{{$value := valueLiteral $field}}
+		{{if eq $field.Type "[]byte"}}
+		if string(s.{{$field.Name}}) != {{$value}} { return &ValueMismatchError{Struct: "{{.StructName}}", Field: "{{$field.Name}}", Want: []byte({{$value}}), Got: s.{{$field.Name}}} }
+		{{else}}
+		if s.{{$field.Name}} != {{$value}} { return &ValueMismatchError{Struct: "{{.StructName}}", Field: "{{$field.Name}}", Want: {{if eq $field.Type "string"}}{{$value}}{{else}}{{goType $field}}({{$value}}){{end}}, Got: s.{{$field.Name}}} }
+		{{end}}
+	{{end}}
+{{end}}
 
-	{{if .NeedsErrVarWrite}}
-	return nil // If we got here, all writes using 'err' were successful
-	{{else if not .Fields}}
-	return nil // No fields, trivially successful
+{{define "stringFromB"}}{{if isPadded .}}string(bytes.TrimRight(b, {{padCutset .}})) // Strip trailing padding{{else}}string(b){{end}}{{end}}
+
+{{define "readEncodedString"}}
+	{{$field := .Field}}
+	{{if eq $field.Encoding "cstring"}}
+		{ // Null-terminated string{{if $field.MaxLength}} of at most {{$field.MaxLength}} byte(s){{end}}
+		var c [1]byte
+		b = b[:0]
+		for {
+			_, err = io.ReadFull(r, c[:])
+			if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} (cstring): %w", err) }
+			if c[0] == 0 { break }
+			{{if $field.MaxLength}}
+			if len(b) == {{$field.MaxLength}} { return fmt.Errorf("reading {{.Label}}{{$field.Name}} (cstring): no terminator within max_length {{$field.MaxLength}}") }
+			{{end}}
+			b = append(b, c[0])
+		}
+		s.{{$field.Name}} = string(b)
+		}
+	{{else}}
+		{ // Length-prefixed string: {{lengthPrefixType $field}} byte count, then the bytes
+		var n {{lengthPrefixType $field}}
+		err = binary.Read(r, {{byteOrder $field}}, &n)
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} length prefix ({{lengthPrefixType $field}}): %w", err) }
+		{{if $field.MaxLength}}
+		if int(n) > {{$field.MaxLength}} { return fmt.Errorf("reading {{.Label}}{{$field.Name}} ({{$field.Encoding}}): length %d exceeds max_length {{$field.MaxLength}}", n) }
+		{{end}}
+		b = make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		if err != nil { return fmt.Errorf("reading {{.Label}}{{$field.Name}} ({{$field.Encoding}}[%d]): %w", n, err) }
+		s.{{$field.Name}} = string(b)
+		}
 	{{end}}
-	{{/* Implicit: If not NeedsErrVarWrite and Fields exist, all paths returned early */}}
-}
+{{end}}
+
+{{define "evalExpr"}}
+		expressionStr := ` + "`{{.Expr}}`" + `
+		{{if isNative}}
+		{{.Var}} := int({{nativeExpr .}})
+		{{else}}
+		evalResult, errEval := {{.ExprVar}}.Eval(utils.ExpressionParameters{"s": s, "ctx": ctx})
+		if errEval != nil { return fmt.Errorf("evaluating {{.Kind}} expression for {{.Label}}{{.Field.Name}} ('%s'): %w", expressionStr, errEval) }
+		var {{.Var}} int
+		switch v := evalResult.(type) { // Type conversion logic
+		case float64: {{.Var}} = int(v); case float32: {{.Var}} = int(v); case int: {{.Var}} = v; case int64: {{.Var}} = int(v); case int32: {{.Var}} = int(v)
+		case uint: {{.Var}} = int(v); case uint64: {{.Var}} = int(v); case uint32: {{.Var}} = int(v); case uint16: {{.Var}} = int(v); case uint8: {{.Var}} = int(v)
+		default: return fmt.Errorf("{{.Kind}} expression for {{.Label}}{{.Field.Name}} ('%s') evaluated to non-numeric type %T", expressionStr, evalResult)
+		}
+		{{end}}
+		if {{.Var}} < 0 { return fmt.Errorf("{{.Kind}} expression for {{.Label}}{{.Field.Name}} ('%s') evaluated to negative {{.Kind}} %d", expressionStr, {{.Var}}) }
+{{end}}
+
+{{define "evalCondition"}}
+		{{if isNative}}
+		{{.Var}} := {{nativeExpr .}}
+		{{else}}
+		conditionStr := ` + "`{{.Expr}}`" + `
+		conditionResult, errEval := {{.ExprVar}}.Eval(utils.ExpressionParameters{"s": s, "ctx": ctx})
+		if errEval != nil { return fmt.Errorf("evaluating condition for {{.Field.Name}} ('%s'): %w", conditionStr, errEval) }
+		{{.Var}}, isBool := conditionResult.(bool)
+		if !isBool { return fmt.Errorf("condition for {{.Field.Name}} ('%s') evaluated to non-boolean type %T", conditionStr, conditionResult) }
+		{{end}}
+{{end}}
+`
+
+// WriteFieldTemplate generates the Write code for a single field. The dot is a
+// FieldTemplateData.
+var WriteFieldTemplate = `{{define "writeField"}}
+	{{$field := .Field}}
+	{{if $field.Value}}
+		// {{$field.Name}} is a constant: its value is written whatever the struct holds
+		{{if isNumeric $field.Type}}
+		err = binary.Write(w, {{byteOrder $field}}, {{$field.Type}}({{valueLiteral $field}}))
+		{{else}}
+		_, err = io.WriteString(w, {{valueLiteral $field}})
+		{{end}}
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Type}}): %w", err) }
+	{{else if isRepeated $field}}
+		{{$elem := elemType $field}}
+		{{if isExpressionCount $field}}
+		{ // Write checks the count of {{$field.Name}} like Read uses it
+		{{template "evalExpr" exprData . "count"}}
+		if len(s.{{$field.Name}}) != count {
+			return fmt.Errorf("writing {{.Label}}{{$field.Name}}: count expression ('%s') expects %d element(s), got %d", expressionStr, count, len(s.{{$field.Name}}))
+		}
+		}
+		{{else if not (isCountToEOF $field)}}
+		if len(s.{{$field.Name}}) != {{$field.Count}} {
+			return fmt.Errorf("writing {{.Label}}{{$field.Name}}: expected {{$field.Count}} element(s), got %d", len(s.{{$field.Name}}))
+		}
+		{{end}}
+		{{if isNumeric $elem}}
+		err = binary.Write(w, {{byteOrder $field}}, s.{{$field.Name}})
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} ([]{{$elem}}): %w", err) }
+		{{else}}
+		for i := range s.{{$field.Name}} {
+			err = s.{{$field.Name}}[i].Write(w, s)
+			if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}}[%d] ({{$elem}}): %w", i, err) }
+		}
+		{{end}}
+	{{else if isNumeric $field.Type}}
+		err = binary.Write(w, {{byteOrder $field}}, s.{{$field.Name}})
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Type}}): %w", err) }
+	{{else if and (eq $field.Type "string") (isSelfDelimited $field)}}
+		{{template "writeEncodedString" .}}
+	{{else if eq $field.Type "string"}}
+		{{if needsManualLength $field}}
+		// TODO: Manual implementation required for writing {{.Label}}string field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
+		return fmt.Errorf("manual implementation needed for writing {{.Label}}string field '{{$field.Name}}'")
+		{{else}}
+		{{template "writeSized" .}}
+		{{end}}
+	{{else if eq $field.Type "[]byte"}}
+		{{if needsManualLength $field}}
+		// TODO: Manual implementation required for writing {{.Label}}[]byte field '{{$field.Name}}' (Length: NEEDS_MANUAL_LENGTH)
+		return fmt.Errorf("manual implementation needed for writing {{.Label}}[]byte field '{{$field.Name}}'")
+		{{else}}
+		{{template "writeSized" .}}
+		{{end}}
+	{{else if isStruct $field.Type}}
+		// Nested struct: the enclosing struct is passed as its context, as in Read
+		err = s.{{$field.Name}}.Write(w, s)
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Type}}): %w", err) }
+	{{else}}
+		return fmt.Errorf("unsupported type '%s' for {{.Label}}field {{$field.Name}} in Write method", "{{$field.Type}}")
+	{{end}}
+{{end}}
+
+{{define "writeSized"}}
+	{{$field := .Field}}
+		{ // {{$field.Name}} is written as exactly {{$field.Length}} byte(s){{if isPadded $field}}, padded with {{padByte $field}}{{end}}
+		value := {{if eq $field.Type "string"}}[]byte(s.{{$field.Name}}){{else}}s.{{$field.Name}}{{end}}
+		{{if isExpressionLength $field}}
+		{{template "evalExpr" exprData . "length"}}
+		{{else}}
+		size := {{$field.Length}}
+		{{end}}
+		if len(value) > size {
+			{{if $field.Truncate}}
+			value = value[:size] // truncate: true
+			{{else}}
+			return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Type}}): value is %d byte(s), longer than its length %d", len(value), size)
+			{{end}}
+		}
+		{{if not (isPadded $field)}}
+		if len(value) < size {
+			return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Type}}): value is %d byte(s), shorter than its length %d", len(value), size)
+		}
+		{{end}}
+		_, err = w.Write(value)
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Type}}): %w", err) }
+		{{if isPadded $field}}
+		if padding := size - len(value); padding > 0 {
+			_, err = w.Write(bytes.Repeat([]byte{ {{padByte $field}} }, padding))
+			if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} padding: %w", err) }
+		}
+		{{end}}
+		}
+{{end}}
+
+{{define "writeEncodedString"}}
+	{{$field := .Field}}
+	{{if $field.MaxLength}}
+		if len(s.{{$field.Name}}) > {{$field.MaxLength}} {
+			return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Encoding}}): value is %d byte(s), longer than max_length {{$field.MaxLength}}", len(s.{{$field.Name}}))
+		}
+	{{end}}
+	{{if eq $field.Encoding "cstring"}}
+		if strings.IndexByte(s.{{$field.Name}}, 0) >= 0 {
+			return fmt.Errorf("writing {{.Label}}{{$field.Name}} (cstring): value contains a NUL byte")
+		}
+		_, err = w.Write(append([]byte(s.{{$field.Name}}), 0))
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} (cstring): %w", err) }
+	{{else}}
+		if uint64(len(s.{{$field.Name}})) > {{lengthPrefixMax $field}} {
+			return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Encoding}}): value is %d byte(s), too long for a {{lengthPrefixType $field}} length prefix", len(s.{{$field.Name}}))
+		}
+		err = binary.Write(w, {{byteOrder $field}}, {{lengthPrefixType $field}}(len(s.{{$field.Name}})))
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} length prefix ({{lengthPrefixType $field}}): %w", err) }
+		_, err = w.Write([]byte(s.{{$field.Name}}))
+		if err != nil { return fmt.Errorf("writing {{.Label}}{{$field.Name}} ({{$field.Encoding}}): %w", err) }
+	{{end}}
+{{end}}
 `
@@ -11,14 +11,16 @@ const TestFileTemplate = `// Code generated by FormatModule tool; DO NOT EDIT in
 package {{.PackageName}}_test // Use _test package convention
 
 import (
-	// "bytes" // TODO: Uncomment if using bytes.Equal for verification
+	{{if or .RoundTripStructs .FileParts}}"bytes"{{else}}// "bytes" // TODO: Uncomment if using bytes.Equal for verification{{end}}
 	"fmt"
 	// "io" // TODO: Uncomment if using io.ReadFull or other io functions
 	"os"
 	"path/filepath" // For joining paths
 	"reflect"
 	"testing"
-
+{{if .ExpressionBenchmarks}}
+	"FIG/utils" // Evaluates the expressions in the benchmarks like the generated code does
+{{end}}
 	// Import the package containing the generated code
 	"{{.GoModulePath}}/{{.FormatDir}}/{{.PackageName}}" // Adjust GoModulePath if needed
 )
@@ -39,14 +41,17 @@ func TestGeneratedCode_{{.FirstStructName}}(t *testing.T) {
 	// TODO: Define meaningful sample data for {{.PackageName}}.{{.FirstStructName}}
 	// You might need data for other structs as well depending on the format.
 	originalStruct := {{.PackageName}}.{{.FirstStructName}}{
+		{{- range .FirstStructRequired}}
+		{{.Name}}: {{.Value}}, // {{.Comment}}
+		{{- end}}
 		// Field1: sampleValue1,
 		// Field2: sampleValue2,
 		// ... add fields based on your {{.FirstStructName}} definition ...
 	}
-	// TODO: If reading requires context (e.g., for dynamic lengths based on other structs),
-	// prepare the necessary context data here.
-	// var writeCtx interface{} = nil // TODO: Uncomment and adapt if Write method needs context
-	var readCtx interface{} = nil  // Example: No context needed for reading (adapt if needed)
+	// TODO: If reading or writing requires context (e.g., for dynamic lengths based on other structs),
+	// prepare the necessary context data here. Write takes the same context as Read.
+	var writeCtx {{.CtxType}} = nil // Example: No context needed for writing (adapt if needed)
+	var readCtx {{.CtxType}} = nil  // Example: No context needed for reading (adapt if needed)
 
 
 	// --- Ensure testdata directory exists ---
@@ -76,8 +81,8 @@ func TestGeneratedCode_{{.FirstStructName}}(t *testing.T) {
 		// TODO: Write all necessary structs/data in the correct order for the format.
 		// This likely involves more than just the first struct.
 		t.Logf("Writing {{.FirstStructName}}...")
-		// TODO: Pass writeCtx if needed by the Write method
-		if writeErr = originalStruct.Write(writeFile /*, writeCtx */); writeErr != nil { // Comment out ctx passing
+		// Pass writeCtx if needed by the Write method
+		if writeErr = originalStruct.Write(writeFile, writeCtx); writeErr != nil {
 			writeErr = fmt.Errorf("error writing {{.FirstStructName}}: %w", writeErr)
 			return
 		}
@@ -85,7 +90,7 @@ func TestGeneratedCode_{{.FirstStructName}}(t *testing.T) {
 
 		// TODO: Write other structs or raw data as required by the format.
 		// Example:
-		// if writeErr = anotherStruct.Write(writeFile); writeErr != nil { ... }
+		// if writeErr = anotherStruct.Write(writeFile, someContext); writeErr != nil { ... }
 		// if _, writeErr = writeFile.Write(someRawData); writeErr != nil { ... }
 
 	}() // End write closure
@@ -158,6 +163,165 @@ func TestGeneratedCode_{{.FirstStructName}}(t *testing.T) {
 }
 
 // TODO: Add more test cases for edge conditions, errors, different variations of the format.
+{{range .RoundTripStructs}}
+// sample{{.Name}} returns a {{.Name}} populated with sample values for every field.
+func sample{{.Name}}() {{$.PackageName}}.{{.Name}} {
+	return {{$.PackageName}}.{{.Name}}{
+		{{range .Fields}}{{.Name}}: {{.Value}},
+		{{end}}
+	}
+}
+
+// TestRoundTrip_{{.Name}} writes a sample {{.Name}} and checks that reading it
+// back yields an identical struct.
+func TestRoundTrip_{{.Name}}(t *testing.T) {
+	original := sample{{.Name}}()
+
+	var buf bytes.Buffer
+	if err := original.Write(&buf, nil); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	written := buf.Len()
+
+	var decoded {{$.PackageName}}.{{.Name}}
+	if err := decoded.Read(&buf, nil); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Read consumed %d of %d written bytes", written-buf.Len(), written)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("round trip mismatch.\nOriginal: %+v\nRead:     %+v", original, decoded)
+	}
+}
+{{end}}
+{{if .FileParts}}
+// sample{{.FileTypeName}} returns a {{.FileTypeName}} whose layout structs are populated with sample values.
+func sample{{.FileTypeName}}() {{.PackageName}}.{{.FileTypeName}} {
+	return {{.PackageName}}.{{.FileTypeName}}{
+		{{range .FileParts}}{{.Name}}: {{.Value}},
+		{{end}}
+	}
+}
+
+// TestRoundTrip_{{.FileTypeName}} writes a complete file through WriteFile and checks
+// that ReadFile reads every layout struct back unchanged.
+func TestRoundTrip_{{.FileTypeName}}(t *testing.T) {
+	original := sample{{.FileTypeName}}()
+
+	var buf bytes.Buffer
+	if err := original.WriteFile(&buf); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	written := buf.Len()
+
+	var decoded {{.PackageName}}.{{.FileTypeName}}
+	if err := decoded.ReadFile(&buf); err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("ReadFile consumed %d of %d written bytes", written-buf.Len(), written)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("round trip mismatch.\nOriginal: %+v\nRead:     %+v", original, decoded)
+	}
+}
+{{end}}
+{{range .BitFieldTests}}
+// TestBitFields_{{.Struct}} sets every bit field of {{.Struct}} to its largest value and
+// back to zero, checking the getters and that the setters leave other bits alone.
+func TestBitFields_{{.Struct}}(t *testing.T) {
+	var s {{$.PackageName}}.{{.Struct}}
+	{{- range .Fields}}
+	{{- range .Accessors}}
+	s.Set{{.Name}}({{.Max}})
+	if got := s.{{.Name}}(); got != {{.Max}} {
+		t.Errorf("{{.Name}}() = %v after Set{{.Name}}({{.Max}})", got)
+	}
+	{{- end}}
+	if s.{{.Field}} != {{.Packed}} {
+		t.Errorf("{{.Field}} = %#x with every bit field set, want {{.Packed}}", uint64(s.{{.Field}}))
+	}
+	{{- range .Accessors}}
+	s.Set{{.Name}}({{.Zero}})
+	{{- end}}
+	if s.{{.Field}} != 0 {
+		t.Errorf("{{.Field}} = %#x with every bit field cleared, want 0", uint64(s.{{.Field}}))
+	}
+	{{- end}}
+}
+{{end}}
+{{range .RoundTripStructs}}
+// BenchmarkRead_{{.Name}} measures decoding a sample {{.Name}}.
+func BenchmarkRead_{{.Name}}(b *testing.B) {
+	original := sample{{.Name}}()
+	var buf bytes.Buffer
+	if err := original.Write(&buf, nil); err != nil {
+		b.Fatalf("Write failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		var decoded {{$.PackageName}}.{{.Name}}
+		if err := decoded.Read(bytes.NewReader(data), nil); err != nil {
+			b.Fatalf("Read failed: %v", err)
+		}
+	}
+}
+{{end}}
+{{if .FileParts}}
+// BenchmarkReadFile measures decoding a complete sample file with ReadFile.
+func BenchmarkReadFile(b *testing.B) {
+	original := sample{{.FileTypeName}}()
+	var buf bytes.Buffer
+	if err := original.WriteFile(&buf); err != nil {
+		b.Fatalf("WriteFile failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		var decoded {{.PackageName}}.{{.FileTypeName}}
+		if err := decoded.ReadFile(bytes.NewReader(data)); err != nil {
+			b.Fatalf("ReadFile failed: %v", err)
+		}
+	}
+}
+{{end}}
+{{range .ExpressionBenchmarks}}
+// BenchmarkExpression_{{.Name}} compares parsing the {{.Kind}} expression of
+// {{.Struct}}.{{.Field}} on every evaluation with evaluating it precompiled, as
+// the generated code does.
+func BenchmarkExpression_{{.Name}}(b *testing.B) {
+	expr := ` + "`{{.Expr}}`" + `
+	params := utils.ExpressionParameters{"s": {{.S}}, "ctx": {{.Ctx}}}
+
+	b.Run("Parsed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			expression, err := utils.CompileExpression(expr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := expression.Eval(params); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Precompiled", func(b *testing.B) {
+		expression := utils.MustCompileExpression(expr)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := expression.Eval(params); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+{{end}}
 `
 
 // --- Test Template Data Struct ---
@@ -165,7 +329,69 @@ type TestTemplateData struct {
 	PackageName     string
 	FormatDir       string // e.g., "formats"
 	FirstStructName string
+	CtxType         string // Go type of the ctx parameter of FirstStructName's Read and Write
+	// FirstStructRequired holds the values of the fields of FirstStructName
+	// that its test must set, since Read rejects their zero value.
+	FirstStructRequired []TestFieldData
 	GoModulePath    string // The Go module path (e.g., "github.com/yourname/project")
+	// RoundTripStructs lists the structs whose fields can all be filled with
+	// sample values, so a Write -> Read round-trip test can be generated for them.
+	RoundTripStructs []TestStructData
+	// FileParts holds sample values for every layout struct, used for a
+	// WriteFile -> ReadFile round-trip test. Nil if there is no layout or a
+	// part cannot be filled with sample values.
+	FileParts    []TestFieldData
+	FileTypeName string
+	// ExpressionBenchmarks lists the length/count expressions that get a
+	// parsed-vs-precompiled benchmark.
+	ExpressionBenchmarks []ExpressionBenchmarkData
+	// BitFieldTests lists the structs with bit fields, which get a test of
+	// their accessors.
+	BitFieldTests []BitFieldTestData
+}
+
+// BitFieldTestData describes the bit fields of one struct.
+type BitFieldTestData struct {
+	Struct string
+	Fields []BitFieldTestField
+}
+
+// BitFieldTestField describes the bit fields packed into one field.
+type BitFieldTestField struct {
+	Field     string
+	Packed    string // Go literal of the field with every bit field set to its largest value
+	Accessors []BitFieldTestAccessor
+}
+
+// BitFieldTestAccessor holds the Go literals of the largest and zero values of a bit field.
+type BitFieldTestAccessor struct {
+	Name string
+	Max  string
+	Zero string
+}
+
+// TestStructData describes a struct that gets a generated round-trip test.
+type TestStructData struct {
+	Name   string
+	Fields []TestFieldData
+}
+
+// ExpressionBenchmarkData describes a benchmark of one length/count expression.
+type ExpressionBenchmarkData struct {
+	Name   string // Benchmark name suffix, e.g. "Str_DynLength"
+	Struct string
+	Field  string
+	Kind   string // "length" or "count"
+	Expr   string
+	S      string // Go expression for the "s" parameter
+	Ctx    string // Go expression for the "ctx" parameter
+}
+
+// TestFieldData holds a field name and the Go literal used as its sample value.
+type TestFieldData struct {
+	Name    string
+	Value   string
+	Comment string // Why the value is set, if it is required
 }
 
 
@@ -7,3 +7,5 @@ require gopkg.in/yaml.v2 v2.4.0
 require github.com/knetic/govaluate v3.0.0+incompatible
 
 require github.com/mitchellh/mapstructure v1.5.0
+
+require gopkg.in/yaml.v3 v3.0.1
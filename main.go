@@ -2,14 +2,13 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"flag"
-	"fmt"
 	"log"
 	"os"
-	"strings"
 	
-	"FIG/generator"
+	"FIG/config"
+	"FIG/dialogue"
 
 )
 
@@ -19,14 +18,65 @@ const (
 	configFileName = "config/formats.json"
 )
 
+// Exit codes, so that scripts and CI can tell failures apart.
+const (
+	exitFailure = 1 // At least one format failed to bootstrap or generate
+	exitUsage   = 2 // Invalid flags or unknown format names
+)
+
 
 // --- Main Function ---
 func main() {
+	// Subcommands (fig validate, fig generate, ...) have their own flags
+	flag.Usage = printUsage
+	if len(os.Args) > 1 {
+		if os.Args[1] == "help" {
+			printUsage()
+			os.Exit(0)
+		}
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			os.Exit(runCommand(cmd, os.Args[2:]))
+		}
+	}
+
 	// Define flags
 	bootstrap := flag.Bool("bootstrap", false, "Enable bootstrapping mode to select, validate, and configure formats from the 'sources' directory")
 	configPath := flag.String("config", "", "Path to the formats configuration JSON file (default: formats.json)")
+	formats := flag.String("formats", "", "Comma-separated list of formats to process (e.g., bmp,jpg), skipping the selection prompt")
+	all := flag.Bool("all", false, "Process every format, skipping the selection prompt")
+	yes := flag.Bool("yes", false, "Answer yes to every y/N prompt (continue after bootstrap, generate test scripts)")
+	tests := flag.Bool("tests", false, "Generate test scripts without asking")
+	noPrompt := flag.Bool("no-prompt", false, "Never read from stdin; unanswered y/N prompts default to no (use with -formats or -all)")
+	strict := flag.Bool("strict", true, strictUsage)
+	dryRun := flag.Bool("dry-run", false, dryRunUsage)
+	diff := flag.Bool("diff", false, diffUsage)
 
 	flag.Parse()
+	if flag.NArg() > 0 {
+		log.Printf("ERROR: unknown command '%s'.", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	opts := config.RunOptions{
+		Formats:  config.ParseFormatList(*formats),
+		All:      *all,
+		Yes:      *yes,
+		Tests:    *tests,
+		NoPrompt: *noPrompt,
+		// Validation rejects unknown YAML keys unless -strict=false
+		AllowUnknownKeys: !*strict,
+		DryRun:           *dryRun,
+		Diff:             *diff,
+	}
+	if opts.All && len(opts.Formats) > 0 {
+		log.Println("ERROR: -all and -formats cannot be used together.")
+		os.Exit(exitUsage)
+	}
+	if *bootstrap && (opts.DryRun || opts.Diff) {
+		log.Println("ERROR: -dry-run and -diff only preview generation and cannot be used with -bootstrap.")
+		os.Exit(exitUsage)
+	}
 
 	// Determine config path
 	actualConfigPath := *configPath
@@ -38,16 +88,15 @@ func main() {
 	// --- Bootstrap Logic ---
 	if *bootstrap {
 		log.Println("--- Running Bootstrap ---")
-		err := RunBootstrap(actualConfigPath)
+		err := RunBootstrap(actualConfigPath, opts)
 		if err != nil {
-			log.Fatalf("Bootstrapping failed: %v", err)
+			log.Printf("Bootstrapping failed: %v", err)
+			os.Exit(exitCode(err))
 		}
 		log.Println("--- Bootstrap Complete ---")
 		// Optionally ask to continue with generation or exit
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("Bootstrap finished. Continue with code generation for configured formats? (y/N): ")
-		response, _ := reader.ReadString('\n')
-		if !strings.EqualFold(strings.TrimSpace(response), "y") {
+		question := "Bootstrap finished. Continue with code generation for configured formats?"
+		if !opts.Yes && (opts.NoPrompt || !dialogue.Confirm(question)) {
 			log.Println("Exiting after bootstrap.")
 			os.Exit(0)
 		}
@@ -55,7 +104,17 @@ func main() {
 
 	// --- Normal Generation Logic ---
 	log.Println("--- Running Code Generation ---")
-	generator.RunGeneration(actualConfigPath)
+	if err := RunGeneration(actualConfigPath, opts); err != nil {
+		log.Printf("Code generation failed: %v", err)
+		os.Exit(exitCode(err))
+	}
 	log.Println("--- Code Generation Complete ---")
 }
 
+// exitCode maps an error from bootstrap or generation to the process exit code.
+func exitCode(err error) int {
+	if errors.Is(err, config.ErrUnknownFormat) || errors.Is(err, config.ErrNoSelection) {
+		return exitUsage
+	}
+	return exitFailure
+}
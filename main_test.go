@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"FIG/config"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("format selection failed: %w", config.ErrUnknownFormat), exitUsage},
+		{fmt.Errorf("format selection failed: %w", config.ErrNoSelection), exitUsage},
+		{errors.New("1 of 2 format(s) failed: JPEG"), exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"fmt"
 	"io/ioutil"
+	"os"
 	
 	"FIG/config"
 	"FIG/utils"
@@ -14,7 +15,11 @@ import (
 )
 
 // --- Bootstrap Function ---
-func RunBootstrap(configPath string) error {
+
+// RunBootstrap validates the source YAML files selected by opts and records
+// them in the configuration. It returns an error if any selected file fails
+// validation; the configuration is still updated for the others.
+func RunBootstrap(configPath string, opts config.RunOptions) error {
 	log.Printf("Scanning '%s' directory for source YAML files...", sourceDir)
 	files, err := ioutil.ReadDir(sourceDir)
 	if err != nil {
@@ -23,7 +28,7 @@ func RunBootstrap(configPath string) error {
 
 	var yamlFiles []string
 	for _, file := range files {
-		if !file.IsDir() && (strings.HasSuffix(file.Name(), ".yml") || strings.HasSuffix(file.Name(), ".yaml")) {
+		if !file.IsDir() && isYAMLFile(file.Name()) {
 			yamlFiles = append(yamlFiles, filepath.Join(sourceDir, file.Name()))
 		}
 	}
@@ -31,11 +36,14 @@ func RunBootstrap(configPath string) error {
 
 	if len(yamlFiles) == 0 {
 		log.Printf("No YAML files found in '%s'. Nothing to bootstrap.", sourceDir)
+		if len(opts.Formats) > 0 {
+			return fmt.Errorf("%w: no YAML files found in '%s'", config.ErrUnknownFormat, sourceDir)
+		}
 		return nil
 	}
 
 	// --- Format Selection based on YAML files ---
-	selectedYamlFiles, err := dialogue.ShowSourceFileSelection(yamlFiles)
+	selectedYamlFiles, err := selectSourceFiles(yamlFiles, opts)
 	if err != nil {
 		return fmt.Errorf("format selection failed: %w", err)
 	}
@@ -59,6 +67,7 @@ func RunBootstrap(configPath string) error {
 
 	// --- Process selected files ---
 	configUpdated := false
+	var failed []string
 	for _, yamlFile := range selectedYamlFiles {
 		log.Printf("--- Bootstrapping from: %s ---", yamlFile)
 
@@ -69,9 +78,10 @@ func RunBootstrap(configPath string) error {
 		formatName := strings.Title(packageName)
 
 		// Validate and Reform YAML using the function from validator.go
-		_, err := utils.ValidateAndReformYAML(yamlFile, outputDir)
+		_, err := utils.ValidateAndReformYAML(yamlFile, outputDir, utils.ValidationOptions{AllowUnknownKeys: opts.AllowUnknownKeys})
 		if err != nil {
 			log.Printf("ERROR: Failed to validate/reform %s: %v. Skipping configuration update.", yamlFile, err)
+			failed = append(failed, yamlFile)
 			continue // Skip this file if validation fails
 		}
 
@@ -106,9 +116,61 @@ func RunBootstrap(configPath string) error {
 		log.Println("No configuration changes needed.")
 	}
 
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d source file(s) failed validation: %s", len(failed), len(selectedYamlFiles), strings.Join(failed, ", "))
+	}
 	return nil
 }
 
 
 // --- Helper Functions ---
 
+// selectSourceFiles picks the source YAML files to bootstrap from the
+// command-line options, falling back to the interactive dialogue when none
+// were given. Format names match the file name without extension, ignoring
+// case; a path to a YAML file (e.g., "sources/bmp.yml") is used as is.
+func selectSourceFiles(yamlFiles []string, opts config.RunOptions) ([]string, error) {
+	switch {
+	case opts.All:
+		return yamlFiles, nil
+	case opts.NoPrompt && len(opts.Formats) == 0:
+		return nil, fmt.Errorf("%w; use -formats or -all with -no-prompt", config.ErrNoSelection)
+	case len(opts.Formats) == 0:
+		return dialogue.ShowSourceFileSelection(yamlFiles)
+	}
+
+	var selected []string
+	seen := make(map[string]bool)
+	for _, name := range opts.Formats {
+		found := false
+		for _, yamlFile := range yamlFiles {
+			baseName := strings.TrimSuffix(filepath.Base(yamlFile), filepath.Ext(yamlFile))
+			if strings.EqualFold(baseName, name) || filepath.Clean(yamlFile) == filepath.Clean(name) {
+				if !seen[yamlFile] {
+					selected = append(selected, yamlFile)
+					seen[yamlFile] = true
+				}
+				found = true
+				break
+			}
+		}
+		if !found && isYAMLFile(name) {
+			if _, err := os.Stat(name); err == nil {
+				if !seen[name] {
+					selected = append(selected, name)
+					seen[name] = true
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w '%s': no source file '%s.yml' in '%s'", config.ErrUnknownFormat, name, name, sourceDir)
+		}
+	}
+	return selected, nil
+}
+
+// isYAMLFile reports whether name has a .yml or .yaml extension.
+func isYAMLFile(name string) bool {
+	return strings.HasSuffix(name, ".yml") || strings.HasSuffix(name, ".yaml")
+}
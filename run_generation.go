@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"FIG/config"
+	"FIG/dialogue"
+	"FIG/fig"
+)
+
+// --- Generation Function ---
+
+// RunGeneration generates code for the configured formats selected by opts,
+// asking for the formats and test scripts the options leave open. It returns
+// an error if the configuration cannot be used or if any selected format
+// fails; the remaining formats are still processed.
+func RunGeneration(configPath string, opts config.RunOptions) error {
+	// --- Load Config ---
+	formatConfigs, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if len(formatConfigs) == 0 {
+		log.Println("No formats configured in", configPath, ". Nothing to generate.")
+		log.Println("Hint: Run with -bootstrap to configure formats from the 'sources' directory.")
+		if len(opts.Formats) > 0 {
+			return fmt.Errorf("%w: no formats configured in %s", config.ErrUnknownFormat, configPath)
+		}
+		return nil
+	}
+
+	// --- Format Selection based on Config ---
+	selectedConfigs, err := selectConfigs(formatConfigs, opts)
+	if err != nil {
+		return fmt.Errorf("format selection failed: %w", err)
+	}
+	if len(selectedConfigs) == 0 {
+		log.Println("No formats selected for generation.")
+		return nil
+	}
+
+	// --- Generate (asks for test scripts unless -tests/-yes/-no-prompt decide) ---
+	figOpts := fig.Options{
+		Tests:            opts.Tests || opts.Yes,
+		AllowUnknownKeys: opts.AllowUnknownKeys,
+		Logger:           log.Default(),
+	}
+	if opts.DryRun || opts.Diff {
+		// Previews never prompt: test scripts are previewed with -tests/-yes only
+		return previewGeneration(fig.New(figOpts), selectedConfigs, opts.Diff)
+	}
+	if !opts.NoPrompt {
+		figOpts.ConfirmTests = func(format string) bool {
+			return dialogue.Confirm(fmt.Sprintf("Generate basic test script for %s?", format))
+		}
+	}
+	g := fig.New(figOpts)
+	if _, err := g.Generate(selectedConfigs...); err != nil {
+		return err
+	}
+	log.Println("Selected format(s) processed.")
+	return nil
+}
+
+// previewGeneration prints the files generating the formats would create,
+// change, delete or keep, or the unified diffs of the changes with showDiff, to
+// stdout without writing anything.
+func previewGeneration(g *fig.Generator, formats []config.FormatConfig, showDiff bool) error {
+	changes, _, err := g.Preview(formats...)
+	counts := make(map[fig.ChangeKind]int)
+	for _, change := range changes {
+		counts[change.Kind]++
+		switch {
+		case change.Kind == fig.Unchanged:
+		case showDiff && change.Kind == fig.Kept:
+			log.Printf("Info: %s would be kept: it was written or modified by hand.", change.Path)
+		case showDiff:
+			fmt.Print(change.Diff())
+		default:
+			fmt.Printf("%-9s %s\n", change.Kind, change.Path)
+		}
+	}
+	log.Printf("Dry run: %d file(s) would be created, %d changed and %d deleted; %d unchanged and %d kept. Nothing was written.",
+		counts[fig.Created], counts[fig.Changed], counts[fig.Deleted], counts[fig.Unchanged], counts[fig.Kept])
+	return err
+}
+
+// selectConfigs picks the formats to generate from the command-line options,
+// falling back to the interactive dialogue when none were given.
+func selectConfigs(formatConfigs []config.FormatConfig, opts config.RunOptions) ([]config.FormatConfig, error) {
+	switch {
+	case opts.All:
+		return formatConfigs, nil
+	case len(opts.Formats) > 0:
+		return config.SelectFormats(formatConfigs, opts.Formats)
+	case opts.NoPrompt:
+		return nil, fmt.Errorf("%w; use -formats or -all with -no-prompt", config.ErrNoSelection)
+	}
+	return dialogue.ShowConfigSelection(formatConfigs)
+}
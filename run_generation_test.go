@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"FIG/config"
+)
+
+func TestRunGeneration(t *testing.T) {
+	dir := t.TempDir()
+	configs := []config.FormatConfig{
+		{Name: "Good", YAMLFile: filepath.Join(dir, "sources", "good.yml"), OutputDir: filepath.Join(dir, "formats", "good"), PackageName: "good"},
+		{Name: "Bad", YAMLFile: filepath.Join(dir, "sources", "bad.yml"), OutputDir: filepath.Join(dir, "formats", "bad"), PackageName: "bad"},
+	}
+	writeTestFile(t, configs[0].YAMLFile, "structs:\n  Header:\n    fields:\n      - {name: Size, type: uint32}\n")
+	writeTestFile(t, configs[1].YAMLFile, "structs:\n  Header:\n    fields:\n      - {name: Size, type: uint128}\n")
+	configData, err := json.Marshal(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "formats.json")
+	writeTestFile(t, configPath, string(configData))
+
+	logger := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logger)
+	tests := []struct {
+		name        string
+		opts        config.RunOptions
+		wantErr     error  // Wrapped by the returned error
+		wantFail    string // Substring of the returned error
+		wantTests   bool   // Whether good_test.go is generated
+		wantNothing bool   // Whether nothing is written
+	}{
+		{name: "unknown format", opts: config.RunOptions{Formats: []string{"png"}}, wantErr: config.ErrUnknownFormat},
+		{name: "no selection without prompting", opts: config.RunOptions{NoPrompt: true}, wantErr: config.ErrNoSelection},
+		{name: "selected format", opts: config.RunOptions{Formats: []string{"good"}, NoPrompt: true}},
+		{name: "selected format with tests", opts: config.RunOptions{Formats: []string{"Good"}, Tests: true}, wantTests: true},
+		{name: "all formats", opts: config.RunOptions{All: true, NoPrompt: true}, wantFail: "1 of 2 format(s) failed: Bad"},
+		{name: "dry run", opts: config.RunOptions{Formats: []string{"good"}, NoPrompt: true, DryRun: true}, wantNothing: true},
+		{name: "diff", opts: config.RunOptions{Formats: []string{"good"}, NoPrompt: true, Diff: true}, wantNothing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(filepath.Join(dir, "formats"))
+			err := RunGeneration(configPath, tt.opts)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("RunGeneration() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.wantFail != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantFail) {
+					t.Fatalf("RunGeneration() error = %v, want %q", err, tt.wantFail)
+				}
+			case err != nil:
+				t.Fatalf("RunGeneration() error = %v", err)
+			}
+			if tt.wantNothing {
+				if _, err := os.Stat(filepath.Join(dir, "formats")); !os.IsNotExist(err) {
+					t.Errorf("RunGeneration() wrote files: %v", err)
+				}
+				return
+			}
+			code, err := ioutil.ReadFile(filepath.Join(configs[0].OutputDir, "Header.go"))
+			if err != nil || !strings.Contains(string(code), "type Header struct") {
+				t.Errorf("Header.go = %s, %v, want the Header type", code, err)
+			}
+			_, err = os.Stat(filepath.Join(configs[0].OutputDir, "good_test.go"))
+			if generated := err == nil; generated != tt.wantTests {
+				t.Errorf("test script generated: %v, want %v", generated, tt.wantTests)
+			}
+		})
+	}
+}
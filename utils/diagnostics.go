@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severity is the importance of a Diagnostic.
+type Severity string
+
+// Severities of diagnostics. Only errors make validation fail.
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info" // A note, such as a value that was reformed
+)
+
+// Diagnostic is a problem (or a reformation) found while validating a source
+// YAML, with its position in the source when it is known.
+type Diagnostic struct {
+	Severity  Severity `json:"severity"`
+	Struct    string   `json:"struct,omitempty"`
+	Field     string   `json:"field,omitempty"`
+	Attribute string   `json:"attribute,omitempty"` // YAML key the diagnostic is about (e.g., "length")
+	File      string   `json:"file"`
+	Line      int      `json:"line,omitempty"` // 1-based; 0 if unknown
+	Column    int      `json:"column,omitempty"`
+	Message   string   `json:"message"`
+}
+
+// Location returns "file:line:column", leaving out the parts that are unknown.
+func (d Diagnostic) Location() string {
+	switch {
+	case d.Line == 0:
+		return d.File
+	case d.Column == 0:
+		return fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+}
+
+// String formats the diagnostic like a compiler message, e.g.
+// "sources/bmp.yml:42:9: error: ...".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Location(), d.Severity, d.Message)
+}
+
+// logPrefix is the prefix validation has always logged messages of each severity with.
+var logPrefix = map[Severity]string{
+	SeverityError:   "ERROR",
+	SeverityWarning: "Warning",
+	SeverityInfo:    "Info",
+}
+
+// validation collects the diagnostics of one validation run. Every diagnostic
+// is logged as soon as it is reported, like the validator always did.
+type validation struct {
+	logger      *log.Logger
+	locator     *sourceLocator
+	diagnostics []Diagnostic
+}
+
+// place identifies what a diagnostic is about: a struct, field and attribute,
+// and the path of the YAML node to point at (see sourceLocator.position). An
+// explicit line, when known, takes precedence over the path.
+type place struct {
+	Struct, Field, Attribute string
+	path                     []interface{}
+	line, column             int
+}
+
+// fieldAt is the attribute of a field, or the field itself if attribute is "".
+func fieldAt(structName, fieldName, attribute string) place {
+	path := []interface{}{"structs", structName, "fields", named(fieldName)}
+	if attribute != "" {
+		path = append(path, attribute)
+	}
+	return place{Struct: structName, Field: fieldName, Attribute: attribute, path: path}
+}
+
+// structAt is the attribute of a struct, or the struct itself if attribute is "".
+func structAt(structName, attribute string) place {
+	path := []interface{}{"structs", structName}
+	if attribute != "" {
+		path = append(path, attribute)
+	}
+	return place{Struct: structName, Attribute: attribute, path: path}
+}
+
+// keyAt is the node at a path from the root, such as the format-level
+// "endian" or ("enums", "Compression", "values", named("RGB")). The first key
+// is the attribute.
+func keyAt(path ...interface{}) place {
+	at := place{path: path}
+	if len(path) > 0 {
+		at.Attribute, _ = path[0].(string)
+	}
+	return at
+}
+
+// child is the node at steps below p, such as a named element of a list attribute.
+func (p place) child(steps ...interface{}) place {
+	p.path = append(append([]interface{}(nil), p.path...), steps...)
+	return p
+}
+
+// report records and logs a diagnostic.
+func (v *validation) report(severity Severity, at place, format string, args ...interface{}) {
+	d := Diagnostic{
+		Severity:  severity,
+		Struct:    at.Struct,
+		Field:     at.Field,
+		Attribute: at.Attribute,
+		File:      v.locator.path,
+		Line:      at.line,
+		Column:    at.column,
+		Message:   fmt.Sprintf(format, args...),
+	}
+	if d.Line == 0 {
+		d.Line, d.Column = v.locator.position(at.path...)
+	}
+	v.diagnostics = append(v.diagnostics, d)
+	v.logger.Printf("%s: %s: %s", logPrefix[severity], d.Location(), d.Message)
+}
+
+// errorf reports an error.
+func (v *validation) errorf(at place, format string, args ...interface{}) {
+	v.report(SeverityError, at, format, args...)
+}
+
+// warnf reports a warning.
+func (v *validation) warnf(at place, format string, args ...interface{}) {
+	v.report(SeverityWarning, at, format, args...)
+}
+
+// infof reports a reformation.
+func (v *validation) infof(at place, format string, args ...interface{}) {
+	v.report(SeverityInfo, at, format, args...)
+}
+
+// yamlErrorLine matches the line number in YAML parser errors
+// (e.g., "yaml: line 3: mapping values are not allowed in this context").
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// syntaxError reports an error of the YAML parser at the line it mentions.
+func (v *validation) syntaxError(err error) {
+	at := place{}
+	if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		at.line, _ = strconv.Atoi(match[1])
+	}
+	v.errorf(at, "%v", err)
+}
+
+// Output formats of WriteDiagnostics.
+const (
+	DiagnosticsText  = "text"
+	DiagnosticsJSON  = "json"
+	DiagnosticsSARIF = "sarif"
+)
+
+// WriteDiagnostics writes diagnostics in the given format: text (one
+// Diagnostic.String per line), json (an array of Diagnostic) or sarif (a
+// SARIF 2.1.0 log, for code scanning tools and CI annotations).
+func WriteDiagnostics(w io.Writer, format string, diagnostics []Diagnostic) error {
+	switch strings.ToLower(format) {
+	case DiagnosticsText:
+		for _, d := range diagnostics {
+			if _, err := fmt.Fprintln(w, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	case DiagnosticsJSON:
+		if diagnostics == nil {
+			diagnostics = []Diagnostic{} // An empty array rather than null
+		}
+		return writeJSON(w, diagnostics)
+	case DiagnosticsSARIF:
+		return writeJSON(w, sarifLog(diagnostics))
+	}
+	return fmt.Errorf("unknown diagnostics format '%s' (use %s, %s or %s)", format, DiagnosticsText, DiagnosticsJSON, DiagnosticsSARIF)
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// sarifLevel maps severities to SARIF result levels.
+var sarifLevel = map[Severity]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityInfo:    "note",
+}
+
+// sarifLog builds a SARIF 2.1.0 log with one run holding a result per diagnostic.
+func sarifLog(diagnostics []Diagnostic) map[string]interface{} {
+	results := make([]map[string]interface{}, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		physical := map[string]interface{}{
+			"artifactLocation": map[string]interface{}{"uri": d.File},
+		}
+		if d.Line > 0 {
+			region := map[string]interface{}{"startLine": d.Line}
+			if d.Column > 0 {
+				region["startColumn"] = d.Column
+			}
+			physical["region"] = region
+		}
+		result := map[string]interface{}{
+			"level":     sarifLevel[d.Severity],
+			"message":   map[string]interface{}{"text": d.Message},
+			"locations": []interface{}{map[string]interface{}{"physicalLocation": physical}},
+		}
+		if properties := sarifProperties(d); len(properties) > 0 {
+			result["properties"] = properties
+		}
+		results = append(results, result)
+	}
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{map[string]interface{}{
+			"tool":    map[string]interface{}{"driver": map[string]interface{}{"name": "FIG"}},
+			"results": results,
+		}},
+	}
+}
+
+// sarifProperties returns the struct, field and attribute of a diagnostic as
+// SARIF result properties.
+func sarifProperties(d Diagnostic) map[string]string {
+	properties := make(map[string]string)
+	for key, value := range map[string]string{"struct": d.Struct, "field": d.Field, "attribute": d.Attribute} {
+		if value != "" {
+			properties[key] = value
+		}
+	}
+	return properties
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticLocation(t *testing.T) {
+	tests := []struct {
+		d    Diagnostic
+		want string
+	}{
+		{Diagnostic{File: "a.yml"}, "a.yml"},
+		{Diagnostic{File: "a.yml", Line: 3}, "a.yml:3"},
+		{Diagnostic{File: "a.yml", Line: 3, Column: 9}, "a.yml:3:9"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.Location(); got != tt.want {
+			t.Errorf("%#v.Location() = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestValidateYAMLDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "test.yml")
+	source := "endian: BE\nstructs:\n  A:\n    fields:\n      - {name: Num, type: uint8}\n      - {name: X, type: uint8, lenght: 2}\n"
+	if err := ioutil.WriteFile(sourcePath, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logger := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logger)
+	_, diagnostics, err := ValidateYAML(sourcePath, ValidationOptions{})
+	if err == nil {
+		t.Fatal("ValidateYAML() error = nil, want the unknown key to fail validation")
+	}
+	want := []Diagnostic{
+		{Severity: SeverityError, Attribute: "lenght", File: sourcePath, Line: 6, Column: 32},
+		{Severity: SeverityInfo, Attribute: "endian", File: sourcePath, Line: 1, Column: 1},
+	}
+	if len(diagnostics) != len(want) {
+		t.Fatalf("ValidateYAML() diagnostics = %v, want %d", diagnostics, len(want))
+	}
+	for i, d := range diagnostics {
+		d.Message = ""
+		if d != want[i] {
+			t.Errorf("diagnostic %d = %#v, want %#v", i, d, want[i])
+		}
+	}
+}
+
+func TestValidateYAMLSyntaxError(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "test.yml")
+	if err := ioutil.WriteFile(sourcePath, []byte("structs:\n  A:\n    fields: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logger := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logger)
+	_, diagnostics, err := ValidateYAML(sourcePath, ValidationOptions{})
+	if err == nil || len(diagnostics) != 1 || diagnostics[0].Severity != SeverityError || diagnostics[0].Line == 0 {
+		t.Errorf("ValidateYAML() = %v, %v, want one error diagnostic with a line", diagnostics, err)
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Severity: SeverityError, Struct: "A", Field: "X", Attribute: "length", File: "a.yml", Line: 5, Column: 35, Message: "bad length"},
+		{Severity: SeverityInfo, File: "a.yml", Message: "reformed"},
+	}
+
+	var text bytes.Buffer
+	if err := WriteDiagnostics(&text, DiagnosticsText, diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a.yml:5:35: error: bad length\na.yml: info: reformed\n"; text.String() != want {
+		t.Errorf("text = %q, want %q", text.String(), want)
+	}
+
+	var jsonOut bytes.Buffer
+	if err := WriteDiagnostics(&jsonOut, "JSON", diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Diagnostic
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil || len(decoded) != 2 || decoded[0] != diagnostics[0] {
+		t.Errorf("json = %s (%v), want the diagnostics", jsonOut.String(), err)
+	}
+	jsonOut.Reset()
+	if err := WriteDiagnostics(&jsonOut, DiagnosticsJSON, nil); err != nil || strings.TrimSpace(jsonOut.String()) != "[]" {
+		t.Errorf("json of no diagnostics = %q, %v, want []", jsonOut.String(), err)
+	}
+
+	var sarif bytes.Buffer
+	if err := WriteDiagnostics(&sarif, DiagnosticsSARIF, diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						Region *struct{ StartLine, StartColumn int }
+					}
+				}
+				Properties map[string]string
+			}
+		}
+	}
+	if err := json.Unmarshal(sarif.Bytes(), &log); err != nil || log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 {
+		t.Fatalf("sarif = %s (%v)", sarif.String(), err)
+	}
+	first, second := log.Runs[0].Results[0], log.Runs[0].Results[1]
+	if region := first.Locations[0].PhysicalLocation.Region; first.Level != "error" || region == nil || region.StartLine != 5 || region.StartColumn != 35 || first.Properties["field"] != "X" {
+		t.Errorf("first SARIF result = %+v", first)
+	}
+	if second.Level != "note" || second.Locations[0].PhysicalLocation.Region != nil || second.Properties != nil {
+		t.Errorf("second SARIF result = %+v", second)
+	}
+
+	if err := WriteDiagnostics(io.Discard, "xml", diagnostics); err == nil {
+		t.Error("WriteDiagnostics(\"xml\") error = nil")
+	}
+}
@@ -1,38 +1,32 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
+	"regexp"
 	"strings"
 
+	"FIG/app_structs"
+
 	"github.com/knetic/govaluate"
 )
 
 // GetExpressionFunctions defines functions usable in YAML Length expressions.
+// Numeric arguments may be of any Go numeric type (see numericArgs); results
+// are float64, like every number in govaluate. The integer helpers truncate
+// their arguments to integers.
 func GetExpressionFunctions() map[string]govaluate.ExpressionFunction {
 	return map[string]govaluate.ExpressionFunction{
 		// Example: BMP padding calculation
 		"CalculatePaddedSize": func(args ...interface{}) (interface{}, error) {
-			if len(args) != 3 {
-				return nil, fmt.Errorf("CalculatePaddedSize expects 3 arguments (width, height, bitsPerPixel)")
-			}
-
-			// --- Careful argument conversion (govaluate often uses float64) ---
-			var width, height, bitsPerPixel float64
-			var ok bool
-
-			width, ok = args[0].(float64)
-			if !ok {
-				return nil, fmt.Errorf("arg 1 (width) must be numeric for CalculatePaddedSize")
-			}
-			height, ok = args[1].(float64)
-			if !ok {
-				return nil, fmt.Errorf("arg 2 (height) must be numeric for CalculatePaddedSize")
-			}
-			bitsPerPixel, ok = args[2].(float64)
-			if !ok {
-				return nil, fmt.Errorf("arg 3 (bitsPerPixel) must be numeric for CalculatePaddedSize")
+			values, err := numericArgs("CalculatePaddedSize", args, 3)
+			if err != nil {
+				return nil, err
 			}
-			// --- End conversion ---
+			width, height, bitsPerPixel := values[0], values[1], values[2]
 
 			if bitsPerPixel == 0 { // Avoid division by zero
 				return nil, fmt.Errorf("bitsPerPixel cannot be zero")
@@ -50,7 +44,129 @@ func GetExpressionFunctions() map[string]govaluate.ExpressionFunction {
 
 			return float64(totalSize), nil // Return as float64 for govaluate
 		},
+		// align(n, k): n rounded up to a multiple of k
+		"align": integerFunction("align", 2, func(v []int64) (int64, error) {
+			if v[1] <= 0 {
+				return 0, fmt.Errorf("align: alignment must be positive, got %d", v[1])
+			}
+			return v[0] + padding(v[0], v[1]), nil
+		}),
+		// pad_to(n, k): the number of bytes needed to pad n to a multiple of k
+		"pad_to": integerFunction("pad_to", 2, func(v []int64) (int64, error) {
+			if v[1] <= 0 {
+				return 0, fmt.Errorf("pad_to: alignment must be positive, got %d", v[1])
+			}
+			return padding(v[0], v[1]), nil
+		}),
+		// ceil_div(a, b): a / b rounded up
+		"ceil_div": integerFunction("ceil_div", 2, func(v []int64) (int64, error) {
+			if v[1] <= 0 {
+				return 0, fmt.Errorf("ceil_div: divisor must be positive, got %d", v[1])
+			}
+			return (v[0] + padding(v[0], v[1])) / v[1], nil
+		}),
+		// bits(x, lo, hi): bits lo to hi (inclusive, 0 = least significant) of x
+		"bits": integerFunction("bits", 3, func(v []int64) (int64, error) {
+			lo, hi := v[1], v[2]
+			if lo < 0 || hi < lo || hi > 63 {
+				return 0, fmt.Errorf("bits: invalid bit range %d..%d", lo, hi)
+			}
+			return int64(uint64(v[0]) >> uint(lo) & (1<<uint(hi-lo+1) - 1)), nil
+		}),
+		// popcount(x): the number of bits set in x
+		"popcount": integerFunction("popcount", 1, func(v []int64) (int64, error) {
+			return int64(bits.OnesCount64(uint64(v[0]))), nil
+		}),
+		// min(a, b, ...) and max(a, b, ...)
+		"min": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("min", args, -2)
+			if err != nil {
+				return nil, err
+			}
+			result := values[0]
+			for _, value := range values[1:] {
+				result = math.Min(result, value)
+			}
+			return result, nil
+		},
+		"max": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("max", args, -2)
+			if err != nil {
+				return nil, err
+			}
+			result := values[0]
+			for _, value := range values[1:] {
+				result = math.Max(result, value)
+			}
+			return result, nil
+		},
+		// len(s.Field): the length of a string or slice field
+		"len": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, argumentCountError{fmt.Sprintf("len expects 1 argument(s), got %d", len(args))}
+			}
+			value := reflect.ValueOf(args[0])
+			switch value.Kind() {
+			case reflect.String, reflect.Slice, reflect.Array:
+				return float64(value.Len()), nil
+			}
+			return nil, fmt.Errorf("len expects a string or slice, got %T", args[0])
+		},
+	}
+}
+
+// numericArgs converts the arguments of the expression function name to
+// float64. govaluate passes numbers as float64, but values can reach functions
+// with their field's type (e.g., a uint32 passed by a caller, or a float32,
+// which govaluate does not convert), so any Go numeric type is accepted. A
+// negative count means at least -count arguments.
+func numericArgs(name string, args []interface{}, count int) ([]float64, error) {
+	if count >= 0 && len(args) != count {
+		return nil, argumentCountError{fmt.Sprintf("%s expects %d argument(s), got %d", name, count, len(args))}
+	}
+	if count < 0 && len(args) < -count {
+		return nil, argumentCountError{fmt.Sprintf("%s expects at least %d argument(s), got %d", name, -count, len(args))}
+	}
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		value := reflect.ValueOf(arg)
+		switch value.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			values[i] = float64(value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			values[i] = float64(value.Uint())
+		case reflect.Float32, reflect.Float64:
+			values[i] = value.Float()
+		default:
+			return nil, fmt.Errorf("arg %d of %s must be numeric, got %T", i+1, name, arg)
+		}
 	}
+	return values, nil
+}
+
+// integerFunction adapts fn, which works on count int64 arguments, to an
+// expression function.
+func integerFunction(name string, count int, fn func([]int64) (int64, error)) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		values, err := numericArgs(name, args, count)
+		if err != nil {
+			return nil, err
+		}
+		integers := make([]int64, len(values))
+		for i, value := range values {
+			integers[i] = int64(value)
+		}
+		result, err := fn(integers)
+		if err != nil {
+			return nil, err
+		}
+		return float64(result), nil
+	}
+}
+
+// padding returns the number of bytes needed to pad n to a multiple of k (k > 0).
+func padding(n, k int64) int64 {
+	return (k - n%k) % k
 }
 
 // isValidLengthExpression remains the same (used by bootstrap now, but keep accessible)
@@ -61,3 +177,323 @@ func IsValidLengthExpression(expr string) bool {
 	}
 	return true
 }
+
+// expressionReferencePattern matches s./ctx. field references such as
+// "s.Width" or "ctx.Header.Size" in a YAML expression.
+var expressionReferencePattern = regexp.MustCompile(`\b(?:s|ctx)(?:\.[A-Za-z_][A-Za-z0-9_]*)+`)
+
+// PrepareExpression rewrites s./ctx. field references into govaluate's
+// bracketed variable syntax ("s.Width" -> "[s.Width]"), since govaluate does
+// not treat '.' as part of a variable name. The rewritten expression is meant
+// to be evaluated with ExpressionParameters.
+func PrepareExpression(expr string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range expressionReferencePattern.FindAllStringIndex(expr, -1) {
+		if loc[0] > 0 && expr[loc[0]-1] == '[' { // Already bracketed
+			continue
+		}
+		out.WriteString(expr[last:loc[0]])
+		out.WriteString("[" + expr[loc[0]:loc[1]] + "]")
+		last = loc[1]
+	}
+	out.WriteString(expr[last:])
+	return out.String()
+}
+
+// ExpressionReferences returns the s./ctx. field references used in an
+// expression (e.g. ["s.Width", "ctx.Header.Size"]), in order of appearance.
+func ExpressionReferences(expr string) []string {
+	return expressionReferencePattern.FindAllString(expr, -1)
+}
+
+// CompileExpression parses a YAML length/count expression into a govaluate
+// expression with the helper functions of GetExpressionFunctions available.
+// The result is evaluated with ExpressionParameters.
+func CompileExpression(expr string) (*govaluate.EvaluableExpression, error) {
+	return govaluate.NewEvaluableExpressionWithFunctions(PrepareExpression(expr), GetExpressionFunctions())
+}
+
+// MustCompileExpression is like CompileExpression but panics if the expression
+// cannot be parsed. Generated code uses it to parse each expression once, in a
+// package-level variable, instead of on every Read/Write call.
+func MustCompileExpression(expr string) *govaluate.EvaluableExpression {
+	expression, err := CompileExpression(expr)
+	if err != nil {
+		panic(fmt.Sprintf("parsing expression '%s': %v", expr, err))
+	}
+	return expression
+}
+
+// lenArgumentPattern matches calls of the len function on a field reference,
+// such as "len(s.Data)", capturing the reference.
+var lenArgumentPattern = regexp.MustCompile(`\blen\s*\(\s*((?:s|ctx)(?:\.[A-Za-z_][A-Za-z0-9_]*)+)\s*\)`)
+
+// expressionSampleNumber is the value given to every numeric field when
+// CheckExpression evaluates an expression to find its result type.
+const expressionSampleNumber = 8.0
+
+// CheckExpression validates a length/count expression (or a condition, if
+// condition is true) of structName against the format definition: every
+// s./ctx. reference must name a numeric or string field (or a string or slice
+// field passed to len), and the result must
+// be a number (a boolean for conditions). In native expression mode it checks
+// that the expression can be translated to Go instead.
+//
+// References to ctx are only checked when ContextStruct knows its type;
+// otherwise a warning suggests declaring a context. The result type is found
+// by evaluating the expression with sample values; if that fails in a function
+// call, the failure is returned as a warning, since functions may reject the
+// sample values.
+func CheckExpression(fileFormat *app_structs.FileFormat, structName, expr string, condition bool) (warning, err error) {
+	if fileFormat.IsNativeMode() {
+		_, err = TranslateExpression(fileFormat, structName, expr, condition)
+		return nil, err
+	}
+
+	expression, err := CompileExpressionWith(expr, checkFunctions(fileFormat))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse expression '%s': %w", expr, err)
+	}
+	lenArgs := make(map[string]bool)
+	for _, match := range lenArgumentPattern.FindAllStringSubmatch(expr, -1) {
+		lenArgs[match[1]] = true
+	}
+	samples := make(map[string]interface{})
+	for _, name := range expression.Vars() {
+		root := strings.SplitN(name, ".", 2)[0]
+		if root != "s" && root != "ctx" || !strings.Contains(name, ".") {
+			return nil, fmt.Errorf("expression '%s': unknown name '%s': use 's.<Field>' or 'ctx.<Field>'", expr, name)
+		}
+		samples[name] = expressionSampleNumber
+		if lenArgs[name] {
+			samples[name] = "" // Only its length matters
+		}
+		if root == "ctx" {
+			if _, errCtx := ContextStruct(fileFormat, structName); errCtx != nil {
+				warning = fmt.Errorf("'%s' is only resolved at runtime: %v", name, errCtx)
+				continue
+			}
+		}
+		fieldType, errRef := ResolveReference(fileFormat, structName, name)
+		switch {
+		case errRef != nil:
+			return nil, fmt.Errorf("expression '%s': %w", expr, errRef)
+		case lenArgs[name]:
+			if fieldType != "string" && !strings.HasPrefix(fieldType, "[]") {
+				return nil, fmt.Errorf("expression '%s': '%s' is a %s; len needs a string or slice field", expr, name, fieldType)
+			}
+		case fieldType == "string":
+			samples[name] = ""
+		case !app_structs.IsNumericType(fieldType):
+			return nil, fmt.Errorf("expression '%s': '%s' is a %s; only numeric and string fields can be used in expressions", expr, name, fieldType)
+		}
+	}
+
+	result, errEval := expression.Evaluate(samples)
+	if errEval != nil {
+		var countErr argumentCountError
+		if errors.As(errEval, &countErr) {
+			return nil, fmt.Errorf("expression '%s': %v", expr, countErr)
+		}
+		for _, token := range expression.Tokens() {
+			if token.Kind == govaluate.FUNCTION {
+				return fmt.Errorf("expression '%s' could not be checked with sample values: %v", expr, errEval), nil
+			}
+		}
+		return nil, fmt.Errorf("expression '%s': %v", expr, errEval)
+	}
+	switch result.(type) {
+	case bool:
+		if !condition {
+			return nil, fmt.Errorf("expression '%s' must be numeric, not boolean", expr)
+		}
+	case float64:
+		if condition {
+			return nil, fmt.Errorf("condition '%s' must be a boolean expression (e.g., a comparison)", expr)
+		}
+	default:
+		return nil, fmt.Errorf("expression '%s' evaluates to a %T, not a number or boolean", expr, result)
+	}
+	return warning, nil
+}
+
+// CompileExpressionWith is like CompileExpression, with extra functions
+// (see ExpressionFunctions) available in addition to GetExpressionFunctions.
+func CompileExpressionWith(expr string, functions map[string]govaluate.ExpressionFunction) (*govaluate.EvaluableExpression, error) {
+	all := GetExpressionFunctions()
+	for name, function := range functions {
+		all[name] = function
+	}
+	return govaluate.NewEvaluableExpressionWithFunctions(PrepareExpression(expr), all)
+}
+
+// MustCompileExpressionWith is like CompileExpressionWith but panics if the
+// expression cannot be parsed. Generated code uses it for formats declaring
+// functions.
+func MustCompileExpressionWith(expr string, functions map[string]govaluate.ExpressionFunction) *govaluate.EvaluableExpression {
+	expression, err := CompileExpressionWith(expr, functions)
+	if err != nil {
+		panic(fmt.Sprintf("parsing expression '%s': %v", expr, err))
+	}
+	return expression
+}
+
+// ExpressionFunctions adapts plain Go functions, such as those declared in a
+// format's functions section, to govaluate. Each function must take numeric
+// arguments and return a single number or bool; arguments are converted from
+// any numeric type (see numericArgs) and results back to float64. A function
+// with another signature returns an error whenever it is called.
+func ExpressionFunctions(functions map[string]interface{}) map[string]govaluate.ExpressionFunction {
+	adapted := make(map[string]govaluate.ExpressionFunction, len(functions))
+	for name, function := range functions {
+		value := reflect.ValueOf(function)
+		if err := checkExpressionFunction(name, value); err != nil {
+			adapted[name] = func(args ...interface{}) (interface{}, error) { return nil, err }
+			continue
+		}
+		adapted[name] = adaptExpressionFunction(name, value)
+	}
+	return adapted
+}
+
+// checkExpressionFunction returns an error if function cannot be adapted by
+// ExpressionFunctions: it must be a non-variadic function of numbers returning
+// a single number or bool.
+func checkExpressionFunction(name string, function reflect.Value) error {
+	if function.Kind() != reflect.Func || function.IsNil() {
+		return fmt.Errorf("expression function %s is not a function", name)
+	}
+	fnType := function.Type()
+	if fnType.IsVariadic() {
+		return fmt.Errorf("expression function %s is variadic", name)
+	}
+	for i := 0; i < fnType.NumIn(); i++ {
+		if !isNumericKind(fnType.In(i).Kind()) {
+			return fmt.Errorf("arg %d of expression function %s is a %s, not a number", i+1, name, fnType.In(i))
+		}
+	}
+	if fnType.NumOut() != 1 {
+		return fmt.Errorf("expression function %s returns %d value(s), not a single number or bool", name, fnType.NumOut())
+	}
+	if out := fnType.Out(0); out.Kind() != reflect.Bool && !isNumericKind(out.Kind()) {
+		return fmt.Errorf("expression function %s returns a %s, not a number or bool", name, out)
+	}
+	return nil
+}
+
+// isNumericKind reports whether kind is an integer or floating-point kind.
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// adaptExpressionFunction wraps one function for ExpressionFunctions, which
+// checked its signature with checkExpressionFunction.
+func adaptExpressionFunction(name string, function reflect.Value) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		fnType := function.Type()
+		values, err := numericArgs(name, args, fnType.NumIn())
+		if err != nil {
+			return nil, err
+		}
+		in := make([]reflect.Value, len(values))
+		for i, value := range values {
+			in[i] = reflect.ValueOf(value).Convert(fnType.In(i))
+		}
+		result := function.Call(in)[0]
+		switch result.Kind() {
+		case reflect.Bool:
+			return result.Bool(), nil
+		case reflect.Float32, reflect.Float64:
+			return result.Float(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(result.Uint()), nil
+		}
+		return float64(result.Int()), nil
+	}
+}
+
+// argumentCountError reports a call with the wrong number of arguments.
+// CheckExpression reports it as an error even though other failures of
+// function calls are only warnings.
+type argumentCountError struct{ message string }
+
+func (e argumentCountError) Error() string { return e.message }
+
+// checkFunctions returns stand-ins for the functions declared by a format,
+// which check the number of arguments and return a sample number, so
+// CheckExpression can evaluate expressions without the Go implementations.
+func checkFunctions(fileFormat *app_structs.FileFormat) map[string]govaluate.ExpressionFunction {
+	functions := make(map[string]govaluate.ExpressionFunction, len(fileFormat.Functions))
+	for name, function := range fileFormat.Functions {
+		name, arity := name, function.Args
+		functions[name] = func(args ...interface{}) (interface{}, error) {
+			if len(args) != arity {
+				return nil, argumentCountError{fmt.Sprintf("function '%s' expects %d argument(s), got %d", name, arity, len(args))}
+			}
+			return expressionSampleNumber, nil
+		}
+	}
+	return functions
+}
+
+// ExpressionUsesContext reports whether an expression refers to ctx.
+func ExpressionUsesContext(expr string) bool {
+	for _, ref := range ExpressionReferences(expr) {
+		if strings.HasPrefix(ref, "ctx.") {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpressionParameters supplies the variables of an expression prepared with
+// PrepareExpression. Dotted names such as "s.Width" are resolved by looking up
+// the root ("s") and then walking exported struct fields, following pointers
+// and interfaces.
+type ExpressionParameters map[string]interface{}
+
+// Get implements govaluate.Parameters.
+func (p ExpressionParameters) Get(name string) (interface{}, error) {
+	parts := strings.Split(name, ".")
+	root, ok := p[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("no parameter '%s' found", parts[0])
+	}
+	value := reflect.ValueOf(root)
+	for i, fieldName := range parts[1:] {
+		for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return nil, fmt.Errorf("cannot resolve '%s': '%s' is nil", name, strings.Join(parts[:i+1], "."))
+			}
+			value = value.Elem()
+		}
+		if !value.IsValid() {
+			return nil, fmt.Errorf("cannot resolve '%s': '%s' is nil", name, strings.Join(parts[:i+1], "."))
+		}
+		if value.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("cannot resolve '%s': '%s' is a %s, not a struct", name, strings.Join(parts[:i+1], "."), value.Type())
+		}
+		value = value.FieldByName(fieldName)
+		if !value.IsValid() {
+			return nil, fmt.Errorf("cannot resolve '%s': no field '%s'", name, fieldName)
+		}
+	}
+	// govaluate only converts the predeclared integer types to numbers, so
+	// values of enum types (and float32) are converted here
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	}
+	return value.Interface(), nil
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrepareExpression(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"4", "4"},
+		{"s.Width", "[s.Width]"},
+		{"s.Width * s.Height / 8", "[s.Width] * [s.Height] / 8"},
+		{"ctx.Header.Size - 14", "[ctx.Header.Size] - 14"},
+		{"[s.Width] + 1", "[s.Width] + 1"},
+		{"max(s.A, ctx.B)", "max([s.A], [ctx.B])"},
+		{"bits.Count", "bits.Count"},
+	}
+	for _, tt := range tests {
+		if got := PrepareExpression(tt.expr); got != tt.want {
+			t.Errorf("PrepareExpression(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestExpressionParameters(t *testing.T) {
+	type header struct {
+		Size uint32
+	}
+	type kind uint16 // An enum type
+	type outer struct {
+		Header  header
+		Pointer *header
+		Count   uint8
+		Kind    kind
+		Scale   float32
+		Name    string
+	}
+	s := &outer{Header: header{Size: 54}, Count: 3, Kind: 2, Scale: 0.5, Name: "abc"}
+	params := ExpressionParameters{"s": s, "ctx": nil}
+	tests := []struct {
+		name    string
+		want    interface{}
+		wantErr string
+	}{
+		{name: "s.Count", want: float64(3)},
+		{name: "s.Header.Size", want: float64(54)},
+		{name: "s.Kind", want: float64(2)},
+		{name: "s.Scale", want: float64(0.5)},
+		{name: "s.Name", want: "abc"},
+		{name: "s", want: s},
+		{name: "s.Missing", wantErr: "no field 'Missing'"},
+		{name: "s.Pointer.Size", wantErr: "'s.Pointer' is nil"},
+		{name: "s.Count.Value", wantErr: "'s.Count' is a uint8, not a struct"},
+		{name: "ctx.Size", wantErr: "'ctx' is nil"},
+		{name: "other.Size", wantErr: "no parameter 'other'"},
+	}
+	for _, tt := range tests {
+		got, err := params.Get(tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Get(%q) = %v, %v, want an error containing %q", tt.name, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Get(%q) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpressionReferences(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    []string
+		usesCtx bool
+	}{
+		{"4", nil, false},
+		{"s.Width * s.Height", []string{"s.Width", "s.Height"}, false},
+		{"ctx.Header.Size - s.Offset", []string{"ctx.Header.Size", "s.Offset"}, true},
+		{"[ctx.Size]", []string{"ctx.Size"}, true},
+		{"context.Size", nil, false},
+	}
+	for _, tt := range tests {
+		got := ExpressionReferences(tt.expr)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("ExpressionReferences(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+		if usesCtx := ExpressionUsesContext(tt.expr); usesCtx != tt.usesCtx {
+			t.Errorf("ExpressionUsesContext(%q) = %v, want %v", tt.expr, usesCtx, tt.usesCtx)
+		}
+	}
+}
+
+func TestCompileExpression(t *testing.T) {
+	type header struct{ Width, Height uint16 }
+	expression, err := CompileExpression("s.Width * s.Height / ctx.Bits")
+	if err != nil {
+		t.Fatalf("CompileExpression() error = %v", err)
+	}
+	got, err := expression.Eval(ExpressionParameters{"s": &header{Width: 4, Height: 6}, "ctx": struct{ Bits int }{8}})
+	if err != nil || got != float64(3) {
+		t.Errorf("Eval() = %v, %v, want 3", got, err)
+	}
+
+	if _, err := CompileExpression("s.Width +"); err == nil {
+		t.Errorf("CompileExpression() of an unparsable expression succeeded")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustCompileExpression() of an unparsable expression didn't panic")
+		}
+	}()
+	MustCompileExpression("s.Width +")
+}
+
+func TestExpressionFunctions(t *testing.T) {
+	functions := ExpressionFunctions(map[string]interface{}{
+		"AlignTo": func(n, align int64) int64 { return (n + align - 1) / align * align },
+		"IsEven":  func(n uint8) bool { return n%2 == 0 },
+		"Half":    func(n float32) float32 { return n / 2 },
+	})
+	tests := []struct {
+		expr    string
+		want    interface{}
+		wantErr string
+	}{
+		{expr: "AlignTo(s.Width, 4)", want: float64(8)},
+		{expr: "IsEven(s.Width)", want: false},
+		{expr: "Half(s.Width)", want: float64(2.5)},
+		{expr: "AlignTo(s.Width)", wantErr: "AlignTo expects 2 argument(s), got 1"},
+		{expr: "IsEven('x')", wantErr: "arg 1 of IsEven must be numeric, got string"},
+	}
+	for _, tt := range tests {
+		expression, err := CompileExpressionWith(tt.expr, functions)
+		if err != nil {
+			t.Fatalf("CompileExpressionWith(%q) error = %v", tt.expr, err)
+		}
+		got, err := expression.Eval(ExpressionParameters{"s": struct{ Width uint16 }{5}})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Eval(%q) = %v, %v, want an error containing %q", tt.expr, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Eval(%q) = %v, %v, want %v", tt.expr, got, err, tt.want)
+		}
+	}
+
+	// Functions with another signature are errors when called, not panics
+	invalid := ExpressionFunctions(map[string]interface{}{
+		"NoResult": func(n int64) {},
+		"Pair":     func(n int64) (int64, int64) { return n, n },
+		"Name":     func(n int64) string { return "" },
+		"Sum":      func(n ...int64) int64 { return 0 },
+		"Text":     func(s string) int64 { return 0 },
+		"Value":    42,
+		"Nil":      (func(int64) int64)(nil),
+	})
+	wantErrs := map[string]string{
+		"NoResult": "expression function NoResult returns 0 value(s), not a single number or bool",
+		"Pair":     "expression function Pair returns 2 value(s), not a single number or bool",
+		"Name":     "expression function Name returns a string, not a number or bool",
+		"Sum":      "expression function Sum is variadic",
+		"Text":     "arg 1 of expression function Text is a string, not a number",
+		"Value":    "expression function Value is not a function",
+		"Nil":      "expression function Nil is not a function",
+	}
+	for name, wantErr := range wantErrs {
+		expression, err := CompileExpressionWith(name+"(s.Width)", invalid)
+		if err != nil {
+			t.Fatalf("CompileExpressionWith(%s) error = %v", name, err)
+		}
+		got, err := expression.Eval(ExpressionParameters{"s": struct{ Width uint16 }{5}})
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("Eval(%s) = %v, %v, want an error containing %q", name, got, err, wantErr)
+		}
+	}
+
+	// The built-in functions stay available
+	if _, err := CompileExpressionWith("CalculatePaddedSize(1, 1, 8) + AlignTo(1, 2)", functions); err != nil {
+		t.Errorf("CompileExpressionWith() with a built-in function: %v", err)
+	}
+}
+
+func TestBuiltinExpressionFunctions(t *testing.T) {
+	s := struct {
+		Size  uint32
+		Flags uint8
+		Scale float32
+		Name  string
+		Data  []byte
+	}{Size: 13, Flags: 0xb4, Scale: 1.5, Name: "abc", Data: []byte{1, 2}}
+	tests := []struct {
+		expr    string
+		want    interface{}
+		wantErr string
+	}{
+		{expr: "align(s.Size, 4)", want: float64(16)},
+		{expr: "align(16, 4)", want: float64(16)},
+		{expr: "pad_to(len(s.Name) + 1, 2)", want: float64(0)},
+		{expr: "pad_to(s.Size, 8)", want: float64(3)},
+		{expr: "ceil_div(s.Size, 4)", want: float64(4)},
+		{expr: "bits(s.Flags, 4, 7)", want: float64(0xb)},
+		{expr: "bits(s.Flags, 0, 0)", want: float64(0)},
+		{expr: "popcount(s.Flags)", want: float64(4)},
+		{expr: "min(s.Size, 4, 7)", want: float64(4)},
+		{expr: "max(s.Scale, 1)", want: float64(1.5)},
+		{expr: "len(s.Name) + len(s.Data)", want: float64(5)},
+		{expr: "CalculatePaddedSize(3, 2, 8)", want: float64(8)},
+		{expr: "align(s.Size, 0)", wantErr: "align: alignment must be positive, got 0"},
+		{expr: "ceil_div(s.Size, -1)", wantErr: "ceil_div: divisor must be positive, got -1"},
+		{expr: "bits(s.Flags, 4, 2)", wantErr: "bits: invalid bit range 4..2"},
+		{expr: "min(s.Size)", wantErr: "min expects at least 2 argument(s), got 1"},
+		{expr: "len(s.Size)", wantErr: "len expects a string or slice, got float64"},
+		{expr: "popcount(s.Name)", wantErr: "arg 1 of popcount must be numeric, got string"},
+	}
+	for _, tt := range tests {
+		expression, err := CompileExpression(tt.expr)
+		if err != nil {
+			t.Fatalf("CompileExpression(%q) error = %v", tt.expr, err)
+		}
+		got, err := expression.Eval(ExpressionParameters{"s": s})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Eval(%q) = %v, %v, want an error containing %q", tt.expr, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Eval(%q) = %v, %v, want %v", tt.expr, got, err, tt.want)
+		}
+	}
+}
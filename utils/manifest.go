@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ManifestFileName is the file in each output directory that records the files
+// FIG generated there, so regeneration only replaces or removes its own files.
+const ManifestFileName = ".fig-manifest.json"
+
+// Manifest maps the name of each file generated in an output directory to the
+// ContentHash of what was generated.
+type Manifest struct {
+	Files map[string]string `json:"files"`
+}
+
+// ReadManifest reads the manifest of an output directory. A directory without
+// one has a manifest with nil Files.
+func ReadManifest(dir string) (Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFileName))
+	if os.IsNotExist(err) {
+		return Manifest{}, nil
+	}
+	manifest := Manifest{Files: make(map[string]string)}
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read manifest of '%s': %w", dir, err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse manifest of '%s': %w", dir, err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]string)
+	}
+	return manifest, nil
+}
+
+// WriteManifest writes the manifest of an output directory.
+func WriteManifest(dir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ") // Map keys are sorted, so the file is stable
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	path := filepath.Join(dir, ManifestFileName)
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest '%s': %w", path, err)
+	}
+	return nil
+}
+
+// pruneManifest removes the files that no longer exist from the manifest of
+// dir, and deletes the manifest once it lists none. A directory without a
+// manifest is left alone. It reports whether the manifest was deleted.
+func pruneManifest(dir string) (bool, error) {
+	manifest, err := ReadManifest(dir)
+	if err != nil || manifest.Files == nil {
+		return false, err
+	}
+	for name := range manifest.Files {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			delete(manifest.Files, name)
+		}
+	}
+	if len(manifest.Files) > 0 {
+		return false, WriteManifest(dir, manifest)
+	}
+	path := filepath.Join(dir, ManifestFileName)
+	if err := os.Remove(path); err != nil {
+		return false, fmt.Errorf("failed to remove manifest '%s': %w", path, err)
+	}
+	return true, nil
+}
+
+// ContentHash returns the hex SHA-256 of a file's content, as recorded in manifests.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Ownership tells whether regeneration may replace or remove an existing file.
+type Ownership int
+
+const (
+	UserFile       Ownership = iota // Not generated by FIG: never replaced or removed
+	GeneratedFile                   // Generated by FIG and unchanged since
+	ModifiedByHand                  // Generated by FIG, then edited: kept, with a warning
+)
+
+// generatedHeader matches the comment marking generated Go files
+// (see https://go.dev/s/generatedcode). Test scripts don't have it, since
+// they are meant to be adapted.
+var generatedHeader = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+// Ownership returns who owns the file with the given name and content. Files
+// the manifest lists are generated, and modified by hand if their content no
+// longer matches; the others belong to the user, even if another tool
+// generated them (e.g., stringer). Without a manifest (nil Files), as in
+// directories generated before manifests existed, files are generated only if
+// they have the generated code header.
+func (m Manifest) Ownership(name string, content []byte) Ownership {
+	if m.Files == nil {
+		if generatedHeader.Match(content) {
+			return GeneratedFile
+		}
+		return UserFile
+	}
+	if hash, listed := m.Files[name]; listed {
+		if hash == ContentHash(content) {
+			return GeneratedFile
+		}
+		return ModifiedByHand
+	}
+	return UserFile
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	generatedCode = "// Code generated by FIG; DO NOT EDIT.\n\npackage bmp\n"
+	stringerCode  = "// Code generated by \"stringer -type=Kind\"; DO NOT EDIT.\n\npackage bmp\n"
+	userCode      = "package bmp\n\nfunc helper() {}\n"
+)
+
+func TestManifestOwnership(t *testing.T) {
+	listed := Manifest{Files: map[string]string{
+		"File.go":     ContentHash([]byte(generatedCode)),
+		"bmp_test.go": ContentHash([]byte(userCode)),
+	}}
+	tests := []struct {
+		name     string
+		manifest Manifest
+		file     string
+		content  string
+		want     Ownership
+	}{
+		{"listed and unchanged", listed, "File.go", generatedCode, GeneratedFile},
+		{"listed and edited by hand", listed, "File.go", generatedCode + "// Edited\n", ModifiedByHand},
+		{"listed without the header", listed, "bmp_test.go", userCode, GeneratedFile},
+		{"not listed", listed, "helpers.go", userCode, UserFile},
+		{"not listed with the header", listed, "kind_string.go", stringerCode, UserFile},
+		{"empty manifest with the header", Manifest{Files: map[string]string{}}, "File.go", generatedCode, UserFile},
+		{"no manifest with the header", Manifest{}, "File.go", generatedCode, GeneratedFile},
+		{"no manifest with another tool's header", Manifest{}, "kind_string.go", stringerCode, GeneratedFile},
+		{"no manifest without the header", Manifest{}, "bmp_test.go", userCode, UserFile},
+		{"header not on its own line", Manifest{}, "doc.go", "package bmp // Code generated by FIG; DO NOT EDIT.\n", UserFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.manifest.Ownership(tt.file, []byte(tt.content)); got != tt.want {
+				t.Errorf("Ownership(%q) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   *string // nil: no manifest file
+		wantFiles map[string]string
+		wantErr   bool
+	}{
+		{name: "missing", wantFiles: nil},
+		{name: "empty", content: strPtr(`{}`), wantFiles: map[string]string{}},
+		{name: "null files", content: strPtr(`{"files": null}`), wantFiles: map[string]string{}},
+		{name: "files", content: strPtr(`{"files": {"File.go": "abc"}}`), wantFiles: map[string]string{"File.go": "abc"}},
+		{name: "corrupt", content: strPtr(`{"files": {"File.go": `), wantErr: true},
+		{name: "wrong type", content: strPtr(`{"files": ["File.go"]}`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.content != nil {
+				writeTestFile(t, dir, ManifestFileName, *tt.content)
+			}
+			manifest, err := ReadManifest(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadManifest() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (manifest.Files == nil) != (tt.wantFiles == nil) {
+				t.Fatalf("ReadManifest() Files = %#v, want %#v", manifest.Files, tt.wantFiles)
+			}
+			if len(manifest.Files) != len(tt.wantFiles) {
+				t.Fatalf("ReadManifest() Files = %v, want %v", manifest.Files, tt.wantFiles)
+			}
+			for name, hash := range tt.wantFiles {
+				if manifest.Files[name] != hash {
+					t.Errorf("ReadManifest() Files[%q] = %q, want %q", name, manifest.Files[name], hash)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := Manifest{Files: map[string]string{"File.go": ContentHash([]byte(generatedCode)), "enums.go": "abc"}}
+	if err := WriteManifest(dir, want); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+	got, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if len(got.Files) != len(want.Files) {
+		t.Fatalf("ReadManifest() Files = %v, want %v", got.Files, want.Files)
+	}
+	for name, hash := range want.Files {
+		if got.Files[name] != hash {
+			t.Errorf("ReadManifest() Files[%q] = %q, want %q", name, got.Files[name], hash)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// writeTestFile writes content to name in dir.
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fileExists reports whether name exists in dir.
+func fileExists(t *testing.T, dir, name string) bool {
+	t.Helper()
+	_, err := os.Stat(filepath.Join(dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
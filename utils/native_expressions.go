@@ -0,0 +1,461 @@
+package utils
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"FIG/app_structs"
+)
+
+// NativeContextVar is the name of the variable that generated Read methods
+// declare for the typed context used by native expressions.
+const NativeContextVar = "typedCtx"
+
+// NativeFunction is the plain Go implementation of an expression function,
+// emitted into the generated package when a native expression calls it.
+type NativeFunction struct {
+	GoName   string // Name of the generated Go function
+	Arity    int
+	Variadic bool   // Arity is the minimum number of arguments
+	Source   string // Go source of the function
+}
+
+// NativeExpressionFunctions are the native counterparts of GetExpressionFunctions
+// (len is translated to Go's len instead).
+// They work on int64 and report invalid input with a negative result, which the
+// generated code rejects like any other negative length.
+var NativeExpressionFunctions = map[string]NativeFunction{
+	"CalculatePaddedSize": {
+		GoName: "calculatePaddedSize",
+		Arity:  3,
+		Source: `// calculatePaddedSize returns the size of pixel data whose rows are padded
+// to 4 bytes, or -1 if bitsPerPixel is not a whole number of bytes.
+func calculatePaddedSize(width, height, bitsPerPixel int64) int64 {
+	bytesPerPixel := bitsPerPixel / 8
+	if bytesPerPixel <= 0 {
+		return -1
+	}
+	bytesPerRow := width * bytesPerPixel
+	paddingPerRow := (4 - bytesPerRow%4) % 4 // Standard BMP padding logic
+	return height * (bytesPerRow + paddingPerRow)
+}
+`,
+	},
+	"align": {
+		GoName: "alignUp",
+		Arity:  2,
+		Source: `// alignUp returns n rounded up to a multiple of k, or -1 if k is not positive.
+func alignUp(n, k int64) int64 {
+	if k <= 0 {
+		return -1
+	}
+	return n + (k-n%k)%k
+}
+`,
+	},
+	"pad_to": {
+		GoName: "padTo",
+		Arity:  2,
+		Source: `// padTo returns the number of bytes needed to pad n to a multiple of k, or -1
+// if k is not positive.
+func padTo(n, k int64) int64 {
+	if k <= 0 {
+		return -1
+	}
+	return (k - n%k) % k
+}
+`,
+	},
+	"ceil_div": {
+		GoName: "ceilDiv",
+		Arity:  2,
+		Source: `// ceilDiv returns a / b rounded up, or -1 if b is not positive.
+func ceilDiv(a, b int64) int64 {
+	if b <= 0 {
+		return -1
+	}
+	return (a + (b-a%b)%b) / b
+}
+`,
+	},
+	"bits": {
+		GoName: "bitRange",
+		Arity:  3,
+		Source: `// bitRange returns bits lo to hi (inclusive, 0 = least significant) of x, or
+// -1 if the range is invalid.
+func bitRange(x, lo, hi int64) int64 {
+	if lo < 0 || hi < lo || hi > 63 {
+		return -1
+	}
+	return int64(uint64(x) >> uint(lo) & (1<<uint(hi-lo+1) - 1))
+}
+`,
+	},
+	"popcount": {
+		GoName: "popcount",
+		Arity:  1,
+		Source: `// popcount returns the number of bits set in x.
+func popcount(x int64) int64 {
+	n := int64(0)
+	for u := uint64(x); u != 0; u &= u - 1 {
+		n++
+	}
+	return n
+}
+`,
+	},
+	"min": {
+		GoName:   "minOf",
+		Arity:    2,
+		Variadic: true,
+		Source: `// minOf returns the smallest of its arguments.
+func minOf(first int64, rest ...int64) int64 {
+	for _, v := range rest {
+		if v < first {
+			first = v
+		}
+	}
+	return first
+}
+`,
+	},
+	"max": {
+		GoName:   "maxOf",
+		Arity:    2,
+		Variadic: true,
+		Source: `// maxOf returns the largest of its arguments.
+func maxOf(first int64, rest ...int64) int64 {
+	for _, v := range rest {
+		if v > first {
+			first = v
+		}
+	}
+	return first
+}
+`,
+	},
+}
+
+// NativeExpression is a YAML expression translated to Go source.
+type NativeExpression struct {
+	Code      string   // Go expression, e.g. "int64(s.Len) * 2"
+	UsesCtx   bool     // True if Code refers to NativeContextVar
+	Functions []string // Names of the NativeExpressionFunctions it calls
+	Imports   []string // Import paths of the format's functions it calls
+}
+
+// ContextStruct returns the type passed as ctx to the Read method of
+// structName: its declared context type, the File type for layout structs, or
+// the single struct that contains structName as a field. Expressions need it
+// to type-check ctx references.
+func ContextStruct(fileFormat *app_structs.FileFormat, structName string) (string, error) {
+	if contextType := fileFormat.ContextType(structName); contextType != "" {
+		return contextType, nil
+	}
+	var candidates []string
+	for _, part := range fileFormat.Layout {
+		if part == structName {
+			candidates = append(candidates, app_structs.FileTypeName)
+		}
+	}
+	for _, parent := range fileFormat.OrderedStructNames() {
+		for _, field := range fileFormat.Structs[parent].Fields {
+			if field.Type == structName || (field.IsRepeated() && field.ElementType() == structName) {
+				candidates = append(candidates, parent)
+				break
+			}
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("struct '%s' is neither in the layout nor nested in another struct, so the type of ctx is unknown; declare it with 'context'", structName)
+	case 1:
+		return candidates[0], nil
+	}
+	return "", fmt.Errorf("struct '%s' is read with different contexts (%s), so the type of ctx is ambiguous; declare it with 'context'", structName, strings.Join(candidates, ", "))
+}
+
+// TranslateExpression type-checks a length/count expression (or a condition,
+// if condition is true) of structName against the format definition and
+// translates it to Go. References to fields become int64 conversions, so the
+// arithmetic follows Go's integer rules.
+func TranslateExpression(fileFormat *app_structs.FileFormat, structName, expr string, condition bool) (NativeExpression, error) {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return NativeExpression{}, fmt.Errorf("cannot parse expression '%s': %w", expr, err)
+	}
+	t := nativeTranslator{fileFormat: fileFormat, structName: structName, functions: make(map[string]bool), imports: make(map[string]bool)}
+	code, isBool, err := t.translate(node)
+	if err != nil {
+		return NativeExpression{}, fmt.Errorf("expression '%s': %w", expr, err)
+	}
+	if condition && !isBool {
+		return NativeExpression{}, fmt.Errorf("condition '%s' must be a boolean expression (e.g., a comparison)", expr)
+	}
+	if !condition && isBool {
+		return NativeExpression{}, fmt.Errorf("expression '%s' must be numeric, not boolean", expr)
+	}
+
+	result := NativeExpression{Code: code, UsesCtx: t.usesCtx}
+	for name := range t.functions {
+		result.Functions = append(result.Functions, name)
+	}
+	sort.Strings(result.Functions)
+	for path := range t.imports {
+		result.Imports = append(result.Imports, path)
+	}
+	sort.Strings(result.Imports)
+	return result, nil
+}
+
+// nativeTranslator walks an expression AST and produces Go source.
+type nativeTranslator struct {
+	fileFormat *app_structs.FileFormat
+	structName string
+	usesCtx    bool
+	functions  map[string]bool
+	imports    map[string]bool
+}
+
+// translate returns the Go source of node and whether it is boolean.
+func (t *nativeTranslator) translate(node ast.Expr) (string, bool, error) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		code, isBool, err := t.translate(n.X)
+		return "(" + code + ")", isBool, err
+
+	case *ast.BasicLit:
+		switch n.Kind {
+		case token.INT:
+			return n.Value, false, nil
+		case token.CHAR:
+			value, _, _, err := strconv.UnquoteChar(n.Value[1:len(n.Value)-1], '\'')
+			if err != nil {
+				return "", false, fmt.Errorf("invalid character literal %s", n.Value)
+			}
+			return strconv.Itoa(int(value)), false, nil
+		}
+		return "", false, fmt.Errorf("unsupported literal %s: native expressions use integer arithmetic only", n.Value)
+
+	case *ast.Ident:
+		switch n.Name {
+		case "true", "false":
+			return n.Name, true, nil
+		}
+		return "", false, fmt.Errorf("unknown name '%s': use 's.<Field>' or 'ctx.<Field>'", n.Name)
+
+	case *ast.SelectorExpr:
+		return t.translateReference(n)
+
+	case *ast.UnaryExpr:
+		code, isBool, err := t.translate(n.X)
+		if err != nil {
+			return "", false, err
+		}
+		switch n.Op {
+		case token.NOT:
+			if !isBool {
+				return "", false, fmt.Errorf("operator ! needs a boolean operand")
+			}
+			return "!" + code, true, nil
+		case token.SUB, token.ADD, token.XOR:
+			if isBool {
+				return "", false, fmt.Errorf("operator %s needs a numeric operand", n.Op)
+			}
+			return n.Op.String() + code, false, nil
+		}
+		return "", false, fmt.Errorf("unsupported operator %s", n.Op)
+
+	case *ast.BinaryExpr:
+		left, leftBool, err := t.translate(n.X)
+		if err != nil {
+			return "", false, err
+		}
+		right, rightBool, err := t.translate(n.Y)
+		if err != nil {
+			return "", false, err
+		}
+		switch n.Op {
+		case token.LAND, token.LOR:
+			if !leftBool || !rightBool {
+				return "", false, fmt.Errorf("operator %s needs boolean operands", n.Op)
+			}
+			return left + " " + n.Op.String() + " " + right, true, nil
+		case token.EQL, token.NEQ:
+			if leftBool != rightBool {
+				return "", false, fmt.Errorf("operator %s cannot compare a boolean with a number", n.Op)
+			}
+			return left + " " + n.Op.String() + " " + right, true, nil
+		case token.LSS, token.LEQ, token.GTR, token.GEQ:
+			if leftBool || rightBool {
+				return "", false, fmt.Errorf("operator %s needs numeric operands", n.Op)
+			}
+			return left + " " + n.Op.String() + " " + right, true, nil
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM,
+			token.AND, token.OR, token.XOR, token.SHL, token.SHR, token.AND_NOT:
+			if leftBool || rightBool {
+				return "", false, fmt.Errorf("operator %s needs numeric operands", n.Op)
+			}
+			return left + " " + n.Op.String() + " " + right, false, nil
+		}
+		return "", false, fmt.Errorf("unsupported operator %s", n.Op)
+
+	case *ast.CallExpr:
+		ident, ok := n.Fun.(*ast.Ident)
+		if !ok {
+			return "", false, fmt.Errorf("unsupported function call")
+		}
+		if ident.Name == "len" {
+			return t.translateLen(n)
+		}
+		function, builtin := NativeExpressionFunctions[ident.Name]
+		if declared, ok := t.fileFormat.Functions[ident.Name]; ok && !builtin {
+			function = NativeFunction{GoName: declared.Go, Arity: declared.Args}
+		} else if !builtin {
+			return "", false, fmt.Errorf("unknown function '%s'", ident.Name)
+		}
+		if function.Variadic && len(n.Args) < function.Arity {
+			return "", false, fmt.Errorf("function '%s' expects at least %d argument(s), got %d", ident.Name, function.Arity, len(n.Args))
+		}
+		if !function.Variadic && len(n.Args) != function.Arity {
+			return "", false, fmt.Errorf("function '%s' expects %d argument(s), got %d", ident.Name, function.Arity, len(n.Args))
+		}
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			code, isBool, err := t.translate(arg)
+			if err != nil {
+				return "", false, err
+			}
+			if isBool {
+				return "", false, fmt.Errorf("argument %d of '%s' must be numeric", i+1, ident.Name)
+			}
+			args[i] = code
+		}
+		if builtin {
+			t.functions[ident.Name] = true
+		} else if path := t.fileFormat.Functions[ident.Name].Import; path != "" {
+			t.imports[path] = true
+		}
+		return function.GoName + "(" + strings.Join(args, ", ") + ")", false, nil
+	}
+	return "", false, fmt.Errorf("unsupported syntax %T", node)
+}
+
+// translateReference type-checks an s./ctx. field reference and converts it to int64.
+func (t *nativeTranslator) translateReference(n *ast.SelectorExpr) (string, bool, error) {
+	code, reference, fieldType, err := t.resolveReference(n)
+	if err != nil {
+		return "", false, err
+	}
+	if !app_structs.IsNumericType(fieldType) {
+		return "", false, fmt.Errorf("'%s' is a %s; only numeric fields can be used in expressions", reference, fieldType)
+	}
+	return "int64(" + code + ")", false, nil
+}
+
+// translateLen translates len(<reference>), the length of a string or slice field.
+func (t *nativeTranslator) translateLen(n *ast.CallExpr) (string, bool, error) {
+	if len(n.Args) != 1 {
+		return "", false, fmt.Errorf("function 'len' expects 1 argument(s), got %d", len(n.Args))
+	}
+	sel, ok := n.Args[0].(*ast.SelectorExpr)
+	if !ok {
+		return "", false, fmt.Errorf("len needs a field reference (e.g., 'len(s.Data)')")
+	}
+	code, reference, fieldType, err := t.resolveReference(sel)
+	if err != nil {
+		return "", false, err
+	}
+	if fieldType != "string" && !strings.HasPrefix(fieldType, "[]") {
+		return "", false, fmt.Errorf("'%s' is a %s; len needs a string or slice field", reference, fieldType)
+	}
+	return "int64(len(" + code + "))", false, nil
+}
+
+// resolveReference resolves an s./ctx. field reference, returning its Go
+// source (e.g. "ctx.Hdr.Width"), the reference as written and its YAML type.
+func (t *nativeTranslator) resolveReference(n *ast.SelectorExpr) (code, reference, fieldType string, err error) {
+	var path []string
+	var node ast.Expr = n
+	for {
+		sel, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			break
+		}
+		path = append([]string{sel.Sel.Name}, path...)
+		node = sel.X
+	}
+	root, ok := node.(*ast.Ident)
+	if !ok {
+		return "", "", "", fmt.Errorf("unsupported reference: use 's.<Field>' or 'ctx.<Field>'")
+	}
+	reference = root.Name + "." + strings.Join(path, ".")
+	if root.Name != "s" && root.Name != "ctx" {
+		return "", "", "", fmt.Errorf("unknown name '%s' in '%s': use 's.<Field>' or 'ctx.<Field>'", root.Name, reference)
+	}
+
+	fieldType, err = ResolveReference(t.fileFormat, t.structName, reference)
+	if err != nil {
+		return "", "", "", err
+	}
+	goRoot := "s"
+	if root.Name == "ctx" {
+		goRoot = NativeContextVar
+		if t.fileFormat.ContextType(t.structName) != "" {
+			goRoot = "ctx" // Read already takes a typed ctx
+		}
+		t.usesCtx = true
+	}
+	return goRoot + "." + strings.Join(path, "."), reference, fieldType, nil
+}
+
+// ResolveReference returns the YAML type of the field named by an s./ctx.
+// reference (e.g. "ctx.InfoHeader.Width") in an expression of structName.
+// The type of ctx is determined by ContextStruct.
+func ResolveReference(fileFormat *app_structs.FileFormat, structName, reference string) (string, error) {
+	path := strings.Split(reference, ".")
+	fieldType := structName
+	if path[0] == "ctx" {
+		ctxStruct, err := ContextStruct(fileFormat, structName)
+		if err != nil {
+			return "", fmt.Errorf("'%s': %w", reference, err)
+		}
+		fieldType = ctxStruct
+	}
+	path = path[1:]
+	for i, name := range path {
+		if fieldType == app_structs.FileTypeName && fileFormat.HasLayout() {
+			found := false
+			for _, part := range fileFormat.Layout {
+				if part == name {
+					found = true
+				}
+			}
+			if !found {
+				return "", fmt.Errorf("'%s': '%s' is not a struct of the layout", reference, name)
+			}
+			fieldType = name
+			continue
+		}
+		fields, ok := fileFormat.TypeFields(fieldType)
+		if !ok {
+			return "", fmt.Errorf("'%s': '%s' is a %s, not a struct", reference, strings.Join(path[:i], "."), fieldType)
+		}
+		found := false
+		for _, field := range fields {
+			if field.Name == name {
+				fieldType, found = field.Type, true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("'%s': struct '%s' has no field '%s'", reference, fieldType, name)
+		}
+	}
+	return fieldType, nil
+}
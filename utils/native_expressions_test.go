@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"FIG/app_structs"
+
+	"gopkg.in/yaml.v2"
+)
+
+const nativeYAML = `expressions: native
+layout: [Header, Image]
+structs:
+  Header:
+    fields:
+      - {name: Size, type: uint32}
+      - {name: Name, type: string, length: 4}
+  Image:
+    fields:
+      - {name: Width, type: uint16}
+      - {name: Height, type: uint16}
+      - {name: Flags, type: uint8}
+      - {name: Rows, type: "[]Row", count: "s.Height"}
+  Row:
+    fields:
+      - {name: Pixels, type: "[]byte", length: "ctx.Width"}
+  Orphan:
+    fields:
+      - {name: Data, type: "[]byte", length: "ctx.Size"}
+`
+
+func TestTranslateExpression(t *testing.T) {
+	var fileFormat app_structs.FileFormat
+	if err := yaml.Unmarshal([]byte(nativeYAML), &fileFormat); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		structName, expr string
+		condition        bool
+		want             NativeExpression
+		wantErr          string
+	}{
+		{structName: "Image", expr: "s.Width * s.Height", want: NativeExpression{Code: "int64(s.Width) * int64(s.Height)"}},
+		{structName: "Image", expr: "s.Flags & 1 != 0", condition: true, want: NativeExpression{Code: "int64(s.Flags) & 1 != 0"}},
+		{structName: "Image", expr: "ctx.Header.Size - 54", want: NativeExpression{Code: "int64(typedCtx.Header.Size) - 54", UsesCtx: true}},
+		{structName: "Row", expr: "ctx.Width", want: NativeExpression{Code: "int64(typedCtx.Width)", UsesCtx: true}},
+		{
+			structName: "Image",
+			expr:       "CalculatePaddedSize(s.Width, s.Height, 24)",
+			want:       NativeExpression{Code: "calculatePaddedSize(int64(s.Width), int64(s.Height), 24)", Functions: []string{"CalculatePaddedSize"}},
+		},
+		{
+			structName: "Image",
+			expr:       "align(s.Width, 4) + max(s.Width, s.Height, 2)",
+			want:       NativeExpression{Code: "alignUp(int64(s.Width), 4) + maxOf(int64(s.Width), int64(s.Height), 2)", Functions: []string{"align", "max"}},
+		},
+		{structName: "Image", expr: "len(s.Rows) + len(ctx.Header.Name)", want: NativeExpression{Code: "int64(len(s.Rows)) + int64(len(typedCtx.Header.Name))", UsesCtx: true}},
+		{structName: "Image", expr: "len(s.Width)", wantErr: "len needs a string or slice field"},
+		{structName: "Image", expr: "len(4)", wantErr: "len needs a field reference"},
+		{structName: "Image", expr: "min(s.Width)", wantErr: "function 'min' expects at least 2 argument(s), got 1"},
+		{structName: "Image", expr: "bits(s.Flags, 1)", wantErr: "function 'bits' expects 3 argument(s), got 2"},
+		{structName: "Image", expr: "sqrt(s.Width)", wantErr: "unknown function 'sqrt'"},
+		{structName: "Image", expr: "s.Width +", wantErr: "cannot parse expression"},
+		{structName: "Image", expr: "s.Missing", wantErr: "Missing"},
+		{structName: "Header", expr: "s.Name", wantErr: "Name"},
+		{structName: "Image", expr: "s.Width == 1", wantErr: "must be numeric, not boolean"},
+		{structName: "Image", expr: "s.Width", condition: true, wantErr: "must be a boolean expression"},
+		{structName: "Orphan", expr: "ctx.Size", wantErr: "the type of ctx is unknown"},
+	}
+	for _, tt := range tests {
+		got, err := TranslateExpression(&fileFormat, tt.structName, tt.expr, tt.condition)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("TranslateExpression(%s, %q) = %+v, %v, want an error containing %q", tt.structName, tt.expr, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got.Code != tt.want.Code || got.UsesCtx != tt.want.UsesCtx || strings.Join(got.Functions, ",") != strings.Join(tt.want.Functions, ",") {
+			t.Errorf("TranslateExpression(%s, %q) = %+v, %v, want %+v", tt.structName, tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestContextStruct(t *testing.T) {
+	var fileFormat app_structs.FileFormat
+	if err := yaml.Unmarshal([]byte(nativeYAML), &fileFormat); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		structName, want, wantErr string
+	}{
+		{structName: "Image", want: "File"},
+		{structName: "Row", want: "Image"},
+		{structName: "Orphan", wantErr: "the type of ctx is unknown"},
+	}
+	for _, tt := range tests {
+		got, err := ContextStruct(&fileFormat, tt.structName)
+		if got != tt.want || (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ContextStruct(%s) = %q, %v, want %q, %q", tt.structName, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// A struct nested in two structs is read with either as ctx
+	fileFormat.Structs["Other"] = app_structs.Struct{Fields: []app_structs.Field{{Name: "Row", Type: "Row"}}}
+	if got, err := ContextStruct(&fileFormat, "Row"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ContextStruct(Row) = %q, %v, want an ambiguous context error", got, err)
+	}
+}
@@ -2,52 +2,124 @@
 package utils
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
-// reset cleans the target directory of generated .go files.
-func Reset(targetDir string) { // Simplified parameters
-	log.Printf("Starting generator reset for directory: %s", targetDir)
-
-	// --- Stub renaming logic removed ---
+// ResetPlan is what Reset does with the .go files of an output directory.
+type ResetPlan struct {
+	Remove   []string // Generated files, unchanged since: removed
+	User     []string // Files FIG didn't generate: left alone
+	Modified []string // Generated files modified by hand since: kept
+	Kept     []string // Generated files, unchanged since, that were asked to be kept
+}
 
-	// 1. Ensure target directory exists
-	log.Printf("Ensuring directory '%s' exists...", targetDir)
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		log.Fatalf("Failed to create directory '%s': %v", targetDir, err)
-	}
+// Left returns the files Reset leaves in place, which regeneration must not
+// overwrite: the User, Modified and Kept files.
+func (p ResetPlan) Left() []string {
+	return slices.Concat(p.User, p.Modified, p.Kept)
+}
 
-	// 2. Clean generated .go files from the target directory
-	log.Printf("Cleaning generated Go files in directory '%s'...", targetDir)
+// PlanReset returns what Reset would do in targetDir, without changing
+// anything. A missing directory has nothing to reset. Generated files named in
+// keep (base names, e.g., "bmp_test.go") are kept instead of removed.
+func PlanReset(targetDir string, keep ...string) (ResetPlan, error) {
+	var plan ResetPlan
 	dirEntries, err := ioutil.ReadDir(targetDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Printf("Directory '%s' does not exist yet, nothing to clean.", targetDir)
-			log.Println("Reset step complete (directory created).")
-			return
+			return plan, nil
 		}
-		log.Fatalf("Failed to read directory '%s': %v", targetDir, err)
+		return plan, fmt.Errorf("failed to read directory '%s': %w", targetDir, err)
+	}
+	manifest, err := ReadManifest(targetDir)
+	if err != nil {
+		return plan, err
 	}
 
-	filesRemoved := 0
 	for _, entry := range dirEntries {
 		entryName := entry.Name()
-		// Only remove .go files (don't touch the reformed .yml file)
-		if !entry.IsDir() && strings.HasSuffix(entryName, ".go") {
-			filePath := filepath.Join(targetDir, entryName)
-			log.Printf("  Removing generated file: %s", filePath)
-			if err := os.Remove(filePath); err != nil {
-				log.Printf("  Warning: Failed to remove file '%s': %v", filePath, err)
-			} else {
-				filesRemoved++
+		// Only .go files are generated (don't touch the reformed .yml file)
+		if entry.IsDir() || !strings.HasSuffix(entryName, ".go") {
+			continue
+		}
+		filePath := filepath.Join(targetDir, entryName)
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return plan, fmt.Errorf("failed to read '%s': %w", filePath, err)
+		}
+		switch manifest.Ownership(entryName, content) {
+		case GeneratedFile:
+			if slices.Contains(keep, entryName) {
+				plan.Kept = append(plan.Kept, filePath)
+				continue
 			}
+			plan.Remove = append(plan.Remove, filePath)
+		case UserFile:
+			plan.User = append(plan.User, filePath)
+		case ModifiedByHand:
+			plan.Modified = append(plan.Modified, filePath)
 		}
 	}
-	log.Printf("Removed %d generated Go file(s) from '%s'.", filesRemoved, targetDir)
+	return plan, nil
+}
+
+// Reset cleans the target directory of the .go files FIG generated, creating
+// the directory if needed. Hand-written files are kept, and so are generated
+// files modified by hand since, with a warning, and the generated files named
+// in keep. The manifest then forgets the files that are gone, and is removed
+// once it lists none. Progress is logged to logger, or to the standard logger
+// if it is nil.
+func Reset(targetDir string, logger *log.Logger, keep ...string) (ResetPlan, error) {
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("Starting generator reset for directory: %s", targetDir)
+
+	// 1. Ensure target directory exists
+	logger.Printf("Ensuring directory '%s' exists...", targetDir)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return ResetPlan{}, fmt.Errorf("failed to create directory '%s': %w", targetDir, err)
+	}
+
+	// 2. Clean generated .go files from the target directory
+	logger.Printf("Cleaning generated Go files in directory '%s'...", targetDir)
+	plan, err := PlanReset(targetDir, keep...)
+	if err != nil {
+		return plan, err
+	}
+	for _, filePath := range plan.Modified {
+		logger.Printf("  Warning: Keeping '%s', which was modified by hand since it was generated. Delete it to generate it again.", filePath)
+	}
+	for _, filePath := range plan.Kept {
+		logger.Printf("  Keeping generated file: %s", filePath)
+	}
+
+	filesRemoved := 0
+	for _, filePath := range plan.Remove {
+		logger.Printf("  Removing generated file: %s", filePath)
+		if err := os.Remove(filePath); err != nil {
+			logger.Printf("  Warning: Failed to remove file '%s': %v", filePath, err)
+		} else {
+			filesRemoved++
+		}
+	}
+	logger.Printf("Removed %d generated Go file(s) from '%s'.", filesRemoved, targetDir)
+
+	// 3. Forget the removed files, so the manifest only lists files that exist
+	deleted, err := pruneManifest(targetDir)
+	if err != nil {
+		return plan, err
+	}
+	if deleted {
+		logger.Printf("  Removed manifest '%s', which no longer lists any file.", filepath.Join(targetDir, ManifestFileName))
+	}
 
-	log.Println("Reset step complete.")
+	logger.Println("Reset step complete.")
+	return plan, nil
 }
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	manifest := `{"files": {` +
+		`"File.go": "` + ContentHash([]byte(generatedCode)) + `", ` +
+		`"FileHeader.go": "` + ContentHash([]byte(generatedCode)) + `", ` +
+		`"bmp_test.go": "` + ContentHash([]byte(userCode)) + `"}}`
+	tests := []struct {
+		name         string
+		files        map[string]string // Name -> content, in the output directory
+		keep         []string
+		wantRemove   []string
+		wantUser     []string
+		wantModified []string
+		wantKept     []string
+		wantListed   []string // Sorted names the manifest lists after Reset; none if it was removed
+		wantErr      bool
+	}{
+		{
+			name: "manifest",
+			files: map[string]string{
+				ManifestFileName: manifest,
+				"File.go":        generatedCode,
+				"FileHeader.go":  generatedCode + "// Edited by hand\n",
+				"bmp_test.go":    userCode,
+				"helpers.go":     userCode,
+				"kind_string.go": stringerCode,
+				"bmp.yml":        "name: BMP\n",
+			},
+			wantRemove:   []string{"File.go", "bmp_test.go"},
+			wantUser:     []string{"helpers.go", "kind_string.go"},
+			wantModified: []string{"FileHeader.go"},
+			wantListed:   []string{"FileHeader.go"},
+		},
+		{
+			name: "manifest keeping the test script",
+			files: map[string]string{
+				ManifestFileName: manifest,
+				"File.go":        generatedCode,
+				"bmp_test.go":    userCode,
+			},
+			keep:       []string{"bmp_test.go"},
+			wantRemove: []string{"File.go"},
+			wantKept:   []string{"bmp_test.go"},
+			wantListed: []string{"bmp_test.go"},
+		},
+		{
+			name: "manifest emptied",
+			files: map[string]string{
+				ManifestFileName: manifest,
+				"File.go":        generatedCode,
+				"bmp_test.go":    userCode,
+			},
+			wantRemove: []string{"File.go", "bmp_test.go"},
+		},
+		{
+			name: "keeping a file edited by hand",
+			files: map[string]string{
+				ManifestFileName: manifest,
+				"bmp_test.go":    userCode + "// Adapted\n",
+			},
+			keep:         []string{"bmp_test.go"},
+			wantModified: []string{"bmp_test.go"},
+			wantListed:   []string{"bmp_test.go"},
+		},
+		{
+			name: "no manifest",
+			files: map[string]string{
+				"File.go":        generatedCode,
+				"kind_string.go": stringerCode,
+				"bmp_test.go":    userCode,
+				"helpers.go":     userCode,
+			},
+			wantRemove: []string{"File.go", "kind_string.go"},
+			wantUser:   []string{"bmp_test.go", "helpers.go"},
+		},
+		{
+			name: "corrupt manifest",
+			files: map[string]string{
+				ManifestFileName: `{"files": `,
+				"File.go":        generatedCode,
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeTestFile(t, dir, name, content)
+			}
+
+			plan, err := PlanReset(dir, tt.keep...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlanReset() error = %v, want error: %v", err, tt.wantErr)
+			}
+			checkPaths(t, "PlanReset() Remove", dir, plan.Remove, tt.wantRemove)
+			checkPaths(t, "PlanReset() User", dir, plan.User, tt.wantUser)
+			checkPaths(t, "PlanReset() Modified", dir, plan.Modified, tt.wantModified)
+			checkPaths(t, "PlanReset() Kept", dir, plan.Kept, tt.wantKept)
+			checkPaths(t, "PlanReset() Left", dir, plan.Left(), slices.Concat(tt.wantUser, tt.wantModified, tt.wantKept))
+			for name := range tt.files {
+				if !fileExists(t, dir, name) {
+					t.Errorf("PlanReset() removed %s", name)
+				}
+			}
+
+			plan, err = Reset(dir, log.New(io.Discard, "", 0), tt.keep...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reset() error = %v, want error: %v", err, tt.wantErr)
+			}
+			checkPaths(t, "Reset() Remove", dir, plan.Remove, tt.wantRemove)
+			removed := make(map[string]bool)
+			for _, name := range tt.wantRemove {
+				removed[name] = true
+			}
+			for name := range tt.files {
+				if name == ManifestFileName && !tt.wantErr {
+					continue // Checked below
+				}
+				if exists := fileExists(t, dir, name); exists == removed[name] {
+					t.Errorf("Reset(): %s exists: %v, want %v", name, exists, !removed[name])
+				}
+			}
+			if tt.wantErr {
+				return
+			}
+			if exists := fileExists(t, dir, ManifestFileName); exists != (len(tt.wantListed) > 0) {
+				t.Errorf("Reset(): manifest exists: %v, want %v", exists, !exists)
+			}
+			after, err := ReadManifest(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var listed []string
+			for name := range after.Files {
+				listed = append(listed, name)
+			}
+			slices.Sort(listed)
+			if !slices.Equal(listed, tt.wantListed) {
+				t.Errorf("Reset() manifest lists %v, want %v", listed, tt.wantListed)
+			}
+		})
+	}
+}
+
+func TestResetMissingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "formats", "bmp")
+	plan, err := PlanReset(dir)
+	if err != nil || len(plan.Remove) > 0 || len(plan.Left()) > 0 {
+		t.Fatalf("PlanReset() = %+v, %v, want an empty plan", plan, err)
+	}
+	if _, err := Reset(dir, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if !fileExists(t, filepath.Dir(dir), "bmp") {
+		t.Errorf("Reset() didn't create %s", dir)
+	}
+}
+
+// checkPaths checks that paths are the files of dir with the wanted names.
+func checkPaths(t *testing.T, what, dir string, paths, wantNames []string) {
+	t.Helper()
+	var names []string
+	for _, path := range paths {
+		if filepath.Dir(path) != dir {
+			t.Errorf("%s: %s is not in %s", what, path, dir)
+		}
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	want := append([]string(nil), wantNames...)
+	sort.Strings(want)
+	if len(names) != len(want) {
+		t.Errorf("%s = %v, want %v", what, names, want)
+		return
+	}
+	for i := range names {
+		if names[i] != want[i] {
+			t.Errorf("%s = %v, want %v", what, names, want)
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"strings"
+
+	yamlnode "gopkg.in/yaml.v3"
+)
+
+// sourceLocator finds the position of attributes in the original YAML, so
+// validation diagnostics can point at them. The decoded FileFormat has no
+// position information, so the source is parsed a second time into a node tree.
+type sourceLocator struct {
+	path string
+	root *yamlnode.Node // nil if the source could not be parsed
+}
+
+// newSourceLocator parses the original YAML source at path.
+func newSourceLocator(path string, data []byte) *sourceLocator {
+	locator := &sourceLocator{path: path}
+	var document yamlnode.Node
+	if err := yamlnode.Unmarshal(data, &document); err == nil && len(document.Content) > 0 {
+		locator.root = document.Content[0]
+	}
+	return locator
+}
+
+// named selects the element of a sequence whose "name" is the given value,
+// such as a field in a struct's fields.
+type named string
+
+// position returns the line and column of the node at path, whose elements
+// are mapping keys (strings, matched case-insensitively), sequence indexes
+// (ints) or named elements. For a mapping key, the position is the key's. When
+// part of the path is missing, it returns the position of the deepest node
+// found, or 0, 0 if there is none.
+func (l *sourceLocator) position(path ...interface{}) (line, column int) {
+	node := l.root
+	for _, step := range path {
+		if node != nil && node.Kind == yamlnode.AliasNode {
+			node = node.Alias
+		}
+		if node == nil {
+			break
+		}
+		var next, at *yamlnode.Node
+		switch step := step.(type) {
+		case string:
+			if key := mappingKey(node, step); key != nil {
+				next, at = mappingValue(node, step), key
+			}
+		case int:
+			if node.Kind == yamlnode.SequenceNode && step >= 0 && step < len(node.Content) {
+				next = node.Content[step]
+				at = next
+			}
+		case named:
+			if node.Kind == yamlnode.SequenceNode {
+				for _, elem := range node.Content {
+					if name := mappingValue(elem, "name"); name != nil && name.Value == string(step) {
+						next, at = elem, elem
+						break
+					}
+				}
+			}
+		}
+		if at == nil {
+			break
+		}
+		line, column = at.Line, at.Column
+		node = next
+	}
+	return line, column
+}
+
+// mappingValue returns the value of key in a mapping node. Keys are matched
+// case-insensitively, like the field attributes themselves.
+func mappingValue(node *yamlnode.Node, key string) *yamlnode.Node {
+	if node == nil || node.Kind != yamlnode.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingKey returns the key node of key in a mapping node, or nil if it is missing.
+func mappingKey(node *yamlnode.Node, key string) *yamlnode.Node {
+	if node == nil || node.Kind != yamlnode.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"FIG/app_structs"
+
+	yamlnode "gopkg.in/yaml.v3"
+)
+
+// unknownKey is a key of the YAML source that no attribute matches. Decoding
+// drops such keys, so typos (e.g., "lenght") would otherwise go unnoticed.
+type unknownKey struct {
+	Key        string
+	Path       string // Where the key is, e.g. "structs.FileHeader.fields[Width]"
+	Line       int
+	Column     int
+	Suggestion string // Closest known key, if the key looks like a misspelling of it
+}
+
+// findUnknownKeys walks the YAML node tree along the Go type it decodes into
+// (a FileFormat at the root) and returns the keys that match no yaml tag.
+// Keys are matched case-insensitively, like decoding does.
+func findUnknownKeys(node *yamlnode.Node, t reflect.Type, path string) []unknownKey {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yamlnode.AliasNode {
+		return findUnknownKeys(node.Alias, t, path)
+	}
+
+	var found []unknownKey
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == reflect.TypeOf(app_structs.Context{}) && node.Kind == yamlnode.SequenceNode {
+			return findUnknownKeys(node, reflect.TypeOf([]app_structs.Field{}), path) // A list of context fields
+		}
+		if node.Kind != yamlnode.MappingNode {
+			return nil // Scalars (e.g., "context: InfoHeader") and type errors are left to decoding
+		}
+		attributes := yamlAttributes(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" { // Merge key
+				continue
+			}
+			fieldType, ok := attributes[strings.ToLower(key.Value)]
+			if !ok {
+				found = append(found, unknownKey{Key: key.Value, Path: path, Line: key.Line, Column: key.Column, Suggestion: closestKey(key.Value, attributes)})
+				continue
+			}
+			found = append(found, findUnknownKeys(value, fieldType, joinKeyPath(path, key.Value))...)
+		}
+	case reflect.Map:
+		if node.Kind != yamlnode.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			found = append(found, findUnknownKeys(node.Content[i+1], t.Elem(), joinKeyPath(path, node.Content[i].Value))...)
+		}
+	case reflect.Slice:
+		if node.Kind != yamlnode.SequenceNode {
+			return nil
+		}
+		for i, elem := range node.Content {
+			label := strconv.Itoa(i)
+			if name := mappingValue(elem, "name"); name != nil && name.Kind == yamlnode.ScalarNode && name.Value != "" {
+				label = name.Value
+			}
+			found = append(found, findUnknownKeys(elem, t.Elem(), fmt.Sprintf("%s[%s]", path, label))...)
+		}
+	}
+	return found
+}
+
+// yamlAttributes maps the lowercased yaml key of each field of the struct type
+// t to the field's type. Fields without a yaml tag use their lowercased name.
+func yamlAttributes(t reflect.Type) map[string]reflect.Type {
+	attributes := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		attributes[strings.ToLower(name)] = field.Type
+	}
+	return attributes
+}
+
+// joinKeyPath appends a key to a dotted path.
+func joinKeyPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closestKey returns the attribute closest to key, or "" if none is close
+// enough to be a likely misspelling.
+func closestKey(key string, attributes map[string]reflect.Type) string {
+	key = strings.ToLower(key)
+	best, bestDistance := "", 0
+	for name := range attributes {
+		distance := editDistance(key, name)
+		if best == "" || distance < bestDistance || distance == bestDistance && name < best {
+			best, bestDistance = name, distance
+		}
+	}
+	if best == "" || bestDistance > 1 && bestDistance > len(key)/3 {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the number of single-character insertions, deletions,
+// substitutions and transpositions of adjacent characters turning a into b.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1) // Row i-2, for transpositions
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(b)]
+}
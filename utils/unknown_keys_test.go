@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"FIG/app_structs"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"length", "length", 0},
+		{"lenght", "length", 1},
+		{"lengt", "length", 1},
+		{"lenghts", "length", 2},
+		{"type", "", 4},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestClosestKey(t *testing.T) {
+	attributes := yamlAttributes(reflect.TypeOf(app_structs.Field{}))
+	tests := []struct {
+		key, want string
+	}{
+		{"lenght", "length"},
+		{"Tpye", "type"},
+		{"encodng", "encoding"},
+		{"xyz", ""},
+		{"colour", ""},
+	}
+	for _, tt := range tests {
+		if got := closestKey(tt.key, attributes); got != tt.want {
+			t.Errorf("closestKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -3,18 +3,19 @@ package utils
 
 import (
 	"fmt"
+	"go/token"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"FIG/app_structs"
 	
 
-	"github.com/knetic/govaluate"
 	"github.com/mitchellh/mapstructure" // Import mapstructure
 	"gopkg.in/yaml.v2"
 )
@@ -39,6 +40,16 @@ func lowercaseFieldKeysRecursive(data interface{}) interface{} {
 		"length":      true,
 		"condition":   true,
 		"tags":        true,
+		"endian":      true,
+		"count":       true,
+		"pad":         true,
+		"truncate":    true,
+		"encoding":    true,
+		"max_length":  true,
+		"enum":        true,
+		"bits":        true,
+		"value":       true,
+		"magic":       true,
 	}
 
 	switch kind {
@@ -93,36 +104,84 @@ func lowercaseFieldKeysRecursive(data interface{}) interface{} {
 // ValidateAndReformYAML reads the original YAML, handles key case-insensitivity,
 // validates/reforms values, and saves the result to the target path.
 // It returns the path to the saved reformed YAML file.
-func ValidateAndReformYAML(originalYAMLPath, outputDir string) (string, error) {
+func ValidateAndReformYAML(originalYAMLPath, outputDir string, opts ValidationOptions) (string, error) {
 	// Calculate the path where the reformed YAML should reside inside the output dir
 	reformedYamlPath := filepath.Join(outputDir, filepath.Base(originalYAMLPath))
 
-	log.Printf("Validating/Reforming '%s' -> '%s'", originalYAMLPath, reformedYamlPath)
+	logger := opts.logger()
+	logger.Printf("Validating/Reforming '%s' -> '%s'", originalYAMLPath, reformedYamlPath)
 
-	// --- 1. Ensure output directory exists ---
+	finalYamlData, _, err := ValidateYAML(originalYAMLPath, opts) // Diagnostics are logged
+	if err != nil {
+		return "", err
+	}
+
+	// --- Ensure output directory exists ---
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to ensure output directory '%s': %w", outputDir, err)
 	}
 
+	// --- Save the final YAML ---
+	err = ioutil.WriteFile(reformedYamlPath, finalYamlData, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write final reformed YAML file '%s': %w", reformedYamlPath, err)
+	}
+	logger.Printf("Saved validated/reformed YAML to: %s", reformedYamlPath)
+
+	return reformedYamlPath, nil
+}
+
+// ValidationOptions tunes validation. The zero value validates strictly.
+type ValidationOptions struct {
+	// AllowUnknownKeys reports YAML keys that match no attribute as warnings
+	// instead of errors. They are left out of the reformed YAML either way.
+	AllowUnknownKeys bool
+	// Logger receives the diagnostics and progress messages; nil uses the
+	// standard logger.
+	Logger *log.Logger
+}
+
+// logger returns the logger validation reports to.
+func (opts ValidationOptions) logger() *log.Logger {
+	if opts.Logger == nil {
+		return log.Default()
+	}
+	return opts.Logger
+}
+
+// ValidateYAML reads the original YAML, handles key case-insensitivity and
+// validates/reforms values without writing anything (a dry run of
+// ValidateAndReformYAML). It returns the reformed YAML, and the diagnostics
+// reported along the way, which are also logged. The error is non-nil if any
+// diagnostic is an error.
+func ValidateYAML(originalYAMLPath string, opts ValidationOptions) ([]byte, []Diagnostic, error) {
 	// --- 2. Read original YAML bytes ---
 	yamlBytes, err := ioutil.ReadFile(originalYAMLPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read original YAML file '%s': %w", originalYAMLPath, err)
+		return nil, nil, fmt.Errorf("failed to read original YAML file '%s': %w", originalYAMLPath, err)
 	}
+	return ValidateYAMLSource(originalYAMLPath, yamlBytes, opts)
+}
+
+// ValidateYAMLSource is ValidateYAML for a source that is already in memory.
+// originalYAMLPath only names the source in messages and diagnostics.
+func ValidateYAMLSource(originalYAMLPath string, yamlBytes []byte, opts ValidationOptions) ([]byte, []Diagnostic, error) {
+	v := &validation{logger: opts.logger(), locator: newSourceLocator(originalYAMLPath, yamlBytes)}
 
 	// --- 3. Unmarshal into generic map ---
 	var genericData map[string]interface{}
-	err = yaml.Unmarshal(yamlBytes, &genericData)
+	err := yaml.Unmarshal(yamlBytes, &genericData)
 	if err != nil {
-		return "", fmt.Errorf("error parsing initial YAML structure from %s: %w", originalYAMLPath, err)
+		v.syntaxError(err)
+		return nil, v.diagnostics, fmt.Errorf("error parsing initial YAML structure from %s: %w", originalYAMLPath, err)
 	}
 
 	// --- 4. Recursively lowercase specific field keys ---
-	log.Printf("Normalizing specific YAML field keys to lowercase for %s...", originalYAMLPath)
+	v.logger.Printf("Normalizing specific YAML field keys to lowercase for %s...", originalYAMLPath)
 	lowerCasedData := lowercaseFieldKeysRecursive(genericData)
 
 	// --- 5. Decode the modified map directly into the struct using mapstructure ---
-	log.Printf("Decoding normalized map into struct for %s...", originalYAMLPath)
+	v.logger.Printf("Decoding normalized map into struct for %s...", originalYAMLPath)
 	var fileFormat app_structs.FileFormat
 	// Configure mapstructure to use the 'yaml' tag
 	config := &mapstructure.DecoderConfig{
@@ -130,17 +189,24 @@ func ValidateAndReformYAML(originalYAMLPath, outputDir string) (string, error) {
 		Result:           &fileFormat,
 		TagName:          "yaml", // Tell mapstructure to use the 'yaml' tags
 		WeaklyTypedInput: true,
+		DecodeHook:       decodeContextHook,
 	}
 	decoder, err := mapstructure.NewDecoder(config)
 	if err != nil {
-		return "", fmt.Errorf("failed to create mapstructure decoder for %s: %w", originalYAMLPath, err)
+		return nil, v.diagnostics, fmt.Errorf("failed to create mapstructure decoder for %s: %w", originalYAMLPath, err)
 	}
 	// Decode the lowerCasedData map (which should have correct keys now)
 	if err := decoder.Decode(lowerCasedData); err != nil {
-		log.Printf("Mapstructure decoding error details: %v", err)
-		return "", fmt.Errorf("error decoding normalized map to struct for %s: %w", originalYAMLPath, err)
+		v.errorf(place{}, "Decoding error: %v", err)
+		return nil, v.diagnostics, fmt.Errorf("error decoding normalized map to struct for %s: %w", originalYAMLPath, err)
+	}
+	v.logger.Printf("Successfully decoded normalized map for %s.", originalYAMLPath)
+
+	// The generic map lost the struct declaration order; recover it from the source
+	fileFormat.StructOrder, err = app_structs.ParseStructOrder(yamlBytes)
+	if err != nil {
+		return nil, v.diagnostics, fmt.Errorf("error reading struct order from %s: %w", originalYAMLPath, err)
 	}
-	log.Printf("Successfully decoded normalized map for %s.", originalYAMLPath)
 
 	// --- Steps 5 & 6 (Marshal/Unmarshal normalized bytes) are REMOVED ---
 	// We now directly decode the map in the new Step 5 above.
@@ -149,15 +215,78 @@ func ValidateAndReformYAML(originalYAMLPath, outputDir string) (string, error) {
 	// This logic now operates on the fileFormat struct populated by mapstructure
 	reformationsMade := 0
 	validationErrors := 0
-	// ... (Keep the entire validation loop exactly as it was) ...
-	for structName, structDef := range fileFormat.Structs {
+
+	// Decoding drops keys that match no attribute, so look for them in the source
+	validationErrors += reportUnknownKeys(v, opts)
+
+	// Validate the expression mode
+	if mode, errMode := app_structs.NormalizeExpressionMode(fileFormat.ExpressionMode); errMode != nil {
+		v.errorf(keyAt("expressions"), "Validation error in format '%s': %v", fileFormat.Name, errMode)
+		validationErrors++
+	} else if mode != fileFormat.ExpressionMode {
+		v.infof(keyAt("expressions"), "Reforming format-level 'expressions: %s' to '%s'.", fileFormat.ExpressionMode, mode)
+		fileFormat.ExpressionMode = mode
+		reformationsMade++
+	}
+
+	// Validate the format-level default byte order
+	if fileFormat.Endian != "" {
+		normalized, errEndian := app_structs.NormalizeEndian(fileFormat.Endian)
+		if errEndian != nil {
+			v.errorf(keyAt("endian"), "Validation error in format '%s': %v", fileFormat.Name, errEndian)
+			validationErrors++
+		} else if normalized != fileFormat.Endian {
+			v.infof(keyAt("endian"), "Reforming format-level 'endian: %s' to '%s'.", fileFormat.Endian, normalized)
+			fileFormat.Endian = normalized
+			reformationsMade++
+		}
+	}
+
+	// Enums are checked first, since fields take their type from them
+	validationErrors += validateEnums(v, &fileFormat)
+
+	// Validate and reform the fields of every struct
+	for _, structName := range fileFormat.OrderedStructNames() {
+		structDef := fileFormat.Structs[structName]
 		tempStructDef := structDef
 		for i := range tempStructDef.Fields {
 			field := &tempStructDef.Fields[i] // Use pointer to modify
 
+			// Reform the magic alias into value
+			if field.Magic != "" {
+				if field.Value != "" && field.Value != field.Magic {
+					v.errorf(fieldAt(structName, field.Name, "magic"), "Validation error in struct '%s': field '%s' has both 'value: %s' and 'magic: %s'. Use only 'value'.", structName, field.Name, field.Value, field.Magic)
+					validationErrors++
+					continue
+				}
+				v.infof(fieldAt(structName, field.Name, "magic"), "Reforming struct '%s': field '%s' 'magic' to 'value: %q'.", structName, field.Name, field.Magic)
+				field.Value, field.Magic = field.Magic, ""
+				reformationsMade++
+			}
+
+			// Validate Enum: a field referring to an enum takes its type unless it declares one
+			if field.Enum != "" {
+				field.Enum = strings.TrimSpace(field.Enum)
+				enum, ok := fileFormat.Enums[field.Enum]
+				switch {
+				case !ok:
+					v.errorf(fieldAt(structName, field.Name, "enum"), "Validation error in struct '%s': field '%s' refers to unknown enum '%s'.", structName, field.Name, field.Enum)
+					validationErrors++
+					continue
+				case strings.TrimSpace(field.Type) == "" && enum.Type != "":
+					v.infof(fieldAt(structName, field.Name, "enum"), "Reforming struct '%s': field '%s' takes 'type: %s' from enum '%s'.", structName, field.Name, enum.Type, field.Enum)
+					field.Type = enum.Type
+					reformationsMade++
+				case field.ElementType() != enum.Type:
+					v.errorf(fieldAt(structName, field.Name, "enum"), "Validation error in struct '%s': field '%s' of type '%s' refers to enum '%s' of type '%s'. Use 'type: %s' (or '[]%s' for a repeated field).", structName, field.Name, field.Type, field.Enum, enum.Type, enum.Type, enum.Type)
+					validationErrors++
+					continue
+				}
+			}
+
 			// Validate Type exists (should be populated now)
 			if strings.TrimSpace(field.Type) == "" {
-				log.Printf("ERROR: Validation error in struct '%s': field '%s' is missing a 'type'.", structName, field.Name)
+				v.errorf(fieldAt(structName, field.Name, "type"), "Validation error in struct '%s': field '%s' is missing a 'type'.", structName, field.Name)
 				validationErrors++
 				continue // Skip further checks for this field
 			}
@@ -165,42 +294,111 @@ func ValidateAndReformYAML(originalYAMLPath, outputDir string) (string, error) {
 			// Validate Length based on Type (existing logic)
 			switch field.Type {
 			case "string", "[]byte":
+				if field.Count != "" {
+					v.errorf(fieldAt(structName, field.Name, "count"), "Validation error in struct '%s': field '%s' of type '%s' cannot have a 'Count'. Use 'Length' for the number of bytes.", structName, field.Name, field.Type)
+					validationErrors++
+					continue
+				}
+				if field.Encoding != "" || field.MaxLength != 0 {
+					errs, reformations := validateEncoding(v, structName, field)
+					reformationsMade += reformations
+					if errs > 0 {
+						validationErrors += errs
+						continue
+					}
+					if field.IsSelfDelimited() {
+						break // Size is stored in the data, no Length to validate
+					}
+				}
+				if field.Length == "" && field.Value != "" {
+					value, errValue := field.ValueBytes()
+					if errValue != nil {
+						v.errorf(fieldAt(structName, field.Name, "value"), "Validation error in struct '%s': field '%s': %v", structName, field.Name, errValue)
+						validationErrors++
+						continue
+					}
+					v.infof(fieldAt(structName, field.Name, "value"), "Reforming struct '%s': field '%s' takes 'length: %d' from its value.", structName, field.Name, len(value))
+					field.Length = strconv.Itoa(len(value))
+					reformationsMade++
+				}
 				if field.Length == "" {
-					log.Printf("ERROR: Validation error in struct '%s': field '%s' of type '%s' requires a 'Length' specification.", structName, field.Name, field.Type)
+					v.errorf(fieldAt(structName, field.Name, "length"), "Validation error in struct '%s': field '%s' of type '%s' requires a 'Length' specification (or a cstring/pascal 'encoding').", structName, field.Name, field.Type)
 					validationErrors++
 					continue
 				}
 				if strings.TrimSpace(field.Length) == "..." {
-					log.Printf("WARNING: Reforming struct '%s': field '%s' had invalid 'Length: ...'. Replacing with 'NEEDS_MANUAL_LENGTH'.", structName, field.Name)
+					v.warnf(fieldAt(structName, field.Name, "length"), "Reforming struct '%s': field '%s' had invalid 'Length: ...'. Replacing with 'NEEDS_MANUAL_LENGTH'.", structName, field.Name)
 					field.Length = "NEEDS_MANUAL_LENGTH" // Modify via pointer
 					reformationsMade++
 				}
 				lengthInt, errConv := strconv.Atoi(field.Length)
 				if errConv == nil { // Is an integer
 					if lengthInt <= 0 {
-						log.Printf("ERROR: Validation error in struct '%s': field '%s' has invalid non-positive integer 'Length: %s'", structName, field.Name, field.Length)
+						v.errorf(fieldAt(structName, field.Name, "length"), "Validation error in struct '%s': field '%s' has invalid non-positive integer 'Length: %s'", structName, field.Name, field.Length)
 						validationErrors++
 					}
 				} else { // Not an integer, assume expression or placeholder
 					if !IsValidLengthExpression(field.Length) { // Checks for empty, "..."
-						log.Printf("ERROR: Validation error in struct '%s': field '%s' has invalid 'Length: %s'. Must be a positive integer or a valid Go expression (cannot be empty or '...')", structName, field.Name, field.Length)
+						v.errorf(fieldAt(structName, field.Name, "length"), "Validation error in struct '%s': field '%s' has invalid 'Length: %s'. Must be a positive integer or a valid Go expression (cannot be empty or '...')", structName, field.Name, field.Length)
 						validationErrors++
-					} else if field.Length != "NEEDS_MANUAL_LENGTH" { // Don't try to validate our placeholder
-						_, errExpr := govaluate.NewEvaluableExpressionWithFunctions(field.Length, GetExpressionFunctions())
-						if errExpr != nil {
-							log.Printf("Warning: Field '%s.%s' has expression length '%s' that may not be fully validatable statically: %v",
-								structName, field.Name, field.Length, errExpr)
-						}
+					} // Expressions are checked by validateExpressions once every struct is known
+				}
+
+			default:
+				if field.Count != "" && !strings.HasPrefix(field.Type, "[]") {
+					v.errorf(fieldAt(structName, field.Name, "count"), "Validation error in struct '%s': field '%s' has a 'Count' but type '%s' is not a slice. Use '[]%s'.", structName, field.Name, field.Type, field.Type)
+					validationErrors++
+					continue
+				}
+				if strings.HasPrefix(field.Type, "[]") { // Repeated field
+					validationErrors += validateCount(v, &fileFormat, structName, field, i == len(tempStructDef.Fields)-1)
+				} else if app_structs.IsNumericType(field.Type) {
+					if field.Length != "" {
+						v.warnf(fieldAt(structName, field.Name, "length"), "struct '%s': field '%s' of fixed-size type '%s' has an unnecessary 'Length: %s'. It will be ignored during generation.", structName, field.Name, field.Type, field.Length)
+					}
+				} else if fileFormat.IsStructType(field.Type) { // Nested struct defined in this file
+					if field.Length != "" {
+						v.warnf(fieldAt(structName, field.Name, "length"), "struct '%s': field '%s' of struct type '%s' has an unnecessary 'Length: %s'. It will be ignored during generation.", structName, field.Name, field.Type, field.Length)
 					}
+				} else {
+					v.errorf(fieldAt(structName, field.Name, "type"), "Validation error in struct '%s': field '%s' has unknown type '%s'. Must be a numeric type, 'string', '[]byte', or a struct defined in this file.", structName, field.Name, field.Type)
+					validationErrors++
+					continue
 				}
+			}
 
-			case "uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64", "float32", "float64":
-				if field.Length != "" {
-					log.Printf("Warning: struct '%s': field '%s' of fixed-size type '%s' has an unnecessary 'Length: %s'. It will be ignored during generation.", structName, field.Name, field.Type, field.Length)
+			// Validate Pad / Truncate (only meaningful for fixed-size string and []byte values)
+			if field.Pad != "" || field.Truncate {
+				if field.Type != "string" && field.Type != "[]byte" {
+					v.warnf(fieldAt(structName, field.Name, "pad"), "struct '%s': field '%s' of type '%s' has 'pad'/'truncate' attributes. They only apply to string and []byte fields and will be ignored.", structName, field.Name, field.Type)
+				} else if padByte, errPad := app_structs.ParsePadByte(field.Pad); errPad != nil {
+					v.errorf(fieldAt(structName, field.Name, "pad"), "Validation error in struct '%s': field '%s': %v", structName, field.Name, errPad)
+					validationErrors++
+				} else if field.Pad != "" {
+					if canonical := fmt.Sprintf("0x%02X", padByte); canonical != field.Pad {
+						v.infof(fieldAt(structName, field.Name, "pad"), "Reforming struct '%s': field '%s' 'pad: %q' to '%s'.", structName, field.Name, field.Pad, canonical)
+						field.Pad = canonical
+						reformationsMade++
+					}
 				}
-			default: // Custom struct types
-				if field.Length != "" {
-					log.Printf("Warning: struct '%s': field '%s' of custom type '%s' has a 'Length: %s' specification. Its usage depends on custom logic.", structName, field.Name, field.Type, field.Length)
+			}
+
+			// Validate per-field Endian override
+			if field.Endian != "" {
+				normalized, errEndian := app_structs.NormalizeEndian(field.Endian)
+				if errEndian != nil {
+					v.errorf(fieldAt(structName, field.Name, "endian"), "Validation error in struct '%s': field '%s': %v", structName, field.Name, errEndian)
+					validationErrors++
+				} else {
+					if normalized != field.Endian {
+						v.infof(fieldAt(structName, field.Name, "endian"), "Reforming struct '%s': field '%s' 'endian: %s' to '%s'.", structName, field.Name, field.Endian, normalized)
+						field.Endian = normalized
+						reformationsMade++
+					}
+					isPascal := strings.HasPrefix(strings.ToLower(strings.TrimSpace(field.Encoding)), "pascal") // Byte order of the length prefix
+					if field.Type == "[]byte" || (field.Type == "string" && !isPascal) {
+						v.warnf(fieldAt(structName, field.Name, "endian"), "struct '%s': field '%s' of type '%s' has an 'endian' attribute. Byte order does not apply to raw bytes and it will be ignored.", structName, field.Name, field.Type)
+					}
 				}
 			}
 
@@ -208,40 +406,611 @@ func ValidateAndReformYAML(originalYAMLPath, outputDir string) (string, error) {
 			if field.IsConditional() {
 				trimmedCondition := strings.TrimSpace(field.Condition)
 				if trimmedCondition == "" {
-					log.Printf("ERROR: Validation error in struct '%s': conditional field '%s' has an empty 'Condition'", structName, field.Name)
+					v.errorf(fieldAt(structName, field.Name, "condition"), "Validation error in struct '%s': conditional field '%s' has an empty 'Condition'", structName, field.Name)
 					validationErrors++
 				}
 			}
 
+			// Validate Value: a constant the field must hold
+			if field.Value != "" {
+				if errs := validateValue(v, &fileFormat, structName, field); errs > 0 {
+					validationErrors += errs
+				} else if literal, _ := field.ValueLiteral(); app_structs.IsNumericType(field.Type) && literal != field.Value {
+					v.infof(fieldAt(structName, field.Name, "value"), "Reforming struct '%s': field '%s' 'value: %s' to '%s'.", structName, field.Name, field.Value, literal)
+					field.Value = literal
+					reformationsMade++
+				}
+			}
+
 			// Validate Tags (existing logic)
 			if field.Tags != "" {
-				log.Printf("Info: Field '%s.%s' has tags: `%s`", structName, field.Name, field.Tags)
+				v.infof(fieldAt(structName, field.Name, "tags"), "Field '%s.%s' has tags: `%s`", structName, field.Name, field.Tags)
 			}
 		}
 		fileFormat.Structs[structName] = tempStructDef // Update map with potentially modified struct
 	}
 
+	validationErrors += validateLayout(v, &fileFormat)
+	validationErrors += validateContexts(v, &fileFormat)
+	validationErrors += validateFunctions(v, &fileFormat)
+	validationErrors += validateBitFields(v, &fileFormat)
+	validationErrors += validateExpressions(v, &fileFormat)
+
+	// Nested struct fields are embedded by value, so a cycle would produce a
+	// Go type of infinite size.
+	if cycle := findStructCycle(&fileFormat); cycle != nil {
+		v.errorf(structAt(cycle[0], ""), "Validation error: structs contain themselves by value: %s", strings.Join(cycle, " -> "))
+		validationErrors++
+	}
+
 	if validationErrors > 0 {
-		return "", fmt.Errorf("found %d critical validation error(s) in %s (after key normalization). Please fix the original YAML", validationErrors, originalYAMLPath)
+		return nil, v.diagnostics, fmt.Errorf("found %d critical validation error(s) in %s (after key normalization). Please fix the original YAML", validationErrors, originalYAMLPath)
 	}
 	if reformationsMade > 0 {
-		log.Printf("Made %d value reformation(s) to the YAML data for %s.", reformationsMade, originalYAMLPath)
+		v.logger.Printf("Made %d value reformation(s) to the YAML data for %s.", reformationsMade, originalYAMLPath)
 	} else {
-		log.Printf("YAML value validation complete for %s. No value reformations needed.", originalYAMLPath)
+		v.logger.Printf("YAML value validation complete for %s. No value reformations needed.", originalYAMLPath)
 	}
 
 	// --- 8. Marshal the final validated/reformed struct back to YAML ---
 	finalYamlData, err := yaml.Marshal(&fileFormat) // Marshal the validated struct
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal final reformed YAML data for %s: %w", originalYAMLPath, err)
+		return nil, v.diagnostics, fmt.Errorf("failed to marshal final reformed YAML data for %s: %w", originalYAMLPath, err)
 	}
+	return finalYamlData, v.diagnostics, nil
+}
 
-	// --- 9. Save the final YAML ---
-	err = ioutil.WriteFile(reformedYamlPath, finalYamlData, 0644)
+
+// reportUnknownKeys logs every key of the source that matches no attribute,
+// with a suggestion when it looks like a misspelling. Unknown keys are errors
+// unless opts allows them. It returns the number of validation errors found.
+func reportUnknownKeys(v *validation, opts ValidationOptions) int {
+	errs := 0
+	for _, unknown := range findUnknownKeys(v.locator.root, reflect.TypeOf(app_structs.FileFormat{}), "") {
+		at := place{Attribute: unknown.Key, line: unknown.Line, column: unknown.Column}
+		where := "at the top level"
+		if unknown.Path != "" {
+			where = "in " + unknown.Path
+		}
+		hint := ""
+		if unknown.Suggestion != "" {
+			hint = fmt.Sprintf(" Did you mean '%s'?", unknown.Suggestion)
+		}
+		if opts.AllowUnknownKeys {
+			v.warnf(at, "Unknown key '%s' %s will be ignored.%s", unknown.Key, where, hint)
+			continue
+		}
+		v.errorf(at, "Validation error: unknown key '%s' %s.%s", unknown.Key, where, hint)
+		errs++
+	}
+	return errs
+}
+
+// validateEncoding checks the encoding and max_length attributes of a string
+// field, normalizing the encoding name in place. It returns the number of
+// validation errors found and of reformations made.
+func validateEncoding(v *validation, structName string, field *app_structs.Field) (errs, reformations int) {
+	normalized, err := app_structs.NormalizeEncoding(field.Encoding)
 	if err != nil {
-		return "", fmt.Errorf("failed to write final reformed YAML file '%s': %w", reformedYamlPath, err)
+		v.errorf(fieldAt(structName, field.Name, "encoding"), "Validation error in struct '%s': field '%s': %v", structName, field.Name, err)
+		return 1, 0
+	}
+	if normalized != field.Encoding {
+		v.infof(fieldAt(structName, field.Name, "encoding"), "Reforming struct '%s': field '%s' 'encoding: %s' to '%s'.", structName, field.Name, field.Encoding, normalized)
+		field.Encoding = normalized
+		reformations++
+	}
+	if field.Encoding != "" && field.Type != "string" {
+		v.errorf(fieldAt(structName, field.Name, "encoding"), "Validation error in struct '%s': field '%s' of type '%s' has an 'encoding'. Encodings only apply to string fields.", structName, field.Name, field.Type)
+		return 1, reformations
+	}
+	if field.MaxLength < 0 {
+		v.errorf(fieldAt(structName, field.Name, "max_length"), "Validation error in struct '%s': field '%s' has negative 'max_length: %d'", structName, field.Name, field.MaxLength)
+		return 1, reformations
 	}
-	log.Printf("Saved validated/reformed YAML to: %s", reformedYamlPath)
+	if !field.IsSelfDelimited() {
+		if field.MaxLength != 0 {
+			v.warnf(fieldAt(structName, field.Name, "max_length"), "struct '%s': field '%s' has 'max_length' but no cstring/pascal encoding. It will be ignored; 'length' already fixes the size.", structName, field.Name)
+		}
+		return 0, reformations
+	}
+	if field.Length != "" {
+		v.errorf(fieldAt(structName, field.Name, "length"), "Validation error in struct '%s': field '%s' with encoding '%s' stores its own size and cannot have a 'Length'. Use 'max_length' to limit it.", structName, field.Name, field.Encoding)
+		return 1, reformations
+	}
+	if field.Encoding == app_structs.EncodingPascal8 && field.MaxLength > 255 {
+		v.warnf(fieldAt(structName, field.Name, "max_length"), "struct '%s': field '%s' has 'max_length: %d', but a pascal8 string cannot be longer than 255 bytes.", structName, field.Name, field.MaxLength)
+	}
+	return 0, reformations
+}
 
-	return reformedYamlPath, nil
+// validateValue checks the constant value of a field: it must fit a numeric
+// field, be one of the values of a strict enum, and have exactly the length of
+// a string or []byte field. It returns the number of validation errors found.
+func validateValue(v *validation, fileFormat *app_structs.FileFormat, structName string, field *app_structs.Field) int {
+	if field.IsRepeated() || fileFormat.IsStructType(field.Type) {
+		v.errorf(fieldAt(structName, field.Name, "value"), "Validation error in struct '%s': field '%s' of type '%s' has a 'value'. Constant values only apply to numeric, string and []byte fields.", structName, field.Name, field.Type)
+		return 1
+	}
+	literal, err := field.ValueLiteral()
+	if err != nil {
+		v.errorf(fieldAt(structName, field.Name, "value"), "Validation error in struct '%s': field '%s': %v", structName, field.Name, err)
+		return 1
+	}
+	if enum, ok := fileFormat.Enums[field.Enum]; ok && enum.Strict {
+		number, _ := strconv.ParseInt(literal, 0, 64)
+		declared := false
+		for _, value := range enum.Values {
+			declared = declared || value.Value == number
+		}
+		if !declared {
+			v.errorf(fieldAt(structName, field.Name, "value"), "Validation error in struct '%s': field '%s' has 'value: %s', which is not a value of strict enum '%s'.", structName, field.Name, field.Value, field.Enum)
+			return 1
+		}
+	}
+	if field.Type != "string" && field.Type != "[]byte" {
+		return 0
+	}
+	if field.Encoding != "" {
+		v.errorf(fieldAt(structName, field.Name, "value"), "Validation error in struct '%s': field '%s' has both a 'value' and 'encoding: %s'. A constant string is stored as is, with a fixed length.", structName, field.Name, field.Encoding)
+		return 1
+	}
+	value, _ := field.ValueBytes() // Checked by ValueLiteral
+	if length, errConv := strconv.Atoi(field.Length); errConv != nil || length != len(value) {
+		v.errorf(fieldAt(structName, field.Name, "value"), "Validation error in struct '%s': field '%s' has a %d-byte 'value' but 'length: %s'. Omit the length to take it from the value.", structName, field.Name, len(value), field.Length)
+		return 1
+	}
+	return 0
+}
+
+// validateCount checks a repeated ([]T) field: the element type must be numeric
+// or a struct from this file, and Count must be a positive integer, an
+// expression, or "eof". It normalizes the "eof" keyword in place and returns
+// the number of validation errors found.
+func validateCount(v *validation, fileFormat *app_structs.FileFormat, structName string, field *app_structs.Field, isLastField bool) int {
+	elemType := field.ElementType()
+	if !app_structs.IsNumericType(elemType) && !fileFormat.IsStructType(elemType) {
+		v.errorf(fieldAt(structName, field.Name, "type"), "Validation error in struct '%s': field '%s' has unsupported element type '%s'. Repeated fields must be slices of a numeric type or of a struct defined in this file.", structName, field.Name, elemType)
+		return 1
+	}
+	if field.Length != "" {
+		v.warnf(fieldAt(structName, field.Name, "length"), "struct '%s': repeated field '%s' has an unnecessary 'Length: %s'. It will be ignored during generation; use 'Count' for the number of elements.", structName, field.Name, field.Length)
+	}
+
+	trimmedCount := strings.TrimSpace(field.Count)
+	switch {
+	case trimmedCount == "":
+		v.errorf(fieldAt(structName, field.Name, "count"), "Validation error in struct '%s': field '%s' of slice type '%s' requires a 'Count' specification (a number, an expression, or '%s').", structName, field.Name, field.Type, app_structs.CountEOF)
+		return 1
+	case strings.EqualFold(trimmedCount, app_structs.CountEOF):
+		field.Count = app_structs.CountEOF
+		if !isLastField {
+			v.warnf(fieldAt(structName, field.Name, "count"), "struct '%s': field '%s' repeats until end of stream, so fields declared after it will never be read.", structName, field.Name)
+		}
+	default:
+		if countInt, errConv := strconv.Atoi(trimmedCount); errConv == nil {
+			if countInt <= 0 {
+				v.errorf(fieldAt(structName, field.Name, "count"), "Validation error in struct '%s': field '%s' has invalid non-positive integer 'Count: %s'", structName, field.Name, field.Count)
+				return 1
+			}
+		} else if !IsValidLengthExpression(trimmedCount) {
+			v.errorf(fieldAt(structName, field.Name, "count"), "Validation error in struct '%s': field '%s' has invalid 'Count: %s'. Must be a positive integer, a valid expression, or '%s'", structName, field.Name, field.Count, app_structs.CountEOF)
+			return 1
+		}
+		field.Count = trimmedCount
+	}
+	return 0
+}
+
+// validateLayout checks the top-level layout: every entry must name a struct
+// defined in this file, at most once. The generated ReadFile passes the File
+// as the context of each part, so ctx. references of a part must name a part
+// read before it. It normalizes entries in place and returns the number of
+// validation errors found.
+func validateLayout(v *validation, fileFormat *app_structs.FileFormat) int {
+	if !fileFormat.HasLayout() {
+		return 0
+	}
+	errs := 0
+	if fileFormat.IsStructType(app_structs.FileTypeName) {
+		v.errorf(keyAt("layout"), "Validation error in layout: struct '%s' conflicts with the generated '%s' type. Rename the struct.", app_structs.FileTypeName, app_structs.FileTypeName)
+		errs++
+	}
+	position := make(map[string]int, len(fileFormat.Layout))
+	for i := range fileFormat.Layout {
+		part := strings.TrimSpace(fileFormat.Layout[i])
+		fileFormat.Layout[i] = part
+		switch {
+		case !fileFormat.IsStructType(part):
+			v.errorf(keyAt("layout", i), "Validation error in layout: entry '%s' is not a struct defined in this file.", part)
+			errs++
+		case position[part] > 0:
+			v.errorf(keyAt("layout", i), "Validation error in layout: struct '%s' is listed more than once.", part)
+			errs++
+		default:
+			position[part] = i + 1 // 1-based so that 0 means "not in layout"
+		}
+	}
+	if errs > 0 {
+		return errs
+	}
+
+	for i, part := range fileFormat.Layout {
+		for _, field := range fileFormat.Structs[part].Fields {
+			for _, expr := range []string{field.Length, field.Count, field.Condition} {
+				for _, ref := range ExpressionReferences(expr) {
+					path := strings.Split(ref, ".")
+					if path[0] != "ctx" {
+						continue
+					}
+					target := path[1]
+					switch pos := position[target]; {
+					case pos-1 == i:
+						v.errorf(fieldAt(part, field.Name, ""), "Validation error in struct '%s': field '%s' refers to '%s', but '%s' is the struct being read. Use 's.' for its own fields.", part, field.Name, ref, target)
+						errs++
+					case pos-1 > i:
+						v.errorf(fieldAt(part, field.Name, ""), "Validation error in struct '%s': field '%s' refers to '%s', but '%s' is read after '%s' in the layout.", part, field.Name, ref, target, part)
+						errs++
+					}
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// decodeContextHook lets mapstructure decode a struct's context attribute,
+// which is either a type name or a list of fields (see app_structs.Context).
+func decodeContextHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(app_structs.Context{}) {
+		return data, nil
+	}
+	switch from.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"Type": data}, nil
+	case reflect.Slice:
+		return map[string]interface{}{"Fields": data}, nil
+	}
+	return data, nil
+}
+
+// validateContexts checks the context declared by each struct: a struct of
+// this file (or File for layout structs), or a list of numeric/string fields.
+// Structs with a context must be read with it, so the enclosing struct of a
+// nested field and ReadFile for layout structs must match the declaration.
+// It returns the number of validation errors found.
+func validateContexts(v *validation, fileFormat *app_structs.FileFormat) int {
+	errs := 0
+	inLayout := make(map[string]bool, len(fileFormat.Layout))
+	for _, part := range fileFormat.Layout {
+		inLayout[part] = true
+	}
+	for _, structName := range fileFormat.OrderedStructNames() {
+		structDef := fileFormat.Structs[structName]
+		structDef.Context.Type = strings.TrimSpace(structDef.Context.Type)
+		fileFormat.Structs[structName] = structDef
+		context := structDef.Context
+		contextType := fileFormat.ContextType(structName)
+		switch {
+		case context.IsZero():
+			continue
+		case context.Type != "":
+			if context.Type == app_structs.FileTypeName && !fileFormat.HasLayout() {
+				v.errorf(structAt(structName, "context"), "Validation error in struct '%s': 'context: %s' requires a 'layout'.", structName, context.Type)
+				errs++
+				continue
+			}
+			if context.Type != app_structs.FileTypeName && !fileFormat.IsStructType(context.Type) {
+				v.errorf(structAt(structName, "context"), "Validation error in struct '%s': context '%s' is not a struct defined in this file (or '%s').", structName, context.Type, app_structs.FileTypeName)
+				errs++
+				continue
+			}
+		default:
+			if fileFormat.IsStructType(contextType) {
+				v.errorf(structAt(structName, "context"), "Validation error in struct '%s': its context type '%s' conflicts with a struct of the same name. Rename the struct.", structName, contextType)
+				errs++
+			}
+			seen := make(map[string]bool, len(context.Fields))
+			for _, field := range context.Fields {
+				switch {
+				case strings.TrimSpace(field.Name) == "":
+					v.errorf(structAt(structName, "context"), "Validation error in struct '%s': a context field is missing a 'name'.", structName)
+					errs++
+				case seen[field.Name]:
+					v.errorf(structAt(structName, "context").child(named(field.Name)), "Validation error in struct '%s': context field '%s' is declared more than once.", structName, field.Name)
+					errs++
+				case !app_structs.IsNumericType(field.Type) && field.Type != "string":
+					v.errorf(structAt(structName, "context").child(named(field.Name)), "Validation error in struct '%s': context field '%s' has type '%s'. Context fields must be numeric or 'string'.", structName, field.Name, field.Type)
+					errs++
+				}
+				seen[field.Name] = true
+			}
+		}
+
+		// Everything that reads the struct must pass a ctx of the declared type
+		if inLayout[structName] && contextType != app_structs.FileTypeName {
+			v.errorf(structAt(structName, "context"), "Validation error in struct '%s': it is in the layout, so ReadFile passes the %s as ctx, but it declares context '%s'.", structName, app_structs.FileTypeName, contextType)
+			errs++
+		}
+		for _, parent := range fileFormat.OrderedStructNames() {
+			for _, field := range fileFormat.Structs[parent].Fields {
+				if (field.Type == structName || field.IsRepeated() && field.ElementType() == structName) && parent != contextType {
+					v.errorf(structAt(structName, "context"), "Validation error in struct '%s': field '%s' of '%s' reads it with ctx *%s, but it declares context '%s'.", structName, field.Name, parent, parent, contextType)
+					errs++
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateBitFields checks the bits of every field: they need an unsigned
+// integer field, names that don't clash with the struct's fields, methods or
+// other bit fields (each gets a getter and a Set<Name> setter), and ranges
+// that fit the field without overlapping. It returns the number of validation
+// errors found.
+func validateBitFields(v *validation, fileFormat *app_structs.FileFormat) int {
+	errs := 0
+	for _, structName := range fileFormat.OrderedStructNames() {
+		fields := fileFormat.Structs[structName].Fields
+		methods := map[string]string{"Read": "a method", "Write": "a method"}
+		for _, field := range fields {
+			methods[field.Name] = "a field"
+		}
+		for _, field := range fields {
+			if len(field.Bits) == 0 {
+				continue
+			}
+			switch field.Type {
+			case "uint8", "uint16", "uint32", "uint64":
+			default:
+				v.errorf(fieldAt(structName, field.Name, "bits"), "Validation error in struct '%s': field '%s' of type '%s' has 'bits'. Bit fields need an unsigned integer type (uint8 to uint64).", structName, field.Name, field.Type)
+				errs++
+				continue
+			}
+			if field.IsRepeated() {
+				v.errorf(fieldAt(structName, field.Name, "bits"), "Validation error in struct '%s': repeated field '%s' cannot have 'bits'.", structName, field.Name)
+				errs++
+				continue
+			}
+
+			size, _ := strconv.Atoi(strings.TrimPrefix(field.Type, "uint"))
+			used := uint64(0)
+			for _, bitField := range field.Bits {
+				lo, hi, errRange := bitField.Range()
+				mask := uint64(0)
+				if errRange == nil && hi < size {
+					mask = (uint64(1)<<uint(hi-lo+1) - 1) << uint(lo)
+				}
+				switch {
+				case !token.IsIdentifier(bitField.Name):
+					v.errorf(fieldAt(structName, field.Name, "bits").child(named(bitField.Name)), "Validation error in struct '%s': field '%s' has a bit field with invalid name '%s'.", structName, field.Name, bitField.Name)
+					errs++
+				case methods[bitField.Name] != "" || methods["Set"+bitField.Name] != "":
+					name := bitField.Name
+					if methods[name] == "" {
+						name = "Set" + name
+					}
+					v.errorf(fieldAt(structName, field.Name, "bits").child(named(bitField.Name)), "Validation error in struct '%s': field '%s' has bit field '%s', but '%s' is already %s of the struct.", structName, field.Name, bitField.Name, name, methods[name])
+					errs++
+				case errRange != nil:
+					v.errorf(fieldAt(structName, field.Name, "bits").child(named(bitField.Name)), "Validation error in struct '%s': field '%s' bit field '%s': %v", structName, field.Name, bitField.Name, errRange)
+					errs++
+				case lo < 0 || hi >= size:
+					v.errorf(fieldAt(structName, field.Name, "bits").child(named(bitField.Name)), "Validation error in struct '%s': field '%s' bit field '%s' has bits '%s', outside the %d bits of %s.", structName, field.Name, bitField.Name, bitField.Bits, size, field.Type)
+					errs++
+				case used&mask != 0:
+					v.errorf(fieldAt(structName, field.Name, "bits").child(named(bitField.Name)), "Validation error in struct '%s': field '%s' bit field '%s' overlaps another bit field.", structName, field.Name, bitField.Name)
+					errs++
+				}
+				used |= mask
+				methods[bitField.Name] = "a bit field accessor"
+				methods["Set"+bitField.Name] = "a bit field accessor"
+			}
+		}
+	}
+	return errs
+}
+
+// validateEnums checks the enums section: each enum needs a name that isn't
+// taken by another type, an integer type, and values with unique names and
+// distinct numbers (String switches on them) that fit the type. It returns the
+// number of validation errors found.
+func validateEnums(v *validation, fileFormat *app_structs.FileFormat) int {
+	errs := 0
+	names := make([]string, 0, len(fileFormat.Enums))
+	for name := range fileFormat.Enums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	constants := make(map[string]string) // Generated constant name -> enum declaring it
+	for _, name := range names {
+		enum := fileFormat.Enums[name]
+		enum.Type = strings.TrimSpace(enum.Type)
+		fileFormat.Enums[name] = enum
+
+		_, isType := fileFormat.TypeFields(name)
+		switch {
+		case !token.IsIdentifier(name):
+			v.errorf(keyAt("enums", name), "Validation error in enums: '%s' is not a valid Go type name.", name)
+			errs++
+			continue
+		case isType || name == app_structs.FileTypeName:
+			v.errorf(keyAt("enums", name), "Validation error in enums: enum '%s' has the name of a generated struct type.", name)
+			errs++
+			continue
+		case !app_structs.IsNumericType(enum.Type) || strings.HasPrefix(enum.Type, "float"):
+			v.errorf(keyAt("enums", name, "type"), "Validation error in enums: enum '%s' has type '%s'. Must be an integer type (e.g., 'uint8', 'int32').", name, enum.Type)
+			errs++
+			continue
+		case len(enum.Values) == 0:
+			v.errorf(keyAt("enums", name, "values"), "Validation error in enums: enum '%s' has no 'values'.", name)
+			errs++
+			continue
+		}
+
+		bitSize, _ := strconv.Atoi(strings.TrimLeft(enum.Type, "uint"))
+		minValue, maxValue := int64(0), uint64(1)<<uint(bitSize)-1
+		if !strings.HasPrefix(enum.Type, "u") {
+			minValue, maxValue = -1<<uint(bitSize-1), 1<<uint(bitSize-1)-1
+		}
+		values := make(map[int64]string)
+		for _, value := range enum.Values {
+			constName := value.ConstName(name)
+			switch {
+			case value.Name == "" || !token.IsIdentifier(constName):
+				v.errorf(keyAt("enums", name, "values", named(value.Name)), "Validation error in enums: enum '%s' has a value with invalid name '%s'.", name, value.Name)
+				errs++
+			case constants[constName] != "":
+				v.errorf(keyAt("enums", name, "values", named(value.Name)), "Validation error in enums: enum '%s' value '%s' generates constant '%s', which enum '%s' already declares.", name, value.Name, constName, constants[constName])
+				errs++
+			case fileFormat.IsStructType(constName):
+				v.errorf(keyAt("enums", name, "values", named(value.Name)), "Validation error in enums: enum '%s' value '%s' generates constant '%s', which is the name of a struct.", name, value.Name, constName)
+				errs++
+			case value.Value < minValue || value.Value > 0 && uint64(value.Value) > maxValue:
+				v.errorf(keyAt("enums", name, "values", named(value.Name), "value"), "Validation error in enums: enum '%s' value '%s' (%d) does not fit in %s.", name, value.Name, value.Value, enum.Type)
+				errs++
+			case values[value.Value] != "":
+				v.errorf(keyAt("enums", name, "values", named(value.Name), "value"), "Validation error in enums: enum '%s' values '%s' and '%s' are both %d.", name, values[value.Value], value.Name, value.Value)
+				errs++
+			}
+			constants[constName] = name
+			if values[value.Value] == "" {
+				values[value.Value] = value.Name
+			}
+		}
+	}
+	return errs
+}
+
+// validateFunctions checks the functions section: each function needs a name
+// usable in expressions that doesn't shadow a built-in function, a Go function
+// to call (qualified by its package name if it comes from an import) and a
+// non-negative number of arguments. It returns the number of validation
+// errors found.
+func validateFunctions(v *validation, fileFormat *app_structs.FileFormat) int {
+	errs := 0
+	builtins := GetExpressionFunctions()
+	names := make([]string, 0, len(fileFormat.Functions))
+	for name := range fileFormat.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		function := fileFormat.Functions[name]
+		function.Go = strings.TrimSpace(function.Go)
+		function.Import = strings.TrimSpace(function.Import)
+		fileFormat.Functions[name] = function
+
+		qualifier, goName, qualified := strings.Cut(function.Go, ".")
+		if !qualified {
+			goName = qualifier
+		}
+		switch {
+		case !token.IsIdentifier(name) || name == "s" || name == "ctx":
+			v.errorf(keyAt("functions", name), "Validation error in functions: '%s' is not a valid function name.", name)
+			errs++
+		case builtins[name] != nil:
+			v.errorf(keyAt("functions", name), "Validation error in functions: '%s' is a built-in expression function and cannot be redeclared.", name)
+			errs++
+		case function.Go == "":
+			v.errorf(keyAt("functions", name, "go"), "Validation error in functions: function '%s' is missing 'go', the Go function to call.", name)
+			errs++
+		case !token.IsIdentifier(goName) || qualified && !token.IsIdentifier(qualifier):
+			v.errorf(keyAt("functions", name, "go"), "Validation error in functions: function '%s' has invalid 'go: %s'. Use a function name (e.g., 'alignTo') or a package-qualified name (e.g., 'fmtutil.AlignTo').", name, function.Go)
+			errs++
+		case qualified && function.Import == "":
+			v.errorf(keyAt("functions", name, "go"), "Validation error in functions: function '%s' calls '%s' but has no 'import' for package '%s'.", name, function.Go, qualifier)
+			errs++
+		case !qualified && function.Import != "":
+			v.errorf(keyAt("functions", name, "import"), "Validation error in functions: function '%s' imports '%s', so 'go' must be qualified by its package name (e.g., 'pkg.%s').", name, function.Import, strings.Title(goName))
+			errs++
+		case qualified && !token.IsExported(goName):
+			v.errorf(keyAt("functions", name, "go"), "Validation error in functions: function '%s' refers to unexported '%s' of package '%s'.", name, goName, function.Import)
+			errs++
+		case function.Args < 0:
+			v.errorf(keyAt("functions", name, "args"), "Validation error in functions: function '%s' has negative 'args: %d'.", name, function.Args)
+			errs++
+		}
+	}
+	return errs
+}
+
+// validateExpressions checks every length, count and condition expression
+// with CheckExpression, reporting errors at their position in the original YAML.
+// It returns the number of validation errors found.
+func validateExpressions(v *validation, fileFormat *app_structs.FileFormat) int {
+	errs := 0
+	for _, structName := range fileFormat.OrderedStructNames() {
+		for _, field := range fileFormat.Structs[structName].Fields {
+			type source struct {
+				key, expr string
+			}
+			var sources []source
+			if field.IsRepeated() {
+				if field.IsExpressionCount() {
+					sources = append(sources, source{"count", field.Count})
+				}
+			} else if field.IsExpressionLength() && field.Length != "NEEDS_MANUAL_LENGTH" {
+				sources = append(sources, source{"length", field.Length})
+			}
+			if field.IsConditional() && strings.TrimSpace(field.Condition) != "" {
+				sources = append(sources, source{"condition", field.Condition})
+			}
+			for _, src := range sources {
+				warning, err := CheckExpression(fileFormat, structName, src.expr, src.key == "condition")
+				if err != nil {
+					v.errorf(fieldAt(structName, field.Name, src.key), "Validation error in struct '%s': field '%s' %s: %v", structName, field.Name, src.key, err)
+					errs++
+				} else if warning != nil {
+					v.warnf(fieldAt(structName, field.Name, src.key), "struct '%s': field '%s' %s: %v", structName, field.Name, src.key, warning)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// findStructCycle returns the struct names forming a cycle of nested struct
+// fields (e.g. [A B A]), or nil if nesting is acyclic.
+func findStructCycle(fileFormat *app_structs.FileFormat) []string {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case inProgress:
+			for i, n := range path {
+				if n == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case done:
+			return nil
+		}
+		state[name] = inProgress
+		path = append(path, name)
+		for _, field := range fileFormat.Structs[name].Fields {
+			if fileFormat.IsStructType(field.Type) {
+				if cycle := visit(field.Type); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return nil
+	}
+
+	for _, name := range fileFormat.OrderedStructNames() {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
 }
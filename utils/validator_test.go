@@ -0,0 +1,788 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validate writes source to a temporary file and validates it, returning
+// the reformed YAML and what was logged.
+func validate(t *testing.T, source string) (string, string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "test.yml")
+	if err := ioutil.WriteFile(sourcePath, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	logger := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(logger)
+	reformedPath, err := ValidateAndReformYAML(sourcePath, filepath.Join(dir, "out"), ValidationOptions{})
+	if err != nil {
+		return "", logs.String(), err
+	}
+	reformed, err := ioutil.ReadFile(reformedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(reformed), logs.String(), nil
+}
+
+func TestValidateReformations(t *testing.T) {
+	tests := []struct {
+		name             string
+		source           string
+		wantReformed     []string // Substrings of the reformed YAML
+		wantReformations int
+	}{
+		{
+			name:             "nothing to reform",
+			source:           "structs:\n  A:\n    fields:\n      - {name: X, type: uint16, endian: big}\n",
+			wantReformed:     []string{"endian: big"},
+			wantReformations: 0,
+		},
+		{
+			name:             "endian",
+			source:           "endian: BIG\nstructs:\n  A:\n    fields:\n      - {name: X, type: uint16, endian: Little}\n",
+			wantReformed:     []string{"\nendian: big\n", "endian: little\n"},
+			wantReformations: 2,
+		},
+		{
+			name:             "context",
+			source:           "structs:\n  A:\n    context:\n      - {name: Length, type: uint16}\n    fields:\n      - {name: Data, type: \"[]byte\", length: \"ctx.Length\"}\n",
+			wantReformed:     []string{"context:\n    - name: Length\n      type: uint16\n"},
+			wantReformations: 0,
+		},
+		{
+			name:             "enum type",
+			source:           "enums:\n  Kind:\n    type: uint16\n    values:\n      - {name: A, value: 1}\nstructs:\n  A:\n    fields:\n      - {name: K, enum: Kind}\n",
+			wantReformed:     []string{"- name: K\n      type: uint16\n"},
+			wantReformations: 1,
+		},
+		{
+			name:             "constant values",
+			source:           "structs:\n  A:\n    fields:\n      - {name: Signature, type: string, magic: BM}\n      - {name: Marker, type: uint16, value: 65496}\n      - {name: PNG, type: \"[]byte\", value: \"0x89504E47\"}\n",
+			wantReformed:     []string{"length: \"2\"\n      value: BM\n", "value: \"0xFFD8\"", "length: \"4\"\n      value: \"0x89504E47\"\n"},
+			wantReformations: 4,
+		},
+		{
+			name:             "pad",
+			source:           "structs:\n  A:\n    fields:\n      - {name: P, type: string, length: 4, pad: \" \"}\n      - {name: Q, type: \"[]byte\", length: 2, pad: 255}\n",
+			wantReformed:     []string{"pad: \"0x20\"", "pad: \"0xFF\""},
+			wantReformations: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reformed, logs, err := validate(t, tt.source)
+			if err != nil {
+				t.Fatalf("ValidateAndReformYAML() error = %v\n%s", err, logs)
+			}
+			for _, want := range tt.wantReformed {
+				if !strings.Contains(reformed, want) {
+					t.Errorf("reformed YAML doesn't contain %q:\n%s", want, reformed)
+				}
+			}
+			summary := "No value reformations needed"
+			if tt.wantReformations > 0 {
+				summary = fmt.Sprintf("Made %d value reformation(s)", tt.wantReformations)
+			}
+			if !strings.Contains(logs, summary) {
+				t.Errorf("log doesn't contain %q:\n%s", summary, logs)
+			}
+
+			// Reformed YAML is stable: validating it again reforms nothing
+			again, logs, err := validate(t, reformed)
+			if err != nil {
+				t.Fatalf("validating the reformed YAML: %v\n%s", err, logs)
+			}
+			if again != reformed || !strings.Contains(logs, "No value reformations needed") {
+				t.Errorf("validating the reformed YAML reformed it again:\n%s\n%s", again, logs)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	field := func(attributes string) string {
+		return "structs:\n  A:\n    fields:\n      - {name: Num, type: uint8}\n      - {name: X, " + attributes + "}\n"
+	}
+	tests := []struct {
+		name    string
+		source  string
+		wantLog string // Empty if the source is valid
+	}{
+		{
+			name:   "nested struct",
+			source: "structs:\n  A:\n    fields:\n      - {name: B, type: B}\n  B:\n    fields:\n      - {name: X, type: uint8}\n",
+		},
+		{
+			name:    "unknown type",
+			source:  "structs:\n  A:\n    fields:\n      - {name: X, type: float128}\n",
+			wantLog: "field 'X' has unknown type 'float128'",
+		},
+		{
+			name:    "cycle",
+			source:  "structs:\n  A:\n    fields:\n      - {name: X, type: B}\n  B:\n    fields:\n      - {name: Y, type: A}\n",
+			wantLog: "structs contain themselves by value: A -> B -> A",
+		},
+		{
+			name:    "struct containing itself",
+			source:  "structs:\n  A:\n    fields:\n      - {name: X, type: A}\n",
+			wantLog: "structs contain themselves by value: A -> A",
+		},
+		{name: "literal count", source: field("type: \"[]uint16\", count: 4")},
+		{name: "expression count", source: field("type: \"[]uint16\", count: \"s.Num * 2\"")},
+		{name: "count to end of stream", source: field("type: \"[]uint16\", count: eof")},
+		{
+			name:    "invalid endian",
+			source:  field("type: uint16, endian: middle"),
+			wantLog: "invalid endian 'middle'",
+		},
+		{
+			name:    "invalid format endian",
+			source:  "endian: middle\n" + field("type: uint16"),
+			wantLog: "invalid endian 'middle'",
+		},
+		{
+			name:    "invalid pad",
+			source:  field("type: string, length: 4, pad: ab"),
+			wantLog: "invalid pad 'ab'",
+		},
+		{name: "cstring", source: field("type: string, encoding: cstring, max_length: 8")},
+		{name: "pascal", source: field("type: string, encoding: pascal16, endian: big")},
+		{
+			name:    "invalid encoding",
+			source:  field("type: string, encoding: utf9"),
+			wantLog: "invalid encoding 'utf9'",
+		},
+		{
+			name:    "encoding on []byte",
+			source:  field("type: \"[]byte\", length: 2, encoding: cstring"),
+			wantLog: "Encodings only apply to string fields",
+		},
+		{
+			name:    "length with a self-delimited encoding",
+			source:  field("type: string, length: 4, encoding: pascal"),
+			wantLog: "stores its own size and cannot have a 'Length'",
+		},
+		{
+			name:    "negative max_length",
+			source:  field("type: string, encoding: cstring, max_length: -1"),
+			wantLog: "negative 'max_length: -1'",
+		},
+		{
+			name:    "string without length or encoding",
+			source:  field("type: string"),
+			wantLog: "requires a 'Length' specification (or a cstring/pascal 'encoding')",
+		},
+		{
+			name:   "layout",
+			source: "layout: [A, B]\n" + field("type: uint8") + "  B:\n    fields:\n      - {name: Data, type: \"[]byte\", length: \"ctx.A.Num\"}\n",
+		},
+		{
+			name:    "layout entry that is not a struct",
+			source:  "layout: [A, Nope]\n" + field("type: uint8"),
+			wantLog: "entry 'Nope' is not a struct defined in this file",
+		},
+		{
+			name:    "layout entry listed twice",
+			source:  "layout: [A, A]\n" + field("type: uint8"),
+			wantLog: "struct 'A' is listed more than once",
+		},
+		{
+			name:    "layout with a File struct",
+			source:  "layout: [File]\nstructs:\n  File:\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "struct 'File' conflicts with the generated 'File' type",
+		},
+		{
+			name:    "layout struct referring to itself",
+			source:  "layout: [A]\n" + field("type: \"[]byte\", length: \"ctx.A.Num\""),
+			wantLog: "'A' is the struct being read",
+		},
+		{
+			name:    "layout struct referring to a later struct",
+			source:  "layout: [A, B]\n" + field("type: \"[]byte\", length: \"ctx.B.Size\"") + "  B:\n    fields:\n      - {name: Size, type: uint8}\n",
+			wantLog: "'B' is read after 'A' in the layout",
+		},
+		{
+			name:    "layout struct referring to a missing field",
+			source:  "layout: [B, A]\n" + field("type: \"[]byte\", length: \"ctx.B.Z\"") + "  B:\n    fields:\n      - {name: Size, type: uint8}\n",
+			wantLog: "struct 'B' has no field 'Z'",
+		},
+		{
+			name:    "invalid expressions mode",
+			source:  "expressions: compiled\n" + field("type: uint8"),
+			wantLog: "invalid expressions mode 'compiled'",
+		},
+		{name: "condition referring to ctx", source: field("type: uint8, condition: \"ctx.Num > 0\"")},
+		{name: "condition", source: field("type: uint8, condition: \"(s.Num & 1) != 0\"")},
+		{
+			name:    "misspelled field",
+			source:  field("type: \"[]byte\", length: \"s.Nmu * 2\""),
+			wantLog: "test.yml:5:35: Validation error in struct 'A': field 'X' length: expression 's.Nmu * 2': ",
+		},
+		{
+			name:    "name without s. or ctx.",
+			source:  field("type: \"[]byte\", length: \"Num * 2\""),
+			wantLog: "unknown name 'Num'",
+		},
+		{
+			name:    "reference to a slice",
+			source:  "structs:\n  A:\n    fields:\n      - {name: Items, type: \"[]uint8\", count: 2}\n      - {name: X, type: \"[]byte\", length: \"s.Items\"}\n",
+			wantLog: "only numeric and string fields can be used in expressions",
+		},
+		{
+			name:    "boolean length",
+			source:  field("type: \"[]byte\", length: \"s.Num > 2\""),
+			wantLog: "must be numeric, not boolean",
+		},
+		{
+			name:    "numeric condition",
+			source:  field("type: uint8, condition: \"s.Num\""),
+			wantLog: "test.yml:5:32: Validation error in struct 'A': field 'X' condition: condition 's.Num' must be a boolean expression",
+		},
+		{
+			name:    "misspelled field of a containing struct",
+			source:  "structs:\n  Outer:\n    fields:\n      - {name: Size, type: uint8}\n      - {name: In, type: A}\n" + field("type: \"[]byte\", length: \"ctx.Sise\"")[len("structs:\n"):],
+			wantLog: "'ctx.Sise'",
+		},
+		{
+			name:    "native expression referring to a string",
+			source:  "expressions: native\nstructs:\n  A:\n    fields:\n      - {name: S, type: string, length: 2}\n      - {name: X, type: \"[]byte\", length: \"s.S\"}\n",
+			wantLog: "field 'X'",
+		},
+		{
+			name:    "native condition that is not boolean",
+			source:  "expressions: native\n" + field("type: uint8, condition: \"s.Num\""),
+			wantLog: "must be a boolean expression",
+		},
+		{name: "context struct", source: "structs:\n  Outer:\n    fields:\n      - {name: Size, type: uint8}\n      - {name: In, type: A}\n  A:\n    context: Outer\n    fields:\n      - {name: Data, type: \"[]byte\", length: \"ctx.Size\"}\n"},
+		{name: "context fields", source: "structs:\n  A:\n    context:\n      - {name: Length, type: uint16}\n    fields:\n      - {name: Data, type: \"[]byte\", length: \"ctx.Length - 2\"}\n"},
+		{
+			name:    "misspelled context field",
+			source:  "structs:\n  Outer:\n    fields:\n      - {name: Size, type: uint8}\n      - {name: In, type: A}\n  A:\n    context: Outer\n    fields:\n      - {name: Data, type: \"[]byte\", length: \"ctx.Sise\"}\n",
+			wantLog: "test.yml:9:38: Validation error in struct 'A': field 'Data' length: expression 'ctx.Sise': 'ctx.Sise': struct 'Outer' has no field 'Sise'",
+		},
+		{
+			name:    "misspelled field of a context list",
+			source:  "structs:\n  A:\n    context:\n      - {name: Length, type: uint16}\n    fields:\n      - {name: Data, type: \"[]byte\", length: \"ctx.Lenght - 2\"}\n",
+			wantLog: "struct 'AContext' has no field 'Lenght'",
+		},
+		{
+			name:    "unknown context",
+			source:  "structs:\n  A:\n    context: Header\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "context 'Header' is not a struct defined in this file",
+		},
+		{
+			name:    "File context without a layout",
+			source:  "structs:\n  A:\n    context: File\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "'context: File' requires a 'layout'",
+		},
+		{
+			name:    "context field of a non-numeric type",
+			source:  "structs:\n  A:\n    context:\n      - {name: Data, type: \"[]byte\"}\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "context field 'Data' has type '[]byte'",
+		},
+		{
+			name:    "context field declared twice",
+			source:  "structs:\n  A:\n    context:\n      - {name: Size, type: uint8}\n      - {name: Size, type: uint16}\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "context field 'Size' is declared more than once",
+		},
+		{
+			name:    "context type named like a struct",
+			source:  "structs:\n  A:\n    context:\n      - {name: Size, type: uint8}\n    fields:\n      - {name: X, type: uint8}\n  AContext:\n    fields:\n      - {name: Y, type: uint8}\n",
+			wantLog: "its context type 'AContext' conflicts with a struct of the same name",
+		},
+		{
+			name:    "layout struct with another context",
+			source:  "layout: [A]\nstructs:\n  A:\n    context:\n      - {name: Size, type: uint8}\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "it is in the layout, so ReadFile passes the File as ctx, but it declares context 'AContext'",
+		},
+		{
+			name:    "nested struct with another context",
+			source:  "structs:\n  Outer:\n    fields:\n      - {name: In, type: A}\n  Other:\n    fields:\n      - {name: Num, type: uint8}\n  A:\n    context: Other\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "field 'In' of 'Outer' reads it with ctx *Outer, but it declares context 'Other'",
+		},
+		{name: "declared functions", source: "functions:\n  AlignTo: {go: binutil.AlignTo, import: example.com/binutil, args: 2}\n  Twice: {go: twice, args: 1}\n" + field("type: \"[]byte\", length: \"AlignTo(s.Num, 4) + Twice(s.Num)\"")},
+		{
+			name:    "declared function called with too many arguments",
+			source:  "functions:\n  AlignTo: {go: alignTo, args: 2}\n" + field("type: \"[]byte\", length: \"AlignTo(s.Num, 4, 1)\""),
+			wantLog: "test.yml:7:35: Validation error in struct 'A': field 'X' length: expression 'AlignTo(s.Num, 4, 1)': function 'AlignTo' expects 2 argument(s), got 3",
+		},
+		{
+			name:    "redeclared built-in function",
+			source:  "functions:\n  CalculatePaddedSize: {go: padded, args: 3}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "'CalculatePaddedSize' is a built-in expression function",
+		},
+		{
+			name:    "function without go",
+			source:  "functions:\n  AlignTo: {args: 2}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "function 'AlignTo' is missing 'go'",
+		},
+		{
+			name:    "function with an invalid go name",
+			source:  "functions:\n  AlignTo: {go: align-to, args: 2}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "function 'AlignTo' has invalid 'go: align-to'",
+		},
+		{
+			name:    "qualified function without import",
+			source:  "functions:\n  AlignTo: {go: binutil.AlignTo, args: 2}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "calls 'binutil.AlignTo' but has no 'import' for package 'binutil'",
+		},
+		{
+			name:    "imported function without qualifier",
+			source:  "functions:\n  AlignTo: {go: alignTo, import: example.com/binutil, args: 2}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "so 'go' must be qualified by its package name (e.g., 'pkg.AlignTo')",
+		},
+		{
+			name:    "unexported imported function",
+			source:  "functions:\n  AlignTo: {go: binutil.alignTo, import: example.com/binutil, args: 2}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "refers to unexported 'alignTo' of package 'example.com/binutil'",
+		},
+		{
+			name:    "invalid function name",
+			source:  "functions:\n  ctx: {go: alignTo, args: 2}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "'ctx' is not a valid function name",
+		},
+		{
+			name:    "negative args",
+			source:  "functions:\n  AlignTo: {go: alignTo, args: -1}\n" + field("type: \"[]byte\", length: \"s.Num\""),
+			wantLog: "function 'AlignTo' has negative 'args: -1'",
+		},
+		{
+			name:    "native call to a declared function with too many arguments",
+			source:  "expressions: native\n" + "functions:\n  AlignTo: {go: alignTo, args: 2}\n" + field("type: \"[]byte\", length: \"AlignTo(s.Num, 4, 1)\""),
+			wantLog: "test.yml:8:35: Validation error in struct 'A': field 'X' length: expression 'AlignTo(s.Num, 4, 1)': function 'AlignTo' expects 2 argument(s), got 3",
+		},
+		{name: "built-in functions", source: field("type: \"[]byte\", length: \"align(s.Num, 4) + pad_to(s.Num, 2) + ceil_div(s.Num, 3) + bits(s.Num, 0, 3) + popcount(s.Num) + min(s.Num, 2) + max(s.Num, 2)\"")},
+		{name: "len of a string", source: "structs:\n  A:\n    fields:\n      - {name: Name, type: string, length: 4}\n      - {name: X, type: \"[]byte\", length: \"pad_to(len(s.Name), 8)\"}\n"},
+		{
+			name:    "len of a number",
+			source:  field("type: \"[]byte\", length: \"len(s.Num)\""),
+			wantLog: "'s.Num' is a uint8; len needs a string or slice field",
+		},
+		{
+			name:    "built-in function called with too few arguments",
+			source:  field("type: \"[]byte\", length: \"align(s.Num)\""),
+			wantLog: "align expects 2 argument(s), got 1",
+		},
+		{name: "enum", source: "enums:\n  Kind:\n    type: uint8\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 2}\n" + field("type: uint8, enum: Kind")},
+		{name: "repeated enum", source: "enums:\n  Kind:\n    type: uint8\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 2}\n" + field("type: \"[]uint8\", count: 2, enum: Kind")},
+		{name: "enum in an expression", source: "enums:\n  Kind:\n    type: uint8\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 2}\nstructs:\n  A:\n    fields:\n      - {name: K, enum: Kind}\n      - {name: X, type: uint8, condition: \"s.K == 2\"}\n"},
+		{
+			name:    "unknown enum",
+			source:  field("type: uint8, enum: Kind"),
+			wantLog: "field 'X' refers to unknown enum 'Kind'",
+		},
+		{
+			name:    "field type other than the enum type",
+			source:  "enums:\n  Kind:\n    type: uint16\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 2}\n" + field("type: uint8, enum: Kind"),
+			wantLog: "field 'X' of type 'uint8' refers to enum 'Kind' of type 'uint16'",
+		},
+		{
+			name:    "float enum",
+			source:  "enums:\n  Kind:\n    type: float32\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 2}\n" + field("type: uint8, enum: Kind"),
+			wantLog: "enum 'Kind' has type 'float32'. Must be an integer type",
+		},
+		{
+			name:    "enum without values",
+			source:  "enums:\n  Kind:\n    type: uint8\n" + field("type: uint8, enum: Kind"),
+			wantLog: "enum 'Kind' has no 'values'",
+		},
+		{
+			name:    "enum values with the same number",
+			source:  "enums:\n  Kind:\n    type: uint8\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 1}\n" + field("type: uint8, enum: Kind"),
+			wantLog: "enum 'Kind' values 'Low' and 'High' are both 1",
+		},
+		{
+			name:    "enum value out of range",
+			source:  "enums:\n  Kind:\n    type: uint8\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 256}\n" + field("type: uint8, enum: Kind"),
+			wantLog: "enum 'Kind' value 'High' (256) does not fit in uint8",
+		},
+		{
+			name:    "negative value of an unsigned enum",
+			source:  "enums:\n  Kind:\n    type: uint8\n    values:\n      - {name: Low, value: -1}\n" + field("type: uint8, enum: Kind"),
+			wantLog: "enum 'Kind' value 'Low' (-1) does not fit in uint8",
+		},
+		{
+			name:    "enum named like a struct",
+			source:  "enums:\n  A:\n    type: uint8\n    values:\n      - {name: Low, value: 1}\n      - {name: High, value: 2}\n" + field("type: uint8"),
+			wantLog: "enum 'A' has the name of a generated struct type",
+		},
+		{
+			name:    "enum constant named like a struct",
+			source:  "enums:\n  Kind:\n    type: uint8\n    values:\n      - {name: A, value: 1}\nstructs:\n  KindA:\n    fields:\n      - {name: X, type: uint8}\n",
+			wantLog: "enum 'Kind' value 'A' generates constant 'KindA', which is the name of a struct",
+		},
+		{name: "bit fields", source: field("type: uint16, bits: [{name: High, bits: 8-15}, {name: Low, bits: 0-3}, {name: Flag, bits: 4}]")},
+		{
+			name:    "bit fields of a signed field",
+			source:  field("type: int8, bits: [{name: High, bits: 4-7}]"),
+			wantLog: "field 'X' of type 'int8' has 'bits'. Bit fields need an unsigned integer type",
+		},
+		{
+			name:    "bit fields of a repeated field",
+			source:  field("type: \"[]uint8\", count: 2, bits: [{name: High, bits: 4-7}]"),
+			wantLog: "field 'X' of type '[]uint8' has 'bits'",
+		},
+		{
+			name:    "bit field with an invalid name",
+			source:  field("type: uint8, bits: [{name: high-nibble, bits: 4-7}]"),
+			wantLog: "field 'X' has a bit field with invalid name 'high-nibble'",
+		},
+		{
+			name:    "bit field named like a field",
+			source:  field("type: uint8, bits: [{name: Num, bits: 4-7}]"),
+			wantLog: "field 'X' has bit field 'Num', but 'Num' is already a field of the struct",
+		},
+		{
+			name:    "bit field declared twice",
+			source:  field("type: uint8, bits: [{name: High, bits: 4-7}, {name: High, bits: 0-3}]"),
+			wantLog: "field 'X' has bit field 'High', but 'High' is already a bit field accessor of the struct",
+		},
+		{
+			name:    "bit field whose setter is a field",
+			source:  "structs:\n  A:\n    fields:\n      - {name: SetHigh, type: uint8}\n      - {name: X, type: uint8, bits: [{name: High, bits: 4-7}]}\n",
+			wantLog: "field 'X' has bit field 'High', but 'SetHigh' is already a field of the struct",
+		},
+		{
+			name:    "bit field named like a method",
+			source:  field("type: uint8, bits: [{name: Read, bits: 4-7}]"),
+			wantLog: "but 'Read' is already a method of the struct",
+		},
+		{
+			name:    "invalid bit range",
+			source:  field("type: uint8, bits: [{name: High, bits: high}]"),
+			wantLog: "field 'X' bit field 'High': invalid bit range 'high'",
+		},
+		{
+			name:    "bit range outside the field",
+			source:  field("type: uint8, bits: [{name: High, bits: 4-8}]"),
+			wantLog: "field 'X' bit field 'High' has bits '4-8', outside the 8 bits of uint8",
+		},
+		{
+			name:    "overlapping bit fields",
+			source:  field("type: uint8, bits: [{name: High, bits: 4-7}, {name: Low, bits: 0-4}]"),
+			wantLog: "field 'X' bit field 'Low' overlaps another bit field",
+		},
+		{name: "constant value of a strict enum", source: "enums:\n  Kind:\n    type: uint8\n    strict: true\n    values:\n      - {name: Low, value: 1}\n" + field("enum: Kind, value: 1")},
+		{
+			name:    "value and magic",
+			source:  field("type: uint8, value: 1, magic: 2"),
+			wantLog: "field 'X' has both 'value: 1' and 'magic: 2'",
+		},
+		{
+			name:    "value that doesn't fit",
+			source:  field("type: uint8, value: 256"),
+			wantLog: "field 'X': value '256' is not a valid uint8",
+		},
+		{
+			name:    "value of a repeated field",
+			source:  field("type: \"[]uint8\", count: 2, value: 1"),
+			wantLog: "field 'X' of type '[]uint8' has a 'value'",
+		},
+		{
+			name:    "value of a nested struct",
+			source:  "structs:\n  A:\n    fields:\n      - {name: X, type: B, value: 1}\n  B:\n    fields:\n      - {name: Y, type: uint8}\n",
+			wantLog: "field 'X' of type 'B' has a 'value'",
+		},
+		{
+			name:    "value outside a strict enum",
+			source:  "enums:\n  Kind:\n    type: uint8\n    strict: true\n    values:\n      - {name: Low, value: 1}\n" + field("enum: Kind, value: 2"),
+			wantLog: "field 'X' has 'value: 2', which is not a value of strict enum 'Kind'",
+		},
+		{
+			name:    "value of an encoded string",
+			source:  field("type: string, encoding: cstring, value: BM"),
+			wantLog: "field 'X' has both a 'value' and 'encoding: cstring'",
+		},
+		{
+			name:    "value of another length",
+			source:  field("type: string, length: 4, value: BM"),
+			wantLog: "field 'X' has a 2-byte 'value' but 'length: 4'",
+		},
+		{
+			name:    "invalid hex value",
+			source:  field("type: \"[]byte\", value: \"0xZZ\""),
+			wantLog: "field 'X': value '0xZZ' is not a valid hex byte string",
+		},
+		{
+			name:    "misspelled key",
+			source:  field("type: uint8, lenght: 2"),
+			wantLog: "test.yml:5:32: Validation error: unknown key 'lenght' in structs.A.fields[X]. Did you mean 'length'?",
+		},
+		{
+			name:    "unknown top-level key",
+			source:  "extra: 1\n" + field("type: uint8"),
+			wantLog: "test.yml:1:1: Validation error: unknown key 'extra' at the top level.",
+		},
+		{
+			name:    "count on []byte",
+			source:  field("type: \"[]byte\", count: 4"),
+			wantLog: "field 'X' of type '[]byte' cannot have a 'Count'",
+		},
+		{
+			name:    "count on a non-slice",
+			source:  field("type: uint16, count: 4"),
+			wantLog: "field 'X' has a 'Count' but type 'uint16' is not a slice",
+		},
+		{
+			name:    "slice without count",
+			source:  field("type: \"[]uint16\""),
+			wantLog: "requires a 'Count' specification",
+		},
+		{
+			name:    "unsupported element type",
+			source:  field("type: \"[]string\", count: 2"),
+			wantLog: "unsupported element type 'string'",
+		},
+		{
+			name:    "zero count",
+			source:  field("type: \"[]uint16\", count: 0"),
+			wantLog: "invalid non-positive integer 'Count: 0'",
+		},
+		{
+			name:    "unparsable count",
+			source:  field("type: \"[]uint16\", count: \"s.Num +\""),
+			wantLog: "test.yml:5:37: Validation error in struct 'A': field 'X' count: cannot parse expression 's.Num +'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, logs, err := validate(t, tt.source)
+			if tt.wantLog == "" {
+				if err != nil {
+					t.Errorf("ValidateAndReformYAML() error = %v\n%s", err, logs)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(logs, tt.wantLog) {
+				t.Errorf("ValidateAndReformYAML() error = %v, want an error logging %q:\n%s", err, tt.wantLog, logs)
+			}
+		})
+	}
+}
+
+func TestValidateKeepsStructOrder(t *testing.T) {
+	source := "structs:\n  Zeta:\n    fields:\n      - {name: A, type: Mid}\n  Alpha:\n    fields:\n      - {name: B, type: uint8}\n  Mid:\n    fields:\n      - {name: C, type: uint8}\n"
+	reformed, logs, err := validate(t, source)
+	if err != nil {
+		t.Fatalf("ValidateAndReformYAML() error = %v\n%s", err, logs)
+	}
+	zeta, alpha, mid := strings.Index(reformed, "Zeta:"), strings.Index(reformed, "Alpha:"), strings.Index(reformed, "Mid:")
+	if zeta < 0 || !(zeta < alpha && alpha < mid) {
+		t.Errorf("reformed YAML doesn't keep the declaration order:\n%s", reformed)
+	}
+}
+
+func TestValidateYAMLWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "test.yml")
+	if err := ioutil.WriteFile(sourcePath, []byte("endian: BE\nstructs:\n  A:\n    fields:\n      - {name: X, type: uint16}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logger := log.Writer()
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(logger)
+	reformed, _, err := ValidateYAML(sourcePath, ValidationOptions{})
+	if err != nil {
+		t.Fatalf("ValidateYAML() error = %v", err)
+	}
+	if !strings.Contains(string(reformed), "endian: big") {
+		t.Errorf("ValidateYAML() = %s, want the reformed YAML", reformed)
+	}
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("ValidateYAML() wrote files: %v, %v", entries, err)
+	}
+}
+
+func TestValidateAllowUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "test.yml")
+	if err := ioutil.WriteFile(sourcePath, []byte("structs:\n  A:\n    fields:\n      - {name: X, type: uint8, lenght: 2}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	logger := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(logger)
+	reformed, _, err := ValidateYAML(sourcePath, ValidationOptions{AllowUnknownKeys: true})
+	if err != nil {
+		t.Fatalf("ValidateYAML() error = %v\n%s", err, logs.String())
+	}
+	if !strings.Contains(logs.String(), "Warning: "+sourcePath+":4:32: Unknown key 'lenght'") {
+		t.Errorf("ValidateYAML() didn't warn about the unknown key:\n%s", logs.String())
+	}
+	if strings.Contains(string(reformed), "lenght") {
+		t.Errorf("ValidateYAML() kept the unknown key:\n%s", reformed)
+	}
+}
+
+func TestValidateYAMLSourceReformations(t *testing.T) {
+	tests := []struct {
+		name             string
+		source           string
+		wantReformed     []string // Substrings of the reformed YAML
+		wantReformations int
+	}{
+		{
+			name:             "nothing to reform",
+			source:           "structs:\n  A:\n    fields:\n      - {name: X, type: uint16, endian: big}\n",
+			wantReformed:     []string{"endian: big"},
+			wantReformations: 0,
+		},
+		{
+			name:             "key case",
+			source:           "structs:\n  A:\n    Fields:\n      - {Name: X, TYPE: uint16}\n",
+			wantReformed:     []string{"- name: X\n      type: uint16"},
+			wantReformations: 0,
+		},
+		{
+			name:             "endian",
+			source:           "endian: BIG\nstructs:\n  A:\n    fields:\n      - {name: X, type: uint16, endian: Little}\n",
+			wantReformed:     []string{"\nendian: big\n", "      endian: little\n"},
+			wantReformations: 2,
+		},
+		{
+			name:             "expression mode",
+			source:           "expressions: Native\nstructs:\n  A:\n    fields:\n      - {name: X, type: uint8}\n",
+			wantReformed:     []string{"expressions: native"},
+			wantReformations: 1,
+		},
+		{
+			name:             "encodings",
+			source:           "structs:\n  A:\n    fields:\n      - {name: S, type: string, encoding: Pascal}\n      - {name: C, type: string, encoding: CString}\n",
+			wantReformed:     []string{"encoding: pascal8", "encoding: cstring"},
+			wantReformations: 2,
+		},
+		{
+			name:             "magic and lengths from values",
+			source:           "structs:\n  A:\n    fields:\n      - {name: M, type: \"[]byte\", magic: \"0x8950\"}\n      - {name: T, type: string, value: BM}\n",
+			wantReformed:     []string{"value: \"0x8950\"", "length: \"2\"\n      value: BM"},
+			wantReformations: 3,
+		},
+		{
+			name:             "pad and numeric value",
+			source:           "structs:\n  A:\n    fields:\n      - {name: P, type: string, length: 4, pad: \" \"}\n      - {name: V, type: uint8, value: \"0x0A\"}\n",
+			wantReformed:     []string{"pad: \"0x20\"", "value: \"10\""},
+			wantReformations: 2,
+		},
+		{
+			name:             "type from enum",
+			source:           "enums:\n  E:\n    type: uint8\n    values:\n      - {name: One, value: 1}\nstructs:\n  A:\n    fields:\n      - {name: K, enum: E}\n",
+			wantReformed:     []string{"type: uint8\n      description: \"\"\n      enum: E"},
+			wantReformations: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			reformed, diagnostics, err := ValidateYAMLSource("test.yml", []byte(tt.source), ValidationOptions{Logger: log.New(&logs, "", 0)})
+			if err != nil {
+				t.Fatalf("ValidateYAMLSource() error = %v\n%s", err, logs.String())
+			}
+			for _, want := range tt.wantReformed {
+				if !strings.Contains(string(reformed), want) {
+					t.Errorf("reformed YAML doesn't contain %q:\n%s", want, reformed)
+				}
+			}
+
+			summary := "No value reformations needed"
+			if tt.wantReformations > 0 {
+				summary = fmt.Sprintf("Made %d value reformation(s)", tt.wantReformations)
+			}
+			if !strings.Contains(logs.String(), summary) {
+				t.Errorf("log doesn't contain %q:\n%s", summary, logs.String())
+			}
+			infos := 0
+			for _, d := range diagnostics {
+				if d.Severity != SeverityInfo || !strings.HasPrefix(d.Message, "Reforming") {
+					t.Errorf("unexpected diagnostic %s", d)
+					continue
+				}
+				infos++
+			}
+			if infos != tt.wantReformations {
+				t.Errorf("got %d reformation diagnostic(s), want %d", infos, tt.wantReformations)
+			}
+
+			// Reformed YAML is stable: validating it again reforms nothing
+			logs.Reset()
+			again, _, err := ValidateYAMLSource("test.yml", reformed, ValidationOptions{Logger: log.New(&logs, "", 0)})
+			if err != nil {
+				t.Fatalf("validating the reformed YAML: %v", err)
+			}
+			if !bytes.Equal(again, reformed) || !strings.Contains(logs.String(), "No value reformations needed") {
+				t.Errorf("validating the reformed YAML reformed it again:\n%s\n%s", again, logs.String())
+			}
+		})
+	}
+}
+
+func TestValidateYAMLSourceErrors(t *testing.T) {
+	field := func(attributes string) string {
+		return "structs:\n  A:\n    fields:\n      - {name: X, " + attributes + "}\n"
+	}
+	tests := []struct {
+		name     string
+		source   string
+		wantDiag string // Substring of the error diagnostic
+		wantLine int
+	}{
+		{"syntax", "structs: [\n", "", 0},
+		{"unknown key", field("type: uint8, lenght: 2"), "unknown key 'lenght' in structs.A.fields[X]. Did you mean 'length'?", 4},
+		{"unknown type", field("type: float128"), "field 'X' has unknown type 'float128'", 4},
+		{"unparsable expression", field("type: \"[]byte\", length: \"s.Y +\""), "cannot parse expression 's.Y +'", 4},
+		{"unknown field in expression", field("type: \"[]byte\", length: \"s.Missing\""), "struct 'A' has no field 'Missing'", 4},
+		{"unknown function", field("type: \"[]byte\", length: \"foo(s.X)\""), "Undefined function foo", 4},
+		{"value out of range", field("type: uint8, value: 300"), "value '300' is not a valid uint8", 4},
+		{"endian", field("type: uint8, endian: middle"), "invalid endian 'middle'", 4},
+		{"encoding", field("type: string, encoding: utf9"), "invalid encoding 'utf9'", 4},
+		{"layout", "layout: [Nope]\n" + field("type: uint8"), "entry 'Nope' is not a struct defined in this file", 1},
+		{"cycle", "structs:\n  A:\n    fields:\n      - {name: X, type: B}\n  B:\n    fields:\n      - {name: Y, type: A}\n", "structs contain themselves by value: A -> B -> A", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reformed, diagnostics, err := ValidateYAMLSource("test.yml", []byte(tt.source), ValidationOptions{Logger: log.New(&bytes.Buffer{}, "", 0)})
+			if err == nil {
+				t.Fatalf("ValidateYAMLSource() succeeded:\n%s", reformed)
+			}
+			if reformed != nil {
+				t.Errorf("ValidateYAMLSource() returned reformed YAML along with error %v", err)
+			}
+			var found bool
+			for _, d := range diagnostics {
+				if d.Severity == SeverityError && strings.Contains(d.Message, tt.wantDiag) {
+					found = true
+					if d.File != "test.yml" || tt.wantLine != 0 && d.Line != tt.wantLine {
+						t.Errorf("diagnostic at %s, want test.yml:%d", d.Location(), tt.wantLine)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("no error diagnostic containing %q in %v", tt.wantDiag, diagnostics)
+			}
+		})
+	}
+}
+
+func TestValidateYAMLSourceAllowUnknownKeys(t *testing.T) {
+	source := "structs:\n  A:\n    fields:\n      - {name: X, type: uint8, lenght: 2}\n"
+	reformed, diagnostics, err := ValidateYAMLSource("test.yml", []byte(source), ValidationOptions{AllowUnknownKeys: true, Logger: log.New(&bytes.Buffer{}, "", 0)})
+	if err != nil {
+		t.Fatalf("ValidateYAMLSource() error = %v", err)
+	}
+	if strings.Contains(string(reformed), "lenght") {
+		t.Errorf("reformed YAML keeps the unknown key:\n%s", reformed)
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Severity != SeverityWarning {
+		t.Errorf("diagnostics = %v, want one warning", diagnostics)
+	}
+}